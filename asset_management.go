@@ -1,51 +1,367 @@
 package tupa
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"mime/multipart"
 	"net/http"
+	"net/textproto"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
-func UploadFile(tc *TupaContext, filePrefix, destFolder, formFileKey string) (multipart.FileHeader, error) {
-	tc.Request().ParseMultipartForm(10 << 20)
+// FileStorage é onde o Upload grava os arquivos. DiskStorage grava em disco, mas qualquer
+// backend ( S3, GCS, banco ) pode ser usado implementando essa interface
+type FileStorage interface {
+	// Save grava o conteúdo com o nome já sanitizado e retorna a localização final do arquivo
+	Save(ctx context.Context, name string, r io.Reader) (string, error)
+	// Delete remove um arquivo salvo, usado para desfazer o upload quando algo dá errado no meio
+	Delete(ctx context.Context, location string) error
+}
+
+type DiskStorage struct {
+	Dir string
+}
 
-	file, fileHeader, err := tc.Request().FormFile(formFileKey)
+func (d DiskStorage) Save(ctx context.Context, name string, r io.Reader) (string, error) {
+	destPath := filepath.Join(d.Dir, name)
+	// O_EXCL para nunca sobrescrever um arquivo que já existe
+	destFile, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
 	if err != nil {
-		fmt.Println("Erro ao retornar o arquivo")
-		fmt.Println(err)
-		return multipart.FileHeader{}, err
+		return "", err
 	}
 
-	randStr, err := GenerateRandomStringHelper(6)
+	// copia o arquivo do upload para o arquivo criado no SO
+	if _, err := io.Copy(destFile, r); err != nil {
+		destFile.Close()
+		os.Remove(destPath)
+		return "", err
+	}
+
+	if err := destFile.Close(); err != nil {
+		os.Remove(destPath)
+		return "", err
+	}
+	return destPath, nil
+}
+
+func (d DiskStorage) Delete(ctx context.Context, location string) error {
+	return os.Remove(location)
+}
+
+type UploadConfig struct {
+	Storage FileStorage
+	// FieldNames limita quais campos do form podem ter arquivo. Vazio aceita qualquer campo
+	FieldNames []string
+	// MaxFileSize é o tamanho máximo de cada arquivo. 0 usa 10 MB
+	MaxFileSize int64
+	// MaxFiles é a quantidade máxima de arquivos na request. 0 não limita
+	MaxFiles int
+	// MaxFieldSize é o tamanho máximo de cada campo que não é arquivo. 0 usa 1 MB
+	MaxFieldSize int64
+	// AllowedTypes são os MIME types aceitos, detectados pelo conteúdo do arquivo e não pelo que o
+	// cliente diz. Aceita prefixos terminando em '/', ex: "image/". Vazio aceita qualquer tipo
+	AllowedTypes []string
+	// FilePrefix vai no começo do nome salvo, antes de um trecho aleatório e do nome original sanitizado
+	FilePrefix string
+	// FileName monta o nome salvo a partir do trecho aleatório e do nome original já sanitizado.
+	// Nulo usa FilePrefix_aleatório_original
+	FileName func(random, original string) string
+	// DiscardExtraFiles descarta os arquivos de campos fora de FieldNames ou além de MaxFiles,
+	// em vez de recusar a request com 400
+	DiscardExtraFiles bool
+}
+
+type UploadedFile struct {
+	Field        string
+	OriginalName string
+	Name         string
+	Location     string
+	ContentType  string
+	Size         int64
+}
+
+type UploadResult struct {
+	Files  []UploadedFile
+	Values map[string][]string
+}
+
+// Upload lê o multipart da request em streaming, sem carregar os arquivos em memória ou em arquivos
+// temporários. Cada arquivo tem o tipo detectado pelo conteúdo, o nome sanitizado e é gravado no
+// Storage configurado. Não escreve nada na response, responder fica por conta do handler.
+// Se qualquer arquivo falhar, os que já foram gravados são removidos. Os campos que não são arquivo
+// também vão para tc.Req.Form, PostForm e MultipartForm, então tc.Req.FormValue continua funcionando
+func (tc *TupaContext) Upload(cfg UploadConfig) (*UploadResult, error) {
+	if cfg.Storage == nil {
+		return nil, errors.New("UploadConfig.Storage não pode ser nulo")
+	}
+	if cfg.MaxFileSize == 0 {
+		cfg.MaxFileSize = 10 << 20
+	}
+	if cfg.MaxFieldSize == 0 {
+		cfg.MaxFieldSize = 1 << 20
+	}
+
+	reader, err := tc.Request().MultipartReader()
 	if err != nil {
-		return multipart.FileHeader{}, err
+		return nil, APIHandlerErr{Status: http.StatusBadRequest, Msg: "Requisição não é multipart: " + err.Error()}
+	}
+
+	ctx := tc.Request().Context()
+	result := &UploadResult{Values: map[string][]string{}}
+
+	rollback := func() {
+		for _, f := range result.Files {
+			cfg.Storage.Delete(ctx, f.Location)
+		}
 	}
-	fileHeader.Filename = filePrefix + "_" + randStr + fileHeader.Filename
 
-	defer file.Close()
-	// fmt.Printf("Uploaded File: %+v\n", fileHeader.Filename)
-	// fmt.Printf("File Size: %+v\n", fileHeader.Size)
-	// fmt.Printf("MIME Header: %+v\n", fileHeader.Header)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			rollback()
+			return nil, uploadReadErr(err)
+		}
 
-	destPath := filepath.Join(destFolder, fileHeader.Filename)
-	destFile, err := os.Create(destPath)
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, cfg.MaxFieldSize+1))
+			part.Close()
+			if err != nil {
+				rollback()
+				return nil, uploadReadErr(err)
+			}
+			if int64(len(value)) > cfg.MaxFieldSize {
+				rollback()
+				return nil, APIHandlerErr{Status: http.StatusRequestEntityTooLarge, Msg: "Campo " + part.FormName() + " maior que o permitido"}
+			}
+			result.Values[part.FormName()] = append(result.Values[part.FormName()], string(value))
+			continue
+		}
+
+		if cfg.DiscardExtraFiles && !uploadFieldAccepted(cfg, part.FormName(), len(result.Files)) {
+			part.Close()
+			continue
+		}
+
+		file, err := saveUploadPart(ctx, part, cfg, len(result.Files))
+		part.Close()
+		if err != nil {
+			rollback()
+			return nil, err
+		}
+		result.Files = append(result.Files, file)
+	}
+
+	setUploadForm(tc.Request(), result.Values)
+	return result, nil
+}
+
+func uploadFieldAccepted(cfg UploadConfig, field string, filesSoFar int) bool {
+	if len(cfg.FieldNames) > 0 && !containsString(cfg.FieldNames, field) {
+		return false
+	}
+	return cfg.MaxFiles <= 0 || filesSoFar < cfg.MaxFiles
+}
+
+// setUploadForm deixa os valores lidos no mesmo lugar que ParseMultipartForm deixaria, já que o
+// corpo da request foi consumido pelo Upload
+func setUploadForm(r *http.Request, values map[string][]string) {
+	postForm := url.Values(values)
+	form := make(url.Values, len(postForm))
+	for k, v := range r.URL.Query() {
+		form[k] = v
+	}
+	for k, v := range postForm {
+		form[k] = append(append([]string(nil), v...), form[k]...)
+	}
+	r.PostForm = postForm
+	r.Form = form
+	r.MultipartForm = &multipart.Form{Value: values, File: map[string][]*multipart.FileHeader{}}
+}
+
+func saveUploadPart(ctx context.Context, part *multipart.Part, cfg UploadConfig, filesSoFar int) (UploadedFile, error) {
+	field := part.FormName()
+	if len(cfg.FieldNames) > 0 && !containsString(cfg.FieldNames, field) {
+		return UploadedFile{}, APIHandlerErr{Status: http.StatusBadRequest, Msg: "Campo de arquivo não esperado: " + field}
+	}
+	if cfg.MaxFiles > 0 && filesSoFar >= cfg.MaxFiles {
+		return UploadedFile{}, APIHandlerErr{Status: http.StatusBadRequest, Msg: fmt.Sprintf("Máximo de %d arquivos por requisição", cfg.MaxFiles)}
+	}
+
+	// os primeiros 512 bytes são suficientes para http.DetectContentType
+	head := make([]byte, 512)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return UploadedFile{}, uploadReadErr(err)
+	}
+	head = head[:n]
+
+	contentType := http.DetectContentType(head)
+	if !mimeAllowed(contentType, cfg.AllowedTypes) {
+		return UploadedFile{}, APIHandlerErr{Status: http.StatusUnsupportedMediaType, Msg: "Tipo de arquivo não permitido: " + contentType}
+	}
+
+	randStr, err := GenerateRandomStringHelper(6)
 	if err != nil {
-		return multipart.FileHeader{}, err
+		return UploadedFile{}, err
+	}
+	name := randStr + "_" + SanitizeFileName(part.FileName())
+	switch {
+	case cfg.FileName != nil:
+		name = cfg.FileName(randStr, SanitizeFileName(part.FileName()))
+	case cfg.FilePrefix != "":
+		name = SanitizeFileName(cfg.FilePrefix) + "_" + name
 	}
 
-	defer destFile.Close()
-	// if err := os.Chmod(destPath, 0777); err != nil {
-	// 	return multipart.FileHeader{}, WriteJSONHelper(tc.Resp, http.StatusInternalServerError, err.Error())
-	// }
+	body := &sizeLimitedReader{r: io.MultiReader(bytes.NewReader(head), part), remain: cfg.MaxFileSize}
+	location, err := cfg.Storage.Save(ctx, name, body)
+	if err != nil {
+		if body.exceeded {
+			return UploadedFile{}, APIHandlerErr{
+				Status: http.StatusRequestEntityTooLarge,
+				Msg:    fmt.Sprintf("Arquivo %s maior que o limite de %d bytes", part.FileName(), cfg.MaxFileSize),
+			}
+		}
+		return UploadedFile{}, uploadReadErr(err)
+	}
 
-	// copia o arquivo do upload para o arquivo criado no SO
-	if _, err := io.Copy(destFile, file); err != nil {
-		return multipart.FileHeader{}, WriteJSONHelper(tc.Resp, http.StatusInternalServerError, err.Error())
+	return UploadedFile{
+		Field:        field,
+		OriginalName: part.FileName(),
+		Name:         name,
+		Location:     location,
+		ContentType:  contentType,
+		Size:         body.read,
+	}, nil
+}
+
+// uploadReadErr mantém o 413 do BodyLimit e trata o resto como multipart mal formado
+func uploadReadErr(err error) error {
+	var apiErr APIHandlerErr
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &apiErr) || errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return APIHandlerErr{Status: http.StatusBadRequest, Msg: "Erro ao ler o upload: " + err.Error()}
+}
+
+// sizeLimitedReader retorna erro assim que passa do limite, para que o Storage aborte a gravação
+type sizeLimitedReader struct {
+	r        io.Reader
+	remain   int64
+	read     int64
+	exceeded bool
+}
+
+var errFileTooLarge = errors.New("arquivo maior que o limite permitido")
+
+func (s *sizeLimitedReader) Read(p []byte) (int, error) {
+	if s.remain < 0 {
+		s.exceeded = true
+		return 0, errFileTooLarge
+	}
+	// lê até um byte além do limite para descobrir se o arquivo passou dele
+	if int64(len(p)) > s.remain+1 {
+		p = p[:s.remain+1]
+	}
+	n, err := s.r.Read(p)
+	s.read += int64(n)
+	s.remain -= int64(n)
+	if s.remain < 0 {
+		s.exceeded = true
+		return n, errFileTooLarge
+	}
+	return n, err
+}
+
+func mimeAllowed(contentType string, allowed []string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.TrimSpace(mediaType)
+	for _, a := range allowed {
+		if strings.HasSuffix(a, "/") && strings.HasPrefix(mediaType, a) {
+			return true
+		}
+		if strings.EqualFold(mediaType, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// SanitizeFileName remove qualquer caminho do nome enviado pelo cliente ( evita path traversal como
+// "../../etc/passwd" ) e troca caracteres fora de [A-Za-z0-9._-] por '_'
+func SanitizeFileName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	name = filepath.Base(name)
+
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+
+	sanitized := strings.TrimLeft(b.String(), ".")
+	if len(sanitized) > 100 {
+		sanitized = sanitized[len(sanitized)-100:]
+	}
+	if sanitized == "" {
+		sanitized = "arquivo"
+	}
+	return sanitized
+}
+
+// UploadFile salva o primeiro arquivo do campo formFileKey em destFolder como
+// filePrefix_<aleatório><nome original>. Arquivos de outros campos ou além do primeiro são ignorados,
+// como antes, e os outros campos do form continuam em tc.Req.FormValue.
+//
+// Deprecated: use tc.Upload, que permite vários arquivos, limites de tamanho e tipo e outros Storages.
+// UploadFile agora só embrulha tc.Upload: não escreve mais nada na response e o nome original é
+// sanitizado ( SanitizeFileName ), então caminhos como "../x" não saem de destFolder
+func UploadFile(tc *TupaContext, filePrefix, destFolder, formFileKey string) (multipart.FileHeader, error) {
+	result, err := tc.Upload(UploadConfig{
+		Storage:           DiskStorage{Dir: destFolder},
+		FieldNames:        []string{formFileKey},
+		MaxFiles:          1,
+		DiscardExtraFiles: true,
+		FileName: func(random, original string) string {
+			return filePrefix + "_" + random + original
+		},
+	})
+	if err != nil {
+		return multipart.FileHeader{}, err
+	}
+	if len(result.Files) == 0 {
+		return multipart.FileHeader{}, http.ErrMissingFile
 	}
 
-	fmt.Fprint(tc.Resp, "Arquivo salvo com sucesso\n")
-	return *fileHeader, nil
+	file := result.Files[0]
+	return multipart.FileHeader{
+		Filename: file.Name,
+		Size:     file.Size,
+		Header:   textproto.MIMEHeader{"Content-Type": {file.ContentType}},
+	}, nil
 }
@@ -0,0 +1,194 @@
+package tupa
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// pngHeader é suficiente para http.DetectContentType reconhecer image/png
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+type uploadPart struct {
+	field, filename string
+	content         []byte
+}
+
+func newMultipartRequest(t *testing.T, parts []uploadPart, values map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for k, v := range values {
+		mw.WriteField(k, v)
+	}
+	for _, p := range parts {
+		fw, err := mw.CreateFormFile(p.field, p.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fw.Write(p.content)
+	}
+	mw.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+func TestUpload(t *testing.T) {
+	t.Run("Teste Upload com vários arquivos", func(t *testing.T) {
+		dir := t.TempDir()
+		req := newMultipartRequest(t, []uploadPart{
+			{"fotos", "../../etc/passwd.png", pngHeader},
+			{"fotos", "minha foto.png", pngHeader},
+		}, map[string]string{"album": "férias"})
+		w := httptest.NewRecorder()
+		tc := &TupaContext{Req: req, Resp: w}
+
+		result, err := tc.Upload(UploadConfig{
+			Storage:      DiskStorage{Dir: dir},
+			AllowedTypes: []string{"image/"},
+			FilePrefix:   "tupa",
+		})
+		if err != nil {
+			t.Fatalf("erro inesperado: %v", err)
+		}
+
+		if len(result.Files) != 2 {
+			t.Fatalf("esperava 2 arquivos, recebeu %d", len(result.Files))
+		}
+		if got := result.Values["album"]; len(got) != 1 || got[0] != "férias" {
+			t.Errorf("campo album recebido %v", got)
+		}
+		if got := tc.Req.FormValue("album"); got != "férias" {
+			t.Errorf("FormValue(album) = %q, queria %q", got, "férias")
+		}
+		for _, f := range result.Files {
+			if filepath.Dir(f.Location) != dir {
+				t.Errorf("arquivo salvo fora da pasta de destino: %s", f.Location)
+			}
+			if !strings.HasPrefix(f.Name, "tupa_") {
+				t.Errorf("nome sem prefixo: %s", f.Name)
+			}
+			if f.ContentType != "image/png" {
+				t.Errorf("Content-Type recebido %s, queria image/png", f.ContentType)
+			}
+			if _, err := os.Stat(f.Location); err != nil {
+				t.Errorf("arquivo não foi salvo: %v", err)
+			}
+		}
+
+		if w.Body.Len() != 0 {
+			t.Errorf("Upload não deveria escrever na response, escreveu %q", w.Body.String())
+		}
+	})
+
+	t.Run("Teste Upload com tipo não permitido", func(t *testing.T) {
+		dir := t.TempDir()
+		req := newMultipartRequest(t, []uploadPart{
+			{"arquivo", "foto.png", pngHeader},
+			{"arquivo", "script.png", []byte("#!/bin/sh\necho oi")},
+		}, nil)
+		tc := &TupaContext{Req: req, Resp: httptest.NewRecorder()}
+
+		_, err := tc.Upload(UploadConfig{
+			Storage:      DiskStorage{Dir: dir},
+			AllowedTypes: []string{"image/png"},
+		})
+		apiErr, ok := err.(APIHandlerErr)
+		if !ok || apiErr.Status != http.StatusUnsupportedMediaType {
+			t.Fatalf("esperava erro 415, recebeu %v", err)
+		}
+
+		// o primeiro arquivo, que era válido, deve ter sido removido
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 0 {
+			t.Errorf("esperava pasta vazia depois do rollback, tem %d arquivos", len(entries))
+		}
+	})
+
+	t.Run("Teste Upload com arquivo maior que o limite", func(t *testing.T) {
+		dir := t.TempDir()
+		req := newMultipartRequest(t, []uploadPart{
+			{"arquivo", "grande.txt", bytes.Repeat([]byte("a"), 2048)},
+		}, nil)
+		tc := &TupaContext{Req: req, Resp: httptest.NewRecorder()}
+
+		_, err := tc.Upload(UploadConfig{Storage: DiskStorage{Dir: dir}, MaxFileSize: 1024})
+		apiErr, ok := err.(APIHandlerErr)
+		if !ok || apiErr.Status != http.StatusRequestEntityTooLarge {
+			t.Fatalf("esperava erro 413, recebeu %v", err)
+		}
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 0 {
+			t.Errorf("arquivo parcial não foi removido")
+		}
+	})
+
+	t.Run("Teste Upload com arquivos demais", func(t *testing.T) {
+		req := newMultipartRequest(t, []uploadPart{
+			{"arquivo", "a.txt", []byte("a")},
+			{"arquivo", "b.txt", []byte("b")},
+		}, nil)
+		tc := &TupaContext{Req: req, Resp: httptest.NewRecorder()}
+
+		_, err := tc.Upload(UploadConfig{Storage: DiskStorage{Dir: t.TempDir()}, MaxFiles: 1})
+		apiErr, ok := err.(APIHandlerErr)
+		if !ok || apiErr.Status != http.StatusBadRequest {
+			t.Fatalf("esperava erro 400, recebeu %v", err)
+		}
+	})
+}
+
+func TestUploadFile(t *testing.T) {
+	dir := t.TempDir()
+	req := newMultipartRequest(t, []uploadPart{
+		{"avatar", "outro.png", pngHeader},
+		{"arquivo", "doc.txt", []byte("conteúdo")},
+		{"arquivo", "segundo.txt", []byte("ignorado")},
+	}, map[string]string{"descricao": "contrato"})
+	w := httptest.NewRecorder()
+	tc := &TupaContext{Req: req, Resp: w}
+
+	header, err := UploadFile(tc, "prefixo", dir, "arquivo")
+	if err != nil {
+		t.Fatalf("erro inesperado: %v", err)
+	}
+	// mesmo formato de antes: prefixo_ + 6 caracteres aleatórios + nome original
+	if !strings.HasPrefix(header.Filename, "prefixo_") || !strings.HasSuffix(header.Filename, "doc.txt") ||
+		len(header.Filename) != len("prefixo_")+6+len("doc.txt") {
+		t.Errorf("nome do arquivo inesperado: %s", header.Filename)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+		t.Errorf("esperava só o primeiro arquivo do campo salvo, recebeu %d", len(entries))
+	}
+	if got := tc.Req.FormValue("descricao"); got != "contrato" {
+		t.Errorf("FormValue depois do UploadFile = %q, queria %q", got, "contrato")
+	}
+	if w.Body.Len() != 0 {
+		t.Errorf("UploadFile não deveria escrever na response, escreveu %q", w.Body.String())
+	}
+}
+
+func TestSanitizeFileName(t *testing.T) {
+	tests := map[string]string{
+		"foto.png":             "foto.png",
+		"../../etc/passwd":     "passwd",
+		"..\\..\\windows\\win": "win",
+		"minha foto (1).jpg":   "minha_foto__1_.jpg",
+		".htaccess":            "htaccess",
+		"..":                   "arquivo",
+		"":                     "arquivo",
+	}
+	for input, want := range tests {
+		if got := SanitizeFileName(input); got != want {
+			t.Errorf("SanitizeFileName(%q) = %q, queria %q", input, got, want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package tupa
+
+import (
+	"compress/gzip"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// SetBodyLimit define o tamanho máximo, em bytes, do corpo das requests de todas as rotas.
+// Rotas com RouteInfo.BodyLimit diferente de 0 usam o próprio limite. Requests que passarem
+// do limite recebem 413
+func (a *APIServer) SetBodyLimit(limit int64) {
+	a.bodyLimit = limit
+}
+
+func (a *APIServer) effectiveBodyLimit(routeInfo RouteInfo) int64 {
+	if routeInfo.BodyLimit != 0 {
+		return routeInfo.BodyLimit
+	}
+	return a.bodyLimit
+}
+
+// DecompressRequest descomprime de forma transparente corpos com Content-Encoding: gzip.
+// maxDecompressed limita o tamanho depois de descomprimir ( protege contra gzip bombs ) e precisa ser
+// maior que zero, senão o processo é encerrado. O limite do corpo comprimido continua sendo o BodyLimit da rota
+func DecompressRequest(maxDecompressed int64) MiddlewareFunc {
+	if maxDecompressed <= 0 {
+		log.Fatalf("%s%d", FmtRed("DecompressRequest precisa de um limite maior que zero, recebeu: "), maxDecompressed)
+	}
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			encoding := strings.ToLower(strings.TrimSpace(tc.Req.Header.Get("Content-Encoding")))
+			switch encoding {
+			case "", "identity":
+				return next(tc)
+			case "gzip", "x-gzip":
+			default:
+				return APIHandlerErr{
+					Status: http.StatusUnsupportedMediaType,
+					Msg:    "Content-Encoding não suportado: " + encoding,
+				}
+			}
+
+			gr, err := gzip.NewReader(tc.Req.Body)
+			if err != nil {
+				// o corpo comprimido já estourou o BodyLimit, writeAPIError transforma em 413
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					return err
+				}
+				return APIHandlerErr{Status: http.StatusBadRequest, Msg: "Corpo gzip inválido"}
+			}
+
+			tc.Req.Body = &decompressedBody{
+				reader: gr,
+				raw:    tc.Req.Body,
+				remain: maxDecompressed,
+				limit:  maxDecompressed,
+			}
+			tc.Req.Header.Del("Content-Encoding")
+			tc.Req.Header.Del("Content-Length")
+			tc.Req.ContentLength = -1
+
+			return next(tc)
+		}
+	}
+}
+
+// decompressedBody funciona como http.MaxBytesReader, mas contando os bytes já descomprimidos
+type decompressedBody struct {
+	reader *gzip.Reader
+	raw    io.ReadCloser
+	remain int64
+	limit  int64
+}
+
+func (d *decompressedBody) Read(p []byte) (int, error) {
+	if d.remain <= 0 {
+		// lê um byte a mais para saber se o corpo terminou exatamente no limite
+		var one [1]byte
+		if n, _ := d.reader.Read(one[:]); n > 0 {
+			return 0, &http.MaxBytesError{Limit: d.limit}
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > d.remain {
+		p = p[:d.remain]
+	}
+	n, err := d.reader.Read(p)
+	d.remain -= int64(n)
+	return n, err
+}
+
+func (d *decompressedBody) Close() error {
+	d.reader.Close()
+	return d.raw.Close()
+}
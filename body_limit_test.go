@@ -0,0 +1,172 @@
+package tupa
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write([]byte(s))
+	gw.Close()
+	return buf.Bytes()
+}
+
+func TestBodyLimit(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	server.SetBodyLimit(16)
+
+	echo := func(tc *TupaContext) error {
+		body, err := io.ReadAll(tc.Req.Body)
+		if err != nil {
+			return err
+		}
+		return tc.SendString(string(body))
+	}
+
+	server.RegisterRoutes([]RouteInfo{
+		{Path: "/limit/global", Method: MethodPost, Handler: echo},
+		{Path: "/limit/route", Method: MethodPost, Handler: echo, BodyLimit: 4},
+		{Path: "/limit/none", Method: MethodPost, Handler: echo, BodyLimit: -1},
+		{
+			Path:   "/limit/json",
+			Method: MethodPost,
+			Handler: func(tc *TupaContext) error {
+				var v map[string]string
+				return json.NewDecoder(tc.Req.Body).Decode(&v)
+			},
+		},
+		{
+			Path:        "/limit/gzip",
+			Method:      MethodPost,
+			Handler:     echo,
+			BodyLimit:   -1,
+			Middlewares: []MiddlewareFunc{DecompressRequest(32)},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		chunked    bool
+		statusCode int
+	}{
+		{"dentro do limite global", "/limit/global", "pequeno", false, http.StatusOK},
+		{"acima do limite global", "/limit/global", strings.Repeat("a", 17), false, http.StatusRequestEntityTooLarge},
+		{"acima do limite global sem Content-Length", "/limit/global", strings.Repeat("a", 17), true, http.StatusRequestEntityTooLarge},
+		{"acima do limite da rota", "/limit/route", "12345", false, http.StatusRequestEntityTooLarge},
+		{"rota sem limite", "/limit/none", strings.Repeat("a", 100), false, http.StatusOK},
+		{"json acima do limite", "/limit/json", `{"nome": "tupã tupã tupã"}`, true, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+			if test.chunked {
+				req.ContentLength = -1
+			}
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, req)
+
+			if rr.Code != test.statusCode {
+				t.Errorf("status recebido %d, queria %d ( corpo: %s )", rr.Code, test.statusCode, rr.Body.String())
+			}
+		})
+	}
+
+	t.Run("Teste DecompressRequest com gzip", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/limit/gzip", bytes.NewReader(gzipBytes(t, "olá tupã")))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || rr.Body.String() != "olá tupã" {
+			t.Errorf("recebeu %d %q, queria 200 %q", rr.Code, rr.Body.String(), "olá tupã")
+		}
+	})
+
+	t.Run("Teste DecompressRequest acima do limite descomprimido", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/limit/gzip", bytes.NewReader(gzipBytes(t, strings.Repeat("a", 1000))))
+		req.Header.Set("Content-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+	})
+
+	t.Run("Teste DecompressRequest com encoding não suportado", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/limit/gzip", strings.NewReader("x"))
+		req.Header.Set("Content-Encoding", "br")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusUnsupportedMediaType)
+		}
+	})
+}
+
+// TestDecompressRequestLimit roda o próprio binário de teste, já que um limite inválido encerra o processo
+func TestBodyLimitMiddlewares(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	server.SetBodyLimit(4)
+	server.UseGlobalMiddlewares(SecureHeaders())
+
+	var routeMiddleware, handler bool
+	server.RegisterRoutes([]RouteInfo{{
+		Path:   "/limit/middlewares",
+		Method: MethodPost,
+		Middlewares: []MiddlewareFunc{func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				routeMiddleware = true
+				return next(tc)
+			}
+		}},
+		Handler: func(tc *TupaContext) error {
+			handler = true
+			return nil
+		},
+	}})
+
+	t.Run("Teste 413 pelo Content-Length passa pelos middlewares globais", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/limit/middlewares", strings.NewReader("grande demais")))
+
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusRequestEntityTooLarge)
+		}
+		if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("o 413 deveria ter os headers do middleware global, recebeu %v", rr.Header())
+		}
+		if routeMiddleware || handler {
+			t.Errorf("middleware da rota ( %v ) e handler ( %v ) não deveriam rodar", routeMiddleware, handler)
+		}
+	})
+}
+
+func TestDecompressRequestLimit(t *testing.T) {
+	if os.Getenv("TUPA_TEST_DECOMPRESS_LIMIT") == "1" {
+		DecompressRequest(0)
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestDecompressRequestLimit$")
+	cmd.Env = append(os.Environ(), "TUPA_TEST_DECOMPRESS_LIMIT=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "limite maior que zero") {
+		t.Errorf("esperava o processo encerrado, recebeu %v:\n%s", err, out)
+	}
+}
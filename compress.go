@@ -0,0 +1,375 @@
+package tupa
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressWriter é o writer devolvido por um Compressor. gzip.Writer, flate.Writer e o
+// brotli.Writer de github.com/andybalholm/brotli já satisfazem essa interface
+type CompressWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// Compressor permite plugar novos algoritmos de compressão ( ex: brotli ) no middleware Compress
+type Compressor interface {
+	// Encoding é o valor usado em Accept-Encoding / Content-Encoding, ex: "gzip"
+	Encoding() string
+	NewWriter(w io.Writer, level int) (CompressWriter, error)
+}
+
+type gzipCompressor struct {
+	pools sync.Map // nível -> *sync.Pool
+}
+
+// GzipCompressor retorna o Compressor gzip, reaproveitando os writers entre requests
+func GzipCompressor() Compressor { return &gzipCompressor{} }
+
+func (g *gzipCompressor) Encoding() string { return "gzip" }
+
+func (g *gzipCompressor) NewWriter(w io.Writer, level int) (CompressWriter, error) {
+	p, _ := g.pools.LoadOrStore(level, &sync.Pool{})
+	pool := p.(*sync.Pool)
+	if gz, ok := pool.Get().(*gzip.Writer); ok {
+		gz.Reset(w)
+		return &pooledWriter{CompressWriter: gz, release: func() { pool.Put(gz) }}, nil
+	}
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledWriter{CompressWriter: gz, release: func() { pool.Put(gz) }}, nil
+}
+
+type deflateCompressor struct {
+	pools sync.Map
+}
+
+// DeflateCompressor retorna o Compressor deflate, reaproveitando os writers entre requests
+func DeflateCompressor() Compressor { return &deflateCompressor{} }
+
+func (d *deflateCompressor) Encoding() string { return "deflate" }
+
+func (d *deflateCompressor) NewWriter(w io.Writer, level int) (CompressWriter, error) {
+	p, _ := d.pools.LoadOrStore(level, &sync.Pool{})
+	pool := p.(*sync.Pool)
+	if fw, ok := pool.Get().(*flate.Writer); ok {
+		fw.Reset(w)
+		return &pooledWriter{CompressWriter: fw, release: func() { pool.Put(fw) }}, nil
+	}
+	fw, err := flate.NewWriter(w, level)
+	if err != nil {
+		return nil, err
+	}
+	return &pooledWriter{CompressWriter: fw, release: func() { pool.Put(fw) }}, nil
+}
+
+// pooledWriter devolve o writer para o pool quando é fechado
+type pooledWriter struct {
+	CompressWriter
+	release func()
+}
+
+func (p *pooledWriter) Close() error {
+	err := p.CompressWriter.Close()
+	p.release()
+	return err
+}
+
+type CompressConfig struct {
+	// Level é repassado ao Compressor. Nulo usa o nível padrão ( -1 no gzip/flate ), então
+	// gzip.NoCompression ( 0 ) também pode ser escolhido
+	Level *int
+	// MinLength é o tamanho mínimo da resposta para valer a pena comprimir. 0 usa 1024 bytes
+	MinLength int
+	// Compressors em ordem de preferência do servidor, usada para desempatar o q-value do cliente
+	Compressors []Compressor
+	// SkipContentTypes são prefixos de Content-Type que já vem comprimidos e não devem ser comprimidos de novo
+	SkipContentTypes []string
+}
+
+func DefaultCompressConfig() CompressConfig {
+	level := gzip.DefaultCompression
+	return CompressConfig{
+		Level:       &level,
+		MinLength:   1024,
+		Compressors: []Compressor{GzipCompressor(), DeflateCompressor()},
+		SkipContentTypes: []string{
+			"image/", "video/", "audio/", "font/woff", "font/woff2",
+			"application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+			"application/x-7z-compressed", "application/x-rar-compressed", "application/pdf",
+			"application/octet-stream",
+		},
+	}
+}
+
+// Compress comprime a resposta de acordo com o Accept-Encoding do cliente. Respostas menores que
+// MinLength e Content-Types já comprimidos passam direto. Flush continua funcionando, então
+// endpoints de streaming continuam mandando os dados aos poucos
+func Compress(cfg ...CompressConfig) MiddlewareFunc {
+	config := DefaultCompressConfig()
+	if len(cfg) > 0 {
+		c := cfg[0]
+		if c.Level == nil {
+			c.Level = config.Level
+		} else {
+			// copia para a config não mudar se quem chamou alterar a variável depois
+			level := *c.Level
+			c.Level = &level
+		}
+		if c.MinLength == 0 {
+			c.MinLength = config.MinLength
+		}
+		if len(c.Compressors) == 0 {
+			c.Compressors = config.Compressors
+		}
+		if c.SkipContentTypes == nil {
+			c.SkipContentTypes = config.SkipContentTypes
+		}
+		config = c
+	}
+
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			tc.Resp.Header().Add("Vary", "Accept-Encoding")
+
+			// HEAD não tem corpo e upgrade ( websocket ) não pode ter o writer trocado
+			if tc.Req.Method == http.MethodHead || tc.Req.Header.Get("Upgrade") != "" {
+				return next(tc)
+			}
+
+			compressor := negotiateEncoding(tc.Req.Header.Get("Accept-Encoding"), config.Compressors)
+			if compressor == nil {
+				return next(tc)
+			}
+
+			cw := &compressResponseWriter{
+				ResponseWriter: tc.Resp,
+				compressor:     compressor,
+				config:         &config,
+			}
+			tc.Resp = cw
+			tc.onFinish(cw.close)
+
+			return next(tc)
+		}
+	}
+}
+
+// negotiateEncoding escolhe o compressor com maior q-value no Accept-Encoding. Em caso de empate
+// vale a ordem de config.Compressors
+func negotiateEncoding(acceptEncoding string, compressors []Compressor) Compressor {
+	if acceptEncoding == "" {
+		return nil
+	}
+	qValues := acceptEncodingQValues(acceptEncoding)
+
+	type candidate struct {
+		compressor Compressor
+		q          float64
+		order      int
+	}
+	var candidates []candidate
+	for i, c := range compressors {
+		q, ok := qValues[c.Encoding()]
+		if !ok {
+			q, ok = qValues["*"]
+		}
+		if ok && q > 0 {
+			candidates = append(candidates, candidate{c, q, i})
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].q != candidates[j].q {
+			return candidates[i].q > candidates[j].q
+		}
+		return candidates[i].order < candidates[j].order
+	})
+	return candidates[0].compressor
+}
+
+// acceptEncodingQValues transforma "gzip;q=0.8, br" em map[gzip:0.8 br:1]
+func acceptEncodingQValues(acceptEncoding string) map[string]float64 {
+	qValues := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		qValues[name] = q
+	}
+	return qValues
+}
+
+// compressResponseWriter segura os primeiros bytes até ter o suficiente para decidir se comprime.
+// A decisão é tomada uma vez só: ou tudo vai comprimido ou tudo vai direto
+type compressResponseWriter struct {
+	http.ResponseWriter
+	compressor Compressor
+	config     *CompressConfig
+
+	status  int
+	buf     []byte
+	decided bool
+	writer  CompressWriter
+	closed  bool
+}
+
+func (c *compressResponseWriter) WriteHeader(status int) {
+	// 1xx não conta como a resposta final
+	if status >= 100 && status < 200 && status != http.StatusSwitchingProtocols {
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if c.decided {
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if c.status != 0 {
+		return
+	}
+	c.status = status
+	// respostas sem corpo não tem o que comprimir
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		c.decide(false)
+	}
+}
+
+func (c *compressResponseWriter) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if c.decided {
+		if c.writer != nil {
+			return c.writer.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.config.MinLength {
+		if err := c.decideAndFlushBuffer(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush força a decisão: quem chama Flush está fazendo streaming, então comprime mesmo que
+// ainda não tenha chegado em MinLength
+func (c *compressResponseWriter) Flush() {
+	if c.closed {
+		return
+	}
+	if !c.decided {
+		if err := c.decideAndFlushBuffer(true); err != nil {
+			return
+		}
+	}
+	if c.writer != nil {
+		c.writer.Flush()
+	}
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Unwrap permite que http.ResponseController encontre o writer original
+func (c *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return c.ResponseWriter
+}
+
+func (c *compressResponseWriter) decideAndFlushBuffer(wantCompress bool) error {
+	c.decide(wantCompress)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	buf := c.buf
+	c.buf = nil
+	var err error
+	if c.writer != nil {
+		_, err = c.writer.Write(buf)
+	} else {
+		_, err = c.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+func (c *compressResponseWriter) decide(wantCompress bool) {
+	c.decided = true
+	h := c.Header()
+
+	if h.Get("Content-Type") == "" && len(c.buf) > 0 {
+		// precisa detectar antes de comprimir, senão o net/http detectaria os bytes já comprimidos
+		h.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+
+	if wantCompress && c.shouldCompress(h) {
+		w, err := c.compressor.NewWriter(c.ResponseWriter, *c.config.Level)
+		if err == nil {
+			c.writer = w
+			h.Set("Content-Encoding", c.compressor.Encoding())
+			h.Del("Content-Length")
+			h.Del("Accept-Ranges")
+			if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				h.Set("ETag", "W/"+etag)
+			}
+		}
+	}
+
+	if c.status != 0 {
+		c.ResponseWriter.WriteHeader(c.status)
+	}
+}
+
+func (c *compressResponseWriter) shouldCompress(h http.Header) bool {
+	// respostas parciais ( Range ) precisam manter os bytes exatamente como estão no arquivo
+	if h.Get("Content-Encoding") != "" || h.Get("Content-Range") != "" || c.status == http.StatusPartialContent {
+		return false
+	}
+	if c.status != 0 && (c.status < 200 || c.status == http.StatusNoContent || c.status == http.StatusNotModified) {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil {
+		mediaType = h.Get("Content-Type")
+	}
+	for _, skip := range c.config.SkipContentTypes {
+		if strings.HasPrefix(mediaType, skip) {
+			return false
+		}
+	}
+	return true
+}
+
+// close é chamado pelo TupaContext no fim da request. Se a resposta não chegou em MinLength
+// ela é enviada sem compressão
+func (c *compressResponseWriter) close() {
+	if c.closed {
+		return
+	}
+	if !c.decided {
+		c.decideAndFlushBuffer(false)
+	}
+	if c.writer != nil {
+		c.writer.Close()
+	}
+	c.closed = true
+}
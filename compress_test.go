@@ -0,0 +1,187 @@
+package tupa
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCompress(t *testing.T) {
+	bigBody := strings.Repeat("tupã ", 1000)
+
+	server := NewAPIServer(":8080", nil)
+	server.UseGlobalMiddlewares(Compress())
+	server.RegisterRoutes([]RouteInfo{
+		{
+			Path:   "/compress/big",
+			Method: MethodGet,
+			Handler: func(tc *TupaContext) error {
+				return WriteJSONHelper(tc.Resp, http.StatusOK, bigBody)
+			},
+		},
+		{
+			Path:   "/compress/small",
+			Method: MethodGet,
+			Handler: func(tc *TupaContext) error {
+				return tc.SendString("pequeno")
+			},
+		},
+		{
+			Path:   "/compress/image",
+			Method: MethodGet,
+			Handler: func(tc *TupaContext) error {
+				tc.Resp.Header().Set("Content-Type", "image/png")
+				return tc.SendString(bigBody)
+			},
+		},
+		{
+			Path:   "/compress/stream",
+			Method: MethodGet,
+			Handler: func(tc *TupaContext) error {
+				tc.SendString("primeiro")
+				http.NewResponseController(tc.Resp).Flush()
+				return tc.SendString("segundo")
+			},
+		},
+	})
+
+	do := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Teste Compress com gzip", func(t *testing.T) {
+		rr := do("/compress/big", "deflate;q=0.5, gzip")
+
+		if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Fatalf("Content-Encoding recebido %q, queria gzip", got)
+		}
+		if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Vary recebido %q, queria Accept-Encoding", got)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type recebido %q, queria application/json", got)
+		}
+
+		gr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(gr)
+		if !strings.Contains(string(body), bigBody) {
+			t.Errorf("corpo descomprimido diferente do enviado")
+		}
+	})
+
+	t.Run("Teste Compress sem Accept-Encoding", func(t *testing.T) {
+		rr := do("/compress/big", "")
+		if got := rr.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("não esperava Content-Encoding, recebeu %q", got)
+		}
+		if got := rr.Header().Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("Vary recebido %q, queria Accept-Encoding", got)
+		}
+	})
+
+	t.Run("Teste Compress com corpo pequeno", func(t *testing.T) {
+		rr := do("/compress/small", "gzip")
+		if got := rr.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("não esperava Content-Encoding, recebeu %q", got)
+		}
+		if rr.Body.String() != "pequeno" {
+			t.Errorf("corpo recebido %q, queria %q", rr.Body.String(), "pequeno")
+		}
+	})
+
+	t.Run("Teste Compress com Content-Type já comprimido", func(t *testing.T) {
+		rr := do("/compress/image", "gzip")
+		if got := rr.Header().Get("Content-Encoding"); got != "" {
+			t.Errorf("não esperava Content-Encoding, recebeu %q", got)
+		}
+	})
+
+	t.Run("Teste Compress com Flush", func(t *testing.T) {
+		rr := do("/compress/stream", "gzip")
+		if !rr.Flushed {
+			t.Error("esperava que a resposta tivesse sido enviada com Flush")
+		}
+		gr, err := gzip.NewReader(rr.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(gr)
+		if string(body) != "primeirosegundo" {
+			t.Errorf("corpo recebido %q, queria %q", body, "primeirosegundo")
+		}
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	compressors := []Compressor{GzipCompressor(), DeflateCompressor()}
+
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"gzip", "gzip"},
+		{"deflate", "deflate"},
+		{"gzip, deflate", "gzip"},
+		{"gzip;q=0.2, deflate;q=0.8", "deflate"},
+		{"*", "gzip"},
+		{"gzip;q=0, *;q=0.1", "deflate"},
+		{"br", ""},
+		{"identity", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.acceptEncoding, func(t *testing.T) {
+			got := ""
+			if c := negotiateEncoding(test.acceptEncoding, compressors); c != nil {
+				got = c.Encoding()
+			}
+			if got != test.expected {
+				t.Errorf("recebeu %q, queria %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestCompressLevel(t *testing.T) {
+	body := strings.Repeat("tupã ", 1000)
+	compressed := func(cfg CompressConfig) int {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rr := httptest.NewRecorder()
+		tc := &TupaContext{Req: req, Resp: rr, Ctx: req.Context()}
+		if err := Compress(cfg)(func(tc *TupaContext) error { return tc.SendString(body) })(tc); err != nil {
+			t.Fatal(err)
+		}
+		tc.finish()
+		if rr.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("esperava gzip, headers %v", rr.Header())
+		}
+		return rr.Body.Len()
+	}
+
+	t.Run("Teste gzip.NoCompression não vira o nível padrão", func(t *testing.T) {
+		level := gzip.NoCompression
+		if size := compressed(CompressConfig{Level: &level}); size <= len(body) {
+			t.Errorf("sem compressão o corpo deveria crescer, ficou com %d de %d bytes", size, len(body))
+		}
+	})
+
+	t.Run("Teste Level nulo usa o nível padrão", func(t *testing.T) {
+		if size := compressed(CompressConfig{}); size >= len(body)/10 {
+			t.Errorf("esperava o corpo comprimido, ficou com %d de %d bytes", size, len(body))
+		}
+	})
+}
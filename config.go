@@ -0,0 +1,674 @@
+package tupa
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rs/cors"
+)
+
+// Config reúne tudo que pode ser configurado fora do código. LoadConfig preenche a partir de
+// DefaultConfig, de um arquivo ( JSON, YAML ou TOML ), das variáveis TUPA_* e das flags, nessa
+// ordem de precedência. As chaves seguem a tag json: "cors.allowed_origins" no arquivo vira
+// TUPA_CORS_ALLOWED_ORIGINS no ambiente e -cors-allowed-origins na linha de comando
+type Config struct {
+	Addr string `json:"addr"`
+	// Listeners são endereços extras no formato de AddListener, e.g. "unix:///run/app.sock" ou "systemd"
+	Listeners         []string      `json:"listeners"`
+	ReadTimeout       time.Duration `json:"read_timeout"`
+	ReadHeaderTimeout time.Duration `json:"read_header_timeout"`
+	WriteTimeout      time.Duration `json:"write_timeout"`
+	IdleTimeout       time.Duration `json:"idle_timeout"`
+	// ShutdownTimeout é quanto Shutdown espera as requests em andamento
+	ShutdownTimeout time.Duration `json:"shutdown_timeout"`
+	ShutdownDelay   time.Duration `json:"shutdown_delay"`
+	// RestartTimeout é quanto Restart espera o processo novo ficar pronto
+	RestartTimeout time.Duration `json:"restart_timeout"`
+	BodyLimit      int64         `json:"body_limit"`
+	// H2C aceita HTTP/2 sem TLS, para quando o TLS termina em um proxy
+	H2C bool `json:"h2c"`
+	// TrustedProxies são os proxies no formato de SetTrustedProxies, e.g. ["10.0.0.0/8", "unix"], e
+	// TrustedProxyHeader o único header lido deles: Forwarded, X-Forwarded-For ou X-Real-IP
+	TrustedProxies     []string `json:"trusted_proxies"`
+	TrustedProxyHeader string   `json:"trusted_proxy_header"`
+
+	CORS        CORSConfig        `json:"cors"`
+	TLS         TLSConfig         `json:"tls"`
+	Log         LogConfig         `json:"log"`
+	Middlewares MiddlewaresConfig `json:"middlewares"`
+}
+
+type CORSConfig struct {
+	AllowedOrigins []string `json:"allowed_origins"`
+	AllowedMethods []string `json:"allowed_methods"`
+	// AllowedHeaders vazio usa a lista padrão do Tupã
+	AllowedHeaders   []string `json:"allowed_headers"`
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"`
+}
+
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// ClientCAFile é o PEM das CAs aceitas para certificados de cliente ( mTLS ). É recarregado junto
+	// com o certificado
+	ClientCAFile string `json:"client_ca_file"`
+	// ClientAuth é none, request, require, verify_if_given ou require_and_verify
+	ClientAuth string `json:"client_auth"`
+	// MinVersion é 1.2 ou 1.3. Padrão 1.2
+	MinVersion string `json:"min_version"`
+	// ReloadInterval é de quanto em quanto tempo os arquivos do certificado são conferidos. 0 só
+	// recarrega no SIGHUP
+	ReloadInterval time.Duration `json:"reload_interval"`
+	// HTTPRedirectAddr abre um listener HTTP, e.g. ":80", que redireciona tudo para HTTPS
+	HTTPRedirectAddr string `json:"http_redirect_addr"`
+}
+
+type LogConfig struct {
+	// Level é debug, info, warn ou error
+	Level string `json:"level"`
+	// Format é text ou json
+	Format string `json:"format"`
+	// SetDefault troca o logger padrão do slog ( slog.SetDefault ) pelo da config, e com ele os logs
+	// do próprio Tupã. Desligado, o logger só fica em a.Logger()
+	SetDefault bool `json:"set_default"`
+}
+
+// MiddlewaresConfig liga os middlewares globais do Tupã
+type MiddlewaresConfig struct {
+	SecureHeaders bool `json:"secure_headers"`
+	Compress      bool `json:"compress"`
+	// Metrics também registra GET /metrics com o DefaultMetricsRegistry. A rota é pública, então
+	// restrinja com MetricsAllowlist ( e.g. a rede do Prometheus ) ou deixe atrás do proxy
+	Metrics bool `json:"metrics"`
+	// MetricsAllowlist são os IPs e CIDRs que podem ler /metrics, via IPAllowlist. Vazio não restringe
+	MetricsAllowlist []string `json:"metrics_allowlist"`
+	// Tracing sem exporter só propaga o traceparent, use Tracing() direto para exportar os spans
+	Tracing                bool          `json:"tracing"`
+	DecompressRequest      bool          `json:"decompress_request"`
+	DecompressRequestLimit int64         `json:"decompress_request_limit"`
+	RequestTimeout         time.Duration `json:"request_timeout"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		Addr:              ":8080",
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		ShutdownTimeout:   10 * time.Second,
+		RestartTimeout:    30 * time.Second,
+		TLS:               TLSConfig{ReloadInterval: time.Minute},
+		CORS:              CORSConfig{AllowCredentials: true},
+		Log:               LogConfig{Level: "info", Format: "text"},
+		Middlewares:       MiddlewaresConfig{DecompressRequestLimit: 10 << 20},
+	}
+}
+
+// LoadConfig monta a Config. path é o arquivo de configuração e pode ser vazio; a variável
+// TUPA_CONFIG e a flag -config têm precedência sobre ele. args normalmente é os.Args[1:] e só pode
+// ter as flags do Tupã; se a aplicação tem flags próprias use LoadConfigFlagSet
+func LoadConfig(path string, args []string) (Config, error) {
+	return LoadConfigFlagSet(path, flag.NewFlagSet("tupa", flag.ContinueOnError), args)
+}
+
+// LoadConfigFlagSet é o LoadConfig com as flags do Tupã registradas em fs, junto com as da aplicação,
+// e.g. flag.CommandLine. fs.Parse(args) é chamado aqui, então as flags da aplicação já vêm preenchidas
+// no retorno. fs não pode ter flags com os mesmos nomes, como -config ou -addr
+func LoadConfigFlagSet(path string, fs *flag.FlagSet, args []string) (Config, error) {
+	cfg := DefaultConfig()
+	fields := configFields(reflect.ValueOf(&cfg).Elem(), "")
+
+	// as flags são lidas primeiro para saber o -config, mas aplicadas por último
+	flagValues := map[string]string{}
+	fs.StringVar(&path, "config", path, "arquivo de configuração ( .json, .yaml, .yml ou .toml )")
+	// em ordem, para o -help e os erros de flag repetida não mudarem a cada execução
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field := fields[key]
+		name := strings.NewReplacer(".", "-", "_", "-").Replace(key)
+		if field.Kind() == reflect.Bool {
+			fs.BoolFunc(name, key, func(s string) error { flagValues[key] = s; return nil })
+			continue
+		}
+		fs.Func(name, key, func(s string) error { flagValues[key] = s; return nil })
+	}
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+	if envPath := os.Getenv("TUPA_CONFIG"); envPath != "" && !flagSet(fs, "config") {
+		path = envPath
+	}
+
+	if path != "" {
+		values, err := readConfigFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		for key, value := range values {
+			field, ok := fields[key]
+			if !ok {
+				return cfg, fmt.Errorf("%s: chave desconhecida %q", path, key)
+			}
+			if value == nil {
+				// null ( ou "chave:" vazia no YAML ) mantém o padrão
+				continue
+			}
+			if err := setConfigValue(field, value); err != nil {
+				return cfg, fmt.Errorf("%s: %s: %w", path, key, err)
+			}
+		}
+	}
+
+	for key, field := range fields {
+		env := "TUPA_" + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(key))
+		if value, ok := os.LookupEnv(env); ok {
+			if err := setConfigValue(field, value); err != nil {
+				return cfg, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+
+	for key, value := range flagValues {
+		if err := setConfigValue(fields[key], value); err != nil {
+			return cfg, fmt.Errorf("-%s: %w", key, err)
+		}
+	}
+
+	return cfg, cfg.Validate()
+}
+
+func flagSet(fs *flag.FlagSet, name string) bool {
+	found := false
+	fs.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			found = true
+		}
+	})
+	return found
+}
+
+func (c Config) Validate() error {
+	var errs []error
+	if c.Addr == "" && len(c.Listeners) == 0 {
+		errs = append(errs, errors.New("addr ou listeners é obrigatório"))
+	}
+	for _, addr := range c.Listeners {
+		if _, err := parseListenAddr(addr); err != nil {
+			errs = append(errs, fmt.Errorf("listeners: %w", err))
+		}
+	}
+	if err := c.TLS.validate(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseTrustedProxies(c.TrustedProxyHeader, c.TrustedProxies); err != nil {
+		errs = append(errs, fmt.Errorf("trusted_proxies: %w", err))
+	}
+	if _, err := parseLogLevel(c.Log.Level); err != nil {
+		errs = append(errs, err)
+	}
+	if c.Log.Format != "" && c.Log.Format != "text" && c.Log.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format inválido %q, use text ou json", c.Log.Format))
+	}
+	for name, d := range map[string]time.Duration{
+		"read_timeout": c.ReadTimeout, "read_header_timeout": c.ReadHeaderTimeout, "write_timeout": c.WriteTimeout,
+		"idle_timeout": c.IdleTimeout, "shutdown_timeout": c.ShutdownTimeout, "shutdown_delay": c.ShutdownDelay,
+		"restart_timeout": c.RestartTimeout, "middlewares.request_timeout": c.Middlewares.RequestTimeout,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s não pode ser negativo", name))
+		}
+	}
+	for _, entry := range c.Middlewares.MetricsAllowlist {
+		if _, err := netip.ParsePrefix(entry); err != nil {
+			if _, err := netip.ParseAddr(entry); err != nil {
+				errs = append(errs, fmt.Errorf("middlewares.metrics_allowlist: IP ou CIDR inválido %q", entry))
+			}
+		}
+	}
+	if c.Middlewares.DecompressRequest && c.Middlewares.DecompressRequestLimit <= 0 {
+		errs = append(errs, errors.New("middlewares.decompress_request_limit precisa ser maior que zero"))
+	}
+	return errors.Join(errs...)
+}
+
+func parseLogLevel(level string) (slog.Level, error) {
+	switch strings.ToLower(level) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return 0, fmt.Errorf("log.level inválido %q, use debug, info, warn ou error", level)
+}
+
+// NewAPIServerFromConfig cria o servidor com a Config: timeouts, TLS e h2c do http.Server, CORS, body
+// limit, shutdown, o logger ( a.Logger(), padrão do slog só com Log.SetDefault ) e os middlewares
+// globais ligados em cfg.Middlewares
+func NewAPIServerFromConfig(cfg Config, routeManager RouteManager) (*APIServer, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	a := NewAPIServer(cfg.Addr, routeManager)
+	a.config = &cfg
+	a.SetBodyLimit(cfg.BodyLimit)
+	a.SetShutdownDelay(cfg.ShutdownDelay)
+	for _, addr := range cfg.Listeners {
+		if err := a.AddListener(addr); err != nil {
+			return nil, err
+		}
+	}
+	if err := a.SetTrustedProxies(cfg.TrustedProxyHeader, cfg.TrustedProxies...); err != nil {
+		return nil, err
+	}
+	if cfg.TLS.CertFile != "" {
+		if err := a.SetTLS(cfg.TLS); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.H2C {
+		a.EnableH2C()
+	}
+
+	level, _ := parseLogLevel(cfg.Log.Level)
+	opts := &slog.HandlerOptions{Level: level}
+	if cfg.Log.Format == "json" {
+		a.logger = slog.New(slog.NewJSONHandler(os.Stderr, opts))
+	} else {
+		a.logger = slog.New(slog.NewTextHandler(os.Stderr, opts))
+	}
+	if cfg.Log.SetDefault {
+		slog.SetDefault(a.logger)
+	}
+
+	m := cfg.Middlewares
+	if m.Tracing {
+		a.UseGlobalMiddlewares(Tracing())
+	}
+	if m.Metrics {
+		a.UseGlobalMiddlewares(Metrics())
+		route := RouteInfo{Path: "/metrics", Method: MethodGet, Hidden: true, Handler: DefaultMetricsRegistry.Handler()}
+		if len(m.MetricsAllowlist) > 0 {
+			route.Middlewares = MiddlewareChain{IPAllowlist(m.MetricsAllowlist...)}
+		}
+		a.RegisterRoutes([]RouteInfo{route})
+	}
+	if m.SecureHeaders {
+		a.UseGlobalMiddlewares(SecureHeaders())
+	}
+	if m.DecompressRequest {
+		a.UseGlobalMiddlewares(DecompressRequest(m.DecompressRequestLimit))
+	}
+	if m.Compress {
+		a.UseGlobalMiddlewares(Compress())
+	}
+	if m.RequestTimeout > 0 {
+		a.UseGlobalMiddlewares(Timeout(m.RequestTimeout))
+	}
+
+	return a, nil
+}
+
+// Logger é o logger montado com Config.Log em NewAPIServerFromConfig. Sem config é o slog.Default()
+func (a *APIServer) Logger() *slog.Logger {
+	if a.logger == nil {
+		return slog.Default()
+	}
+	return a.logger
+}
+
+var defaultCORSHeaders = []string{
+	"Authorization", "authorization", "Accept", "Content-Type", "X-Requested-With", "X-Frame-Options",
+	"X-XSS-Protection", "X-Content-Type-Options", "X-Permitted-Cross-Domain-Policies", "Referrer-Policy", "Expect-CT",
+	"Feature-Policy", "Content-Security-Policy", "Content-Security-Policy-Report-Only", "Strict-Transport-Security",
+	"Public-Key-Pins", "Public-Key-Pins-Report-Only", "Access-Control-Allow-Origin", "Access-Control-Allow-Methods",
+	"Access-Control-Allow-Headers", "Access-Control-Allow-Credentials", "X-Forwarded-For", "X-Real-IP",
+	"X-Csrf-Token", "X-HTTP-Method-Override",
+}
+
+func (a *APIServer) corsOptions() cors.Options {
+	c := DefaultConfig().CORS
+	if a.config != nil {
+		c = a.config.CORS
+	}
+	headers := c.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	return cors.Options{
+		AllowedOrigins:   c.AllowedOrigins,
+		AllowedMethods:   c.AllowedMethods,
+		AllowedHeaders:   headers,
+		ExposedHeaders:   c.ExposedHeaders,
+		AllowCredentials: c.AllowCredentials,
+		MaxAge:           c.MaxAge,
+	}
+}
+
+// configFields mapeia cada chave ( "cors.allowed_origins" ) para o campo da Config
+func configFields(v reflect.Value, prefix string) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	for i := 0; i < v.NumField(); i++ {
+		name := strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		field := v.Field(i)
+		if field.Kind() == reflect.Struct {
+			for key, sub := range configFields(field, prefix+name+".") {
+				fields[key] = sub
+			}
+			continue
+		}
+		fields[prefix+name] = field
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// setConfigValue aceita os tipos do JSON e strings, que é o que chega do ambiente, das flags e
+// dos arquivos YAML e TOML
+func setConfigValue(field reflect.Value, value any) error {
+	if field.Type() == durationType {
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("duração precisa ser texto, e.g. \"10s\", recebeu %v", value)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(s))
+		if err != nil {
+			return err
+		}
+		field.SetInt(int64(d))
+		return nil
+	}
+
+	switch field.Kind() {
+	case reflect.String:
+		s, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("esperava texto, recebeu %v", value)
+		}
+		field.SetString(s)
+	case reflect.Bool:
+		switch v := value.(type) {
+		case bool:
+			field.SetBool(v)
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err != nil {
+				return err
+			}
+			field.SetBool(b)
+		default:
+			return fmt.Errorf("esperava booleano, recebeu %v", value)
+		}
+	case reflect.Int, reflect.Int64:
+		switch v := value.(type) {
+		case float64:
+			if v != float64(int64(v)) {
+				return fmt.Errorf("esperava inteiro, recebeu %v", v)
+			}
+			field.SetInt(int64(v))
+		case string:
+			n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+			if err != nil {
+				return err
+			}
+			field.SetInt(n)
+		default:
+			return fmt.Errorf("esperava inteiro, recebeu %v", value)
+		}
+	case reflect.Slice:
+		var list []string
+		switch v := value.(type) {
+		case string:
+			// no ambiente e nas flags listas são separadas por vírgula
+			for _, item := range strings.Split(v, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+		case []string:
+			list = v
+		case []any:
+			for _, item := range v {
+				s, ok := item.(string)
+				if !ok {
+					return fmt.Errorf("esperava lista de textos, recebeu %v", item)
+				}
+				list = append(list, s)
+			}
+		default:
+			return fmt.Errorf("esperava lista, recebeu %v", value)
+		}
+		field.Set(reflect.ValueOf(list))
+	default:
+		return fmt.Errorf("tipo %s não suportado", field.Type())
+	}
+	return nil
+}
+
+func readConfigFile(path string) (map[string]any, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	values := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		var doc map[string]any
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		flattenConfig(doc, "", values)
+	case ".yaml", ".yml":
+		err = parseYAMLConfig(string(data), values)
+	case ".toml":
+		err = parseTOMLConfig(string(data), values)
+	default:
+		return nil, fmt.Errorf("%s: formato não suportado, use .json, .yaml, .yml ou .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return values, nil
+}
+
+func flattenConfig(doc map[string]any, prefix string, out map[string]any) {
+	for key, value := range doc {
+		if sub, ok := value.(map[string]any); ok {
+			flattenConfig(sub, prefix+key+".", out)
+			continue
+		}
+		out[prefix+key] = value
+	}
+}
+
+// parseYAMLConfig entende o subconjunto de YAML que a Config usa: mapas aninhados por indentação,
+// escalares e listas ( "- item" ou [a, b] ). Âncoras, blocos multilinha e afins não são suportados
+func parseYAMLConfig(doc string, out map[string]any) error {
+	type level struct {
+		indent int
+		prefix string
+	}
+	stack := []level{{indent: -1}}
+	var listKey string
+	listIndent := -1
+
+	for n, line := range strings.Split(doc, "\n") {
+		line = stripConfigComment(strings.TrimRight(line, " \t\r"))
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" || indent < listIndent {
+				return fmt.Errorf("linha %d: item de lista fora de uma chave", n+1)
+			}
+			list, _ := out[listKey].([]string)
+			out[listKey] = append(list, unquoteConfig(strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))))
+			continue
+		}
+		listKey = ""
+
+		key, value, ok := strings.Cut(trimmed, ":")
+		if !ok {
+			return fmt.Errorf("linha %d: esperava \"chave: valor\"", n+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+
+		for len(stack) > 1 && indent <= stack[len(stack)-1].indent {
+			stack = stack[:len(stack)-1]
+		}
+		fullKey := stack[len(stack)-1].prefix + key
+
+		switch {
+		case value == "":
+			// pode ser um mapa ou uma lista, decidido pelas próximas linhas
+			stack = append(stack, level{indent: indent, prefix: fullKey + "."})
+			listKey, listIndent = fullKey, indent
+			out[fullKey] = nil
+		case yamlNull(value):
+			out[fullKey] = nil
+		case strings.HasPrefix(value, "["):
+			out[fullKey] = parseInlineList(value)
+		default:
+			out[fullKey] = unquoteConfig(value)
+		}
+	}
+
+	// chaves que abriram um mapa ficam com nil, que não é um valor de verdade. Sem filhos nem itens
+	// o nil fica, é o null do YAML
+	for key, value := range out {
+		if value == nil && hasConfigChild(out, key) {
+			delete(out, key)
+		}
+	}
+	return nil
+}
+
+// yamlNull diz se o valor é o null do YAML, que vale o mesmo que a chave vazia
+func yamlNull(value string) bool {
+	switch value {
+	case "~", "null", "Null", "NULL":
+		return true
+	}
+	return false
+}
+
+func hasConfigChild(values map[string]any, key string) bool {
+	for k := range values {
+		if strings.HasPrefix(k, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTOMLConfig entende tabelas ( [cors] ), "chave = valor", strings, números, booleanos e arrays
+// de textos, que podem ocupar várias linhas. Tabelas inline, arrays de tabelas e strings multilinha
+// não são suportados
+func parseTOMLConfig(doc string, out map[string]any) error {
+	prefix := ""
+	lines := strings.Split(doc, "\n")
+	for n := 0; n < len(lines); n++ {
+		line := strings.TrimSpace(stripConfigComment(lines[n]))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return fmt.Errorf("linha %d: tabela inválida", n+1)
+			}
+			prefix = strings.TrimSpace(line[1:len(line)-1]) + "."
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("linha %d: esperava \"chave = valor\"", n+1)
+		}
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		start := n
+		if strings.HasPrefix(value, "[") {
+			// array de várias linhas: junta até a linha que fecha o ]
+			for !strings.HasSuffix(value, "]") {
+				if n++; n == len(lines) {
+					return fmt.Errorf("linha %d: array sem ]", start+1)
+				}
+				value += " " + strings.TrimSpace(stripConfigComment(lines[n]))
+			}
+			out[prefix+unquoteConfig(key)] = parseInlineList(value)
+			continue
+		}
+		if strings.HasPrefix(value, "{") || strings.HasPrefix(value, `"""`) || strings.HasPrefix(value, "'''") {
+			return fmt.Errorf("linha %d: tabelas inline e strings multilinha não são suportadas", n+1)
+		}
+		out[prefix+unquoteConfig(key)] = unquoteConfig(value)
+	}
+	return nil
+}
+
+func parseInlineList(value string) []string {
+	value = strings.TrimSuffix(strings.TrimPrefix(value, "["), "]")
+	list := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, unquoteConfig(item))
+		}
+	}
+	return list
+}
+
+func unquoteConfig(value string) string {
+	if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+		if value[0] == '"' {
+			if s, err := strconv.Unquote(value); err == nil {
+				return s
+			}
+		}
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// stripConfigComment remove comentários com #, ignorando # dentro de aspas
+func stripConfigComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,329 @@
+package tupa
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	files := map[string]string{
+		"config.json": `{
+			"addr": ":9000",
+			"write_timeout": "15s",
+			"body_limit": 1048576,
+			"cors": {"allowed_origins": ["https://app.example.com", "https://admin.example.com"], "allow_credentials": false},
+			"log": {"level": "debug"},
+			"middlewares": {"compress": true}
+		}`,
+		"config.yaml": `
+# servidor
+addr: ":9000"
+write_timeout: 15s
+body_limit: 1048576
+cors:
+  allowed_origins:
+    - https://app.example.com
+    - "https://admin.example.com"
+  allow_credentials: false
+log:
+  level: debug # mais detalhes
+middlewares:
+  compress: true
+`,
+		"config.toml": `
+addr = ":9000"
+write_timeout = "15s"
+body_limit = 1048576
+
+[cors]
+allowed_origins = ["https://app.example.com", "https://admin.example.com"]
+allow_credentials = false
+
+[log]
+level = "debug"
+
+[middlewares]
+compress = true
+`,
+	}
+
+	want := DefaultConfig()
+	want.Addr = ":9000"
+	want.WriteTimeout = 15 * time.Second
+	want.BodyLimit = 1 << 20
+	want.CORS.AllowedOrigins = []string{"https://app.example.com", "https://admin.example.com"}
+	want.CORS.AllowCredentials = false
+	want.Log.Level = "debug"
+	want.Middlewares.Compress = true
+
+	for name, content := range files {
+		t.Run("Teste arquivo "+name, func(t *testing.T) {
+			cfg, err := LoadConfig(writeConfigFile(t, name, content), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cfg, want) {
+				t.Errorf("config recebida:\n%+v\nqueria:\n%+v", cfg, want)
+			}
+		})
+	}
+
+	t.Run("Teste precedência arquivo < env < flags", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", "addr: \":9000\"\nwrite_timeout: 15s\nlog:\n  level: debug\n")
+		t.Setenv("TUPA_ADDR", ":9100")
+		t.Setenv("TUPA_WRITE_TIMEOUT", "20s")
+		t.Setenv("TUPA_CORS_ALLOWED_ORIGINS", "https://a.com, https://b.com")
+
+		cfg, err := LoadConfig(path, []string{"-addr", ":9200", "-middlewares-metrics", "-log-level=warn"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Addr != ":9200" || cfg.WriteTimeout != 20*time.Second || cfg.Log.Level != "warn" || !cfg.Middlewares.Metrics {
+			t.Errorf("precedência errada: %+v", cfg)
+		}
+		if !reflect.DeepEqual(cfg.CORS.AllowedOrigins, []string{"https://a.com", "https://b.com"}) {
+			t.Errorf("lista do env inesperada: %v", cfg.CORS.AllowedOrigins)
+		}
+	})
+
+	t.Run("Teste arquivo pela flag e pelo TUPA_CONFIG", func(t *testing.T) {
+		fromEnv := writeConfigFile(t, "env.json", `{"addr": ":7000"}`)
+		fromFlag := writeConfigFile(t, "flag.json", `{"addr": ":7001"}`)
+		t.Setenv("TUPA_CONFIG", fromEnv)
+
+		cfg, err := LoadConfig("", nil)
+		if err != nil || cfg.Addr != ":7000" {
+			t.Errorf("TUPA_CONFIG ignorado: %v %s", err, cfg.Addr)
+		}
+		cfg, err = LoadConfig("", []string{"-config", fromFlag})
+		if err != nil || cfg.Addr != ":7001" {
+			t.Errorf("-config ignorado: %v %s", err, cfg.Addr)
+		}
+	})
+
+	t.Run("Teste chave vazia no YAML mantém o padrão", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", "log:\n  level:\n  format: json\ncors:\n  allowed_origins:\n")
+		cfg, err := LoadConfig(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Log.Level != "info" || cfg.Log.Format != "json" || cfg.CORS.AllowedOrigins != nil {
+			t.Errorf("config recebida %+v", cfg)
+		}
+	})
+
+	t.Run("Teste null do YAML mantém o padrão", func(t *testing.T) {
+		path := writeConfigFile(t, "config.yaml", "log:\n  level: ~\n  format: null\ncors:\n  allowed_origins: NULL\n")
+		cfg, err := LoadConfig(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Log != DefaultConfig().Log || cfg.CORS.AllowedOrigins != nil {
+			t.Errorf("config recebida %+v", cfg)
+		}
+	})
+
+	t.Run("Teste array de várias linhas no TOML", func(t *testing.T) {
+		path := writeConfigFile(t, "config.toml", `
+[cors]
+allowed_origins = [
+  "https://app.example.com", # app
+  "https://admin.example.com",
+]
+allow_credentials = false
+`)
+		cfg, err := LoadConfig(path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"https://app.example.com", "https://admin.example.com"}; !reflect.DeepEqual(cfg.CORS.AllowedOrigins, want) {
+			t.Errorf("allowed_origins recebido %v, queria %v", cfg.CORS.AllowedOrigins, want)
+		}
+		if cfg.CORS.AllowCredentials {
+			t.Error("a chave depois do array não foi lida")
+		}
+	})
+
+	t.Run("Teste flags registradas em ordem", func(t *testing.T) {
+		// com duas flags repetidas o panic do flag tem que ser sempre da primeira chave em ordem
+		for i := 0; i < 20; i++ {
+			fs := flag.NewFlagSet("app", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			fs.String("tls-cert-file", "", "")
+			fs.String("addr", "", "")
+			func() {
+				defer func() {
+					if p := recover(); p == nil || !strings.Contains(fmt.Sprint(p), "addr") {
+						t.Fatalf("esperava panic da flag addr, recebeu %v", p)
+					}
+				}()
+				LoadConfigFlagSet("", fs, nil)
+			}()
+		}
+	})
+
+	t.Run("Teste flags da aplicação no mesmo FlagSet", func(t *testing.T) {
+		fs := flag.NewFlagSet("app", flag.ContinueOnError)
+		workers := fs.Int("workers", 1, "workers da aplicação")
+		cfg, err := LoadConfigFlagSet("", fs, []string{"-workers", "4", "-addr", ":9300", "migrate"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *workers != 4 || cfg.Addr != ":9300" || fs.Arg(0) != "migrate" {
+			t.Errorf("flags recebidas workers=%d addr=%s args=%v", *workers, cfg.Addr, fs.Args())
+		}
+	})
+
+	t.Run("Teste erros", func(t *testing.T) {
+		for name, tc := range map[string]struct {
+			file, content string
+			args          []string
+			err           string
+		}{
+			"chave desconhecida": {"c.json", `{"porta": 80}`, nil, `chave desconhecida "porta"`},
+			"duração inválida":   {"c.yaml", "idle_timeout: muito", nil, "idle_timeout"},
+			"formato":            {"c.ini", "addr=:80", nil, "formato não suportado"},
+			"array sem fim":      {"c.toml", "listeners = [\n  \"systemd\",\n", nil, "linha 1: array sem ]"},
+			"tabela inline":      {"c.toml", "[tls]\ncert = { file = \"a\" }", nil, "tabelas inline"},
+			"tls incompleto":     {"c.toml", "[tls]\ncert_file = \"cert.pem\"", nil, "tls.cert_file e tls.key_file"},
+			"log level":          {"c.json", `{}`, []string{"-log-level", "verbose"}, "log.level inválido"},
+			"flag desconhecida":  {"c.json", `{}`, []string{"-porta", "80"}, "porta"},
+			"metrics_allowlist":  {"c.json", `{"middlewares": {"metrics_allowlist": ["prometheus"]}}`, nil, "metrics_allowlist"},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := LoadConfig(writeConfigFile(t, tc.file, tc.content), tc.args)
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("esperava erro com %q, recebeu %v", tc.err, err)
+				}
+			})
+		}
+	})
+}
+
+func TestNewAPIServerFromConfig(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+
+	cfg := DefaultConfig()
+	cfg.CORS.AllowedOrigins = []string{"https://app.example.com"}
+	cfg.CORS.AllowedMethods = []string{http.MethodGet, http.MethodPost}
+	cfg.BodyLimit = 16
+	cfg.ShutdownDelay = time.Second
+	cfg.Middlewares.SecureHeaders = true
+
+	server, err := NewAPIServerFromConfig(cfg, func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterRoutes([]RouteInfo{{Path: "/config/eco", Method: MethodPost, Handler: func(tc *TupaContext) error {
+		_, err := tc.Req.Body.Read(make([]byte, 64))
+		return err
+	}}})
+	handler := server.Handler()
+
+	t.Run("Teste CORS da config", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodOptions, "/config/eco", nil)
+		req.Header.Set("Origin", "https://app.example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+			t.Errorf("origin permitido recebeu headers %v", rr.Header())
+		}
+
+		req.Header.Set("Origin", "https://outro.com")
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("origin não permitido recebeu Access-Control-Allow-Origin")
+		}
+	})
+
+	t.Run("Teste body limit, shutdown delay e middlewares", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/config/eco", strings.NewReader(strings.Repeat("a", 32)))
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("esperava 413, recebeu %d", rr.Code)
+		}
+
+		rr = httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/config/nada", nil))
+		if rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("SecureHeaders deveria estar ligado: %v", rr.Header())
+		}
+		if server.shutdownDelay != time.Second {
+			t.Errorf("shutdown delay não aplicado")
+		}
+	})
+
+	t.Run("Teste /metrics restrito pela allowlist", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Middlewares.Metrics = true
+		cfg.Middlewares.MetricsAllowlist = []string{"10.0.0.0/8"}
+		server, err := NewAPIServerFromConfig(cfg, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for remote, want := range map[string]int{"10.1.2.3:5000": http.StatusOK, "203.0.113.9:5000": http.StatusForbidden} {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.RemoteAddr = remote
+			rr := httptest.NewRecorder()
+			server.Handler().ServeHTTP(rr, req)
+			if rr.Code != want {
+				t.Errorf("%s: esperava %d, recebeu %d", remote, want, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Teste logger da config só vira o padrão com SetDefault", func(t *testing.T) {
+		before := slog.Default()
+		cfg := DefaultConfig()
+		cfg.Log.Level = "error"
+		server, err := NewAPIServerFromConfig(cfg, func() {})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if slog.Default() != before {
+			t.Error("slog.Default() não deveria mudar sem Log.SetDefault")
+		}
+		if server.Logger().Enabled(context.Background(), slog.LevelWarn) {
+			t.Error("o logger do servidor deveria usar o nível da config")
+		}
+
+		cfg.Log.SetDefault = true
+		if server, err = NewAPIServerFromConfig(cfg, func() {}); err != nil {
+			t.Fatal(err)
+		}
+		if slog.Default() != server.Logger() {
+			t.Error("Log.SetDefault deveria trocar o slog.Default()")
+		}
+	})
+
+	t.Run("Teste config inválida", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.Addr = ""
+		if _, err := NewAPIServerFromConfig(cfg, nil); err == nil {
+			t.Errorf("esperava erro de validação")
+		}
+	})
+}
@@ -0,0 +1,188 @@
+package tupa
+
+import (
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"strings"
+	"time"
+)
+
+type DebugConfig struct {
+	// Prefix das rotas. Padrão "/debug"
+	Prefix string
+	// Guard protege todas as rotas de debug, e.g. IPAllowlist ou TokenAuth. É obrigatório: sem ele
+	// a.Debug encerra o processo, a não ser que AllowUnprotected seja true ( só para desenvolvimento )
+	Guard            MiddlewareFunc
+	AllowUnprotected bool
+	// Middlewares extras, rodam depois do Guard
+	Middlewares []MiddlewareFunc
+}
+
+// Debug registra as rotas de diagnóstico, todas atrás de cfg.Guard:
+//
+//	GET  /debug/pprof/             índice do net/http/pprof e os profiles ( heap, goroutine, profile, trace... )
+//	GET  /debug/goroutines         stack de todas as goroutines em texto
+//	GET  /debug/routes             tabela de rotas ( a.Routes() ) em JSON
+//	GET  /debug/runtime            memória, GC, goroutines e versão do Go em JSON
+func (a *APIServer) Debug(cfg DebugConfig) {
+	if cfg.Guard == nil && !cfg.AllowUnprotected {
+		log.Fatalf("%s", FmtRed("DebugConfig.Guard é obrigatório, as rotas de debug expõem detalhes internos do processo"))
+	}
+	prefix := strings.TrimSuffix(cfg.Prefix, "/")
+	if prefix == "" {
+		prefix = "/debug"
+	}
+
+	var middlewares []MiddlewareFunc
+	if cfg.Guard != nil {
+		middlewares = append(middlewares, cfg.Guard)
+	}
+	middlewares = append(middlewares, cfg.Middlewares...)
+
+	started := time.Now()
+	routes := []RouteInfo{
+		// {name...} também casa com o path vazio, que é o índice
+		{Path: prefix + "/pprof/{name...}", Method: MethodGet, Handler: pprofHandler(prefix)},
+		// o symbol do pprof aceita os endereços no corpo
+		{Path: prefix + "/pprof/{name...}", Method: MethodPost, Handler: pprofHandler(prefix)},
+		{Path: prefix + "/goroutines", Method: MethodGet, Handler: func(tc *TupaContext) error {
+			tc.Resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			return runtimepprof.Lookup("goroutine").WriteTo(tc.Resp, 2)
+		}},
+		{Path: prefix + "/routes", Method: MethodGet, Handler: func(tc *TupaContext) error {
+			return WriteJSONHelper(tc.Resp, http.StatusOK, a.Routes())
+		}},
+		{Path: prefix + "/runtime", Method: MethodGet, Handler: func(tc *TupaContext) error {
+			return WriteJSONHelper(tc.Resp, http.StatusOK, readRuntimeStats(started))
+		}},
+	}
+	for i := range routes {
+		routes[i].Middlewares = middlewares
+		routes[i].Hidden = true
+	}
+	a.RegisterRoutes(routes)
+}
+
+func pprofHandler(prefix string) APIFunc {
+	return func(tc *TupaContext) error {
+		name := tc.Param("name")
+		switch name {
+		case "":
+			// pprof.Index descobre o profile pelo path, que precisa começar com /debug/pprof/
+			req := tc.Req.Clone(tc.Req.Context())
+			req.URL.Path = "/debug/pprof/"
+			pprof.Index(tc.Resp, req)
+		case "cmdline":
+			pprof.Cmdline(tc.Resp, tc.Req)
+		case "profile":
+			pprof.Profile(tc.Resp, tc.Req)
+		case "symbol":
+			pprof.Symbol(tc.Resp, tc.Req)
+		case "trace":
+			pprof.Trace(tc.Resp, tc.Req)
+		default:
+			pprof.Handler(name).ServeHTTP(tc.Resp, tc.Req)
+		}
+		return nil
+	}
+}
+
+type RuntimeStats struct {
+	GoVersion    string `json:"go_version"`
+	Uptime       string `json:"uptime"`
+	Goroutines   int    `json:"goroutines"`
+	NumCPU       int    `json:"num_cpu"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	Sys          uint64 `json:"sys_bytes"`
+	TotalAlloc   uint64 `json:"total_alloc_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	LastGC       string `json:"last_gc,omitempty"`
+	PauseTotal   string `json:"gc_pause_total"`
+	NextGCTarget uint64 `json:"next_gc_bytes"`
+}
+
+func readRuntimeStats(started time.Time) RuntimeStats {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	stats := RuntimeStats{
+		GoVersion:    runtime.Version(),
+		Uptime:       time.Since(started).Round(time.Second).String(),
+		Goroutines:   runtime.NumGoroutine(),
+		NumCPU:       runtime.NumCPU(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		HeapAlloc:    mem.HeapAlloc,
+		HeapInuse:    mem.HeapInuse,
+		HeapObjects:  mem.HeapObjects,
+		Sys:          mem.Sys,
+		TotalAlloc:   mem.TotalAlloc,
+		NumGC:        mem.NumGC,
+		PauseTotal:   time.Duration(mem.PauseTotalNs).String(),
+		NextGCTarget: mem.NextGC,
+	}
+	if mem.LastGC > 0 {
+		stats.LastGC = time.Unix(0, int64(mem.LastGC)).UTC().Format(time.RFC3339)
+	}
+	return stats
+}
+
+// IPAllowlist só deixa passar requests vindas dos IPs ou redes ( CIDR ) informados, e.g.
+// IPAllowlist("127.0.0.1", "10.0.0.0/8"), comparando com tc.RealIP(). Entradas inválidas encerram o processo
+func IPAllowlist(entries ...string) MiddlewareFunc {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Fatalf("%s%s", FmtRed("IP ou CIDR inválido no IPAllowlist: "), entry)
+		}
+		nets = append(nets, ipNet)
+	}
+
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			// atrás de um proxy confiável vale o IP do cliente, não o do proxy
+			if ip := net.ParseIP(tc.RealIP()); ip != nil {
+				for _, n := range nets {
+					if n.Contains(ip) {
+						return next(tc)
+					}
+				}
+			}
+			return APIHandlerErr{Status: http.StatusForbidden, Msg: "Acesso negado"}
+		}
+	}
+}
+
+// TokenAuth exige o token no header Authorization ( "Bearer <token>" ) ou em X-Debug-Token
+func TokenAuth(token string) MiddlewareFunc {
+	if token == "" {
+		log.Fatalf("%s", FmtRed("TokenAuth precisa de um token"))
+	}
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			got := tc.Req.Header.Get("X-Debug-Token")
+			if auth := tc.Req.Header.Get("Authorization"); got == "" && strings.HasPrefix(auth, "Bearer ") {
+				got = strings.TrimPrefix(auth, "Bearer ")
+			}
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				return APIHandlerErr{Status: http.StatusUnauthorized, Msg: "Token inválido"}
+			}
+			return next(tc)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package tupa
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebug(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	server.Debug(DebugConfig{Guard: TokenAuth("segredo")})
+
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Teste guard bloqueia sem token", func(t *testing.T) {
+		for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/goroutines", "/debug/routes", "/debug/runtime"} {
+			if rr := do(http.MethodGet, path, ""); rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s sem token recebeu %d", path, rr.Code)
+			}
+			if rr := do(http.MethodGet, path, "errado"); rr.Code != http.StatusUnauthorized {
+				t.Errorf("%s com token errado recebeu %d", path, rr.Code)
+			}
+		}
+	})
+
+	t.Run("Teste índice e profiles do pprof", func(t *testing.T) {
+		rr := do(http.MethodGet, "/debug/pprof/", "segredo")
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "goroutine") {
+			t.Errorf("índice recebeu %d: %s", rr.Code, rr.Body.String())
+		}
+
+		rr = do(http.MethodGet, "/debug/pprof/goroutine?debug=1", "segredo")
+		if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), "goroutine profile") {
+			t.Errorf("profile goroutine recebeu %d: %s", rr.Code, rr.Body.String())
+		}
+
+		rr = do(http.MethodGet, "/debug/pprof/cmdline", "segredo")
+		if rr.Code != http.StatusOK || rr.Body.Len() == 0 {
+			t.Errorf("cmdline recebeu %d", rr.Code)
+		}
+
+		if rr = do(http.MethodGet, "/debug/pprof/naoexiste", "segredo"); rr.Code != http.StatusNotFound {
+			t.Errorf("profile desconhecido recebeu %d", rr.Code)
+		}
+	})
+
+	t.Run("Teste goroutines, rotas e runtime", func(t *testing.T) {
+		rr := do(http.MethodGet, "/debug/goroutines", "segredo")
+		if !strings.Contains(rr.Body.String(), "goroutine ") {
+			t.Errorf("dump de goroutines inesperado: %s", rr.Body.String())
+		}
+
+		var routes []RegisteredRoute
+		rr = do(http.MethodGet, "/debug/routes", "segredo")
+		if err := json.Unmarshal(rr.Body.Bytes(), &routes); err != nil || len(routes) != 5 {
+			t.Errorf("rotas inesperadas: %s", rr.Body.String())
+		}
+
+		var stats RuntimeStats
+		rr = do(http.MethodGet, "/debug/runtime", "segredo")
+		if err := json.Unmarshal(rr.Body.Bytes(), &stats); err != nil || stats.Goroutines == 0 || stats.GoVersion == "" {
+			t.Errorf("runtime inesperado: %s", rr.Body.String())
+		}
+	})
+}
+
+func TestIPAllowlist(t *testing.T) {
+	mw := IPAllowlist("127.0.0.1", "10.0.0.0/8", "::1")
+	for addr, want := range map[string]bool{
+		"127.0.0.1:5000":  true,
+		"10.20.30.40:80":  true,
+		"[::1]:8080":      true,
+		"192.168.0.1:443": false,
+		"[2001:db8::1]:1": false,
+	} {
+		t.Run("Teste IP "+addr, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = addr
+			called := false
+			err := mw(func(tc *TupaContext) error {
+				called = true
+				return nil
+			})(&TupaContext{Req: req, Resp: httptest.NewRecorder()})
+
+			if called != want {
+				t.Errorf("%s: next chamado = %v, queria %v", addr, called, want)
+			}
+			if !want {
+				if apiErr, ok := err.(APIHandlerErr); !ok || apiErr.Status != http.StatusForbidden {
+					t.Errorf("%s: esperava 403, recebeu %v", addr, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+//go:build go1.24
+
+package tupa
+
+import "net/http"
+
+// h2c sem dependências externas só existe a partir do Go 1.24 ( http.Protocols )
+const h2cSupported = true
+
+func enableH2C(server *http.Server) {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server.Protocols = protocols
+}
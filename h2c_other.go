@@ -0,0 +1,10 @@
+//go:build !go1.24
+
+package tupa
+
+import "net/http"
+
+// antes do Go 1.24 o net/http não fala h2c, EnableH2C falha na inicialização
+const h2cSupported = false
+
+func enableH2C(*http.Server) {}
@@ -0,0 +1,44 @@
+//go:build go1.24
+
+package tupa
+
+import (
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestH2C(t *testing.T) {
+	server := NewAPIServer(":8080", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/h2c/proto", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		return tc.SendString(tc.Req.Proto)
+	}}})
+	server.EnableH2C()
+	addr := serveTest(t, server)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	transport := &http.Transport{Protocols: protocols}
+	defer transport.CloseIdleConnections()
+
+	resp, err := (&http.Client{Transport: transport}).Get("http://" + addr + "/h2c/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("esperava HTTP/2 sem TLS, recebeu %q", body)
+	}
+
+	// HTTP/1.1 continua funcionando
+	resp, err = http.Get("http://" + addr + "/h2c/proto")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	if string(body) != "HTTP/1.1" {
+		t.Errorf("esperava HTTP/1.1, recebeu %q", body)
+	}
+}
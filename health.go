@@ -0,0 +1,167 @@
+package tupa
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthCheck é uma verificação nomeada, e.g. ping no banco. Check recebe um context com o prazo
+// de Timeout e deve retornar nil quando a dependência está saudável
+type HealthCheck struct {
+	Name  string
+	Check func(ctx context.Context) error
+	// Timeout padrão é DefaultHealthCheckTimeout
+	Timeout time.Duration
+	// Liveness inclui a verificação em /healthz. Sem ela a verificação só roda em /readyz, que é o
+	// certo para dependências externas: um banco fora do ar não se resolve reiniciando o processo
+	Liveness bool
+}
+
+var DefaultHealthCheckTimeout = 5 * time.Second
+
+const (
+	HealthStatusOK       = "ok"
+	HealthStatusFail     = "fail"
+	HealthStatusDraining = "draining"
+)
+
+type HealthReport struct {
+	Status string                       `json:"status"`
+	Checks map[string]HealthCheckResult `json:"checks,omitempty"`
+}
+
+type HealthCheckResult struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Health registra GET /healthz ( liveness ) e GET /readyz ( readiness ). As verificações rodam em
+// paralelo e a resposta é 200 quando todas passam e 503 quando alguma falha, com os detalhes em JSON.
+// /readyz também falha assim que Shutdown começa, para o orquestrador parar de mandar tráfego
+func (a *APIServer) Health(checks ...HealthCheck) {
+	names := map[string]bool{}
+	var liveness []HealthCheck
+	for _, check := range checks {
+		if check.Name == "" || check.Check == nil {
+			log.Fatalf("%s", FmtRed("HealthCheck precisa de Name e Check"))
+		}
+		if names[check.Name] {
+			log.Fatalf("%s%s", FmtRed("HealthCheck repetido: "), check.Name)
+		}
+		names[check.Name] = true
+		if check.Liveness {
+			liveness = append(liveness, check)
+		}
+	}
+
+	a.RegisterRoutes([]RouteInfo{
+		{
+			Path:    "/healthz",
+			Method:  MethodGet,
+			Hidden:  true,
+			Handler: healthHandler(liveness, nil),
+		},
+		{
+			Path:    "/readyz",
+			Method:  MethodGet,
+			Hidden:  true,
+			Handler: healthHandler(checks, a.Draining),
+		},
+	})
+}
+
+func healthHandler(checks []HealthCheck, draining func() bool) APIFunc {
+	return func(tc *TupaContext) error {
+		report := runHealthChecks(tc.Ctx, checks)
+		if draining != nil && draining() {
+			report.Status = HealthStatusDraining
+		}
+
+		status := http.StatusOK
+		if report.Status != HealthStatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		tc.Resp.Header().Set("Cache-Control", "no-store")
+		return WriteJSONHelper(tc.Resp, status, report)
+	}
+}
+
+func runHealthChecks(ctx context.Context, checks []HealthCheck) HealthReport {
+	report := HealthReport{Status: HealthStatusOK}
+	if len(checks) == 0 {
+		return report
+	}
+
+	report.Checks = make(map[string]HealthCheckResult, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func(check HealthCheck) {
+			defer wg.Done()
+			result := runHealthCheck(ctx, check)
+			mu.Lock()
+			report.Checks[check.Name] = result
+			mu.Unlock()
+		}(check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != HealthStatusOK {
+			report.Status = HealthStatusFail
+		}
+	}
+	return report
+}
+
+// runHealthCheck não espera além do Timeout, mesmo que Check ignore o context
+func runHealthCheck(ctx context.Context, check HealthCheck) HealthCheckResult {
+	timeout := check.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHealthCheckTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	errCh := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errCh <- fmt.Errorf("panic: %v", r)
+			}
+		}()
+		errCh <- check.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
+		err = fmt.Errorf("tempo limite de %s excedido", timeout)
+	}
+
+	result := HealthCheckResult{Status: HealthStatusOK, Duration: time.Since(start).String()}
+	if err != nil {
+		result.Status = HealthStatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Draining indica que Shutdown começou e o servidor está terminando as requests em andamento
+func (a *APIServer) Draining() bool {
+	return a.draining.Load()
+}
+
+// SetShutdownDelay define quanto tempo o servidor continua aceitando conexões depois que Shutdown
+// começa, com /readyz já falhando, para o load balancer tirar a instância antes das conexões fecharem
+func (a *APIServer) SetShutdownDelay(d time.Duration) {
+	a.shutdownDelay = d
+}
@@ -0,0 +1,120 @@
+package tupa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHealth(t *testing.T) {
+	dbUp := true
+	server := NewAPIServer(":8080", nil)
+	server.Health(
+		HealthCheck{Name: "processo", Liveness: true, Check: func(ctx context.Context) error { return nil }},
+		HealthCheck{Name: "db", Check: func(ctx context.Context) error {
+			if !dbUp {
+				return errors.New("conexão recusada")
+			}
+			return nil
+		}},
+		HealthCheck{Name: "cache", Timeout: 20 * time.Millisecond, Check: func(ctx context.Context) error {
+			<-ctx.Done()
+			return nil
+		}},
+	)
+
+	get := func(path string) (int, HealthReport) {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		var report HealthReport
+		if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+			t.Fatalf("%s: corpo não é JSON: %s", path, rr.Body.String())
+		}
+		return rr.Code, report
+	}
+
+	t.Run("Teste healthz só roda as verificações de liveness", func(t *testing.T) {
+		status, report := get("/healthz")
+		if status != http.StatusOK || report.Status != HealthStatusOK || len(report.Checks) != 1 || report.Checks["processo"].Status != HealthStatusOK {
+			t.Errorf("healthz inesperado: %d %+v", status, report)
+		}
+	})
+
+	t.Run("Teste readyz com timeout em uma verificação", func(t *testing.T) {
+		start := time.Now()
+		status, report := get("/readyz")
+		if time.Since(start) > time.Second {
+			t.Errorf("as verificações deveriam respeitar o timeout")
+		}
+		if status != http.StatusServiceUnavailable || report.Status != HealthStatusFail {
+			t.Errorf("readyz deveria falhar: %d %+v", status, report)
+		}
+		if report.Checks["db"].Status != HealthStatusOK || report.Checks["cache"].Error != "tempo limite de 20ms excedido" {
+			t.Errorf("detalhes inesperados: %+v", report.Checks)
+		}
+	})
+
+	t.Run("Teste readyz com erro na verificação", func(t *testing.T) {
+		dbUp = false
+		defer func() { dbUp = true }()
+		_, report := get("/readyz")
+		if report.Checks["db"].Status != HealthStatusFail || report.Checks["db"].Error != "conexão recusada" {
+			t.Errorf("db deveria falhar: %+v", report.Checks["db"])
+		}
+	})
+
+	t.Run("Teste readyz falha durante o shutdown", func(t *testing.T) {
+		ready := NewAPIServer(":8080", nil)
+		ready.Health()
+
+		rr := httptest.NewRecorder()
+		ready.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("readyz antes do shutdown recebeu %d", rr.Code)
+		}
+
+		ready.Shutdown()
+		rr = httptest.NewRecorder()
+		ready.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		if rr.Code != http.StatusServiceUnavailable || !ready.Draining() {
+			t.Errorf("readyz durante o shutdown recebeu %d: %s", rr.Code, rr.Body.String())
+		}
+
+		rr = httptest.NewRecorder()
+		ready.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("healthz não depende do shutdown, recebeu %d", rr.Code)
+		}
+	})
+}
+
+// TestHealthInvalidChecks roda o próprio binário de teste, já que uma configuração inválida encerra o processo
+func TestHealthInvalidChecks(t *testing.T) {
+	ok := func(context.Context) error { return nil }
+	switch os.Getenv("TUPA_TEST_HEALTH_INVALID") {
+	case "sem-nome":
+		NewAPIServer(":8080", nil).Health(HealthCheck{Check: ok})
+		return
+	case "repetido":
+		NewAPIServer(":8080", nil).Health(HealthCheck{Name: "db", Check: ok}, HealthCheck{Name: "db", Check: ok})
+		return
+	}
+
+	for name, want := range map[string]string{"sem-nome": "HealthCheck precisa de Name e Check", "repetido": "HealthCheck repetido: "} {
+		t.Run("Teste "+name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestHealthInvalidChecks$")
+			cmd.Env = append(os.Environ(), "TUPA_TEST_HEALTH_INVALID="+name)
+			out, err := cmd.CombinedOutput()
+			if err == nil || !strings.Contains(string(out), want) {
+				t.Errorf("esperava o processo encerrado com %q, recebeu %v:\n%s", want, err, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package tupa
+
+import (
+	"net"
+	"strings"
+)
+
+// hostPattern é um Host como "{tenant}.example.com". Cada {nome} captura um label inteiro
+// do domínio e os labels fixos são comparados sem diferenciar maiúsculas
+type hostPattern struct {
+	raw    string
+	labels []string
+}
+
+func parseHostPattern(host string) hostPattern {
+	if host == "" {
+		return hostPattern{}
+	}
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if !isHostParam(label) {
+			labels[i] = strings.ToLower(label)
+		}
+	}
+	return hostPattern{raw: host, labels: labels}
+}
+
+func isHostParam(label string) bool {
+	return strings.HasPrefix(label, "{") && strings.HasSuffix(label, "}")
+}
+
+// priority ordena hosts fixos antes dos com parâmetro, e esses antes do host vazio
+func (p hostPattern) priority() int {
+	if p.raw == "" {
+		return 2
+	}
+	if strings.Contains(p.raw, "{") {
+		return 1
+	}
+	return 0
+}
+
+func (p hostPattern) match(host string) (map[string]string, bool) {
+	if p.raw == "" {
+		return nil, true
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(labels) != len(p.labels) {
+		return nil, false
+	}
+
+	params := map[string]string{}
+	for i, label := range p.labels {
+		if isHostParam(label) {
+			if labels[i] == "" {
+				return nil, false
+			}
+			// DNS não diferencia maiúsculas, então o valor chega sempre em minúsculas
+			params[label[1:len(label)-1]] = strings.ToLower(labels[i])
+			continue
+		}
+		if !strings.EqualFold(label, labels[i]) {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+// AddHostRoutes funciona como AddRoutes, mas as rotas só atendem requests para o host informado.
+// Parâmetros do host, como {tenant} em "{tenant}.example.com", ficam disponíveis em tc.Param
+func AddHostRoutes(host string, groupMiddlewares MiddlewareChain, routeFuncs ...func() []RouteInfo) {
+	for _, routeFunc := range routeFuncs {
+		routes := routeFunc()
+		for i := range routes {
+			routes[i].Host = host
+		}
+		AddRoutes(groupMiddlewares, func() []RouteInfo { return routes })
+	}
+}
@@ -0,0 +1,53 @@
+package tupa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHostRouting(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	reply := func(name string) APIFunc {
+		return func(tc *TupaContext) error {
+			return tc.SendString(name + " " + tc.Param("tenant") + " " + tc.Param("id"))
+		}
+	}
+	server.RegisterRoutes([]RouteInfo{
+		{Host: "admin.example.com", Path: "/host/painel", Method: MethodGet, Handler: reply("admin")},
+		{Host: "{tenant}.example.com", Path: "/host/painel", Method: MethodGet, Handler: reply("tenant")},
+		{Host: "{tenant}.example.com", Path: "/host/pedidos/{id}", Method: MethodGet, Handler: reply("pedido")},
+		{Path: "/host/painel", Method: MethodGet, Handler: reply("qualquer")},
+	})
+
+	tests := []struct {
+		name       string
+		host       string
+		path       string
+		statusCode int
+		body       string
+	}{
+		{"host fixo tem preferência", "admin.example.com", "/host/painel", http.StatusOK, "admin  "},
+		{"parâmetro de host", "Acme.example.com:8080", "/host/painel", http.StatusOK, "tenant acme "},
+		{"parâmetros de host e path juntos", "acme.example.com", "/host/pedidos/42", http.StatusOK, "pedido acme 42"},
+		{"host sem rota específica cai na rota sem host", "outro.com", "/host/painel", http.StatusOK, "qualquer  "},
+		{"path só existe para outro host", "outro.com", "/host/pedidos/42", http.StatusNotFound, ""},
+		{"subdomínio a mais não casa", "a.b.example.com", "/host/pedidos/42", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			req.Host = tt.host
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.statusCode {
+				t.Errorf("status recebido %d, queria %d", rr.Code, tt.statusCode)
+			}
+			if tt.body != "" && rr.Body.String() != tt.body {
+				t.Errorf("corpo recebido %q, queria %q", rr.Body.String(), tt.body)
+			}
+		})
+	}
+}
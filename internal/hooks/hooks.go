@@ -0,0 +1,13 @@
+// Package hooks liga o pacote tupatest a partes internas do tupa sem que elas virem API pública.
+// As funções são preenchidas no init do pacote tupa; os tipos ficam como any porque este pacote
+// não pode importar o tupa
+package hooks
+
+import "net/http"
+
+var (
+	// NewContext recebe um *tupa.APIServer e retorna o *tupa.TupaContext da request ligado a ele
+	NewContext func(server any, w http.ResponseWriter, r *http.Request) any
+	// RunFinishers roda os finishers de um *tupa.TupaContext, como o router faz no fim da request
+	RunFinishers func(tc any)
+)
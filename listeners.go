@@ -0,0 +1,299 @@
+package tupa
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenerSpec é um endereço passado para AddListener, aberto só em Start
+type listenerSpec struct {
+	raw     string
+	network string
+	addr    string
+	// mode é a permissão do arquivo do socket unix
+	mode    fs.FileMode
+	systemd bool
+	// ln é um listener já aberto ( AddNetListener )
+	ln net.Listener
+}
+
+// AddListener faz o servidor atender também em addr, com o mesmo router e o mesmo ciclo de vida
+// do listenAddr. Formatos aceitos:
+//
+//	":8080", "tcp://127.0.0.1:8080"    TCP
+//	"unix:///run/app.sock?mode=0660"   socket unix, o arquivo antigo é removido antes de abrir
+//	"systemd" ou "systemd:http"        sockets da ativação do systemd ( LISTEN_FDS ), todos ou os
+//	                                   com o nome em FileDescriptorName=
+//
+// Com SetTLS os listeners TCP e do systemd atendem HTTPS, os unix continuam em HTTP
+func (a *APIServer) AddListener(addr string) error {
+	spec, err := parseListenAddr(addr)
+	if err != nil {
+		return err
+	}
+	a.listenerSpecs = append(a.listenerSpecs, spec)
+	return nil
+}
+
+// AddNetListener serve em um listener aberto por outra parte do programa
+func (a *APIServer) AddNetListener(ln net.Listener) {
+	a.listenerSpecs = append(a.listenerSpecs, listenerSpec{raw: ln.Addr().String(), ln: ln})
+}
+
+func parseListenAddr(addr string) (listenerSpec, error) {
+	spec := listenerSpec{raw: addr}
+	switch {
+	case addr == "systemd" || strings.HasPrefix(addr, "systemd:"):
+		spec.systemd = true
+		spec.addr = strings.TrimPrefix(strings.TrimPrefix(addr, "systemd"), ":")
+	case strings.HasPrefix(addr, "unix:"):
+		u, err := url.Parse(addr)
+		if err != nil {
+			return spec, fmt.Errorf("endereço unix inválido %q: %w", addr, err)
+		}
+		spec.network, spec.addr = "unix", u.Path
+		if spec.addr == "" {
+			// unix:app.sock, relativo ao diretório atual
+			spec.addr = u.Opaque
+		}
+		if spec.addr == "" {
+			return spec, fmt.Errorf("endereço unix sem path %q", addr)
+		}
+		if mode := u.Query().Get("mode"); mode != "" {
+			m, err := strconv.ParseUint(mode, 8, 32)
+			if err != nil {
+				return spec, fmt.Errorf("mode inválido em %q: %w", addr, err)
+			}
+			spec.mode = fs.FileMode(m)
+		}
+	default:
+		spec.network, spec.addr = "tcp", strings.TrimPrefix(addr, "tcp://")
+		if _, _, err := net.SplitHostPort(spec.addr); err != nil {
+			return spec, fmt.Errorf("endereço TCP inválido %q: %w", addr, err)
+		}
+	}
+	return spec, nil
+}
+
+// boundListener é um listener aberto junto com o endereço de onde veio, que é o nome usado para
+// passar o socket para o processo novo em Restart
+type boundListener struct {
+	name string
+	net.Listener
+	// redirect é o listener do redirect HTTP→HTTPS de TLSConfig.HTTPRedirectAddr, fora do router
+	redirect bool
+}
+
+// openListeners abre o listenAddr ( se não for vazio ) e os listeners de AddListener
+func (a *APIServer) openListeners() ([]boundListener, error) {
+	specs := a.listenerSpecs
+	if a.listenAddr != "" {
+		spec, err := parseListenAddr(a.listenAddr)
+		if err != nil {
+			return nil, err
+		}
+		specs = append([]listenerSpec{spec}, specs...)
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("nenhum endereço para escutar")
+	}
+
+	var listeners []boundListener
+	closeAll := func() {
+		for _, ln := range listeners {
+			ln.Close()
+		}
+	}
+	for _, spec := range specs {
+		opened, err := spec.open()
+		if err != nil {
+			closeAll()
+			return nil, fmt.Errorf("%s: %w", spec.raw, err)
+		}
+		for _, ln := range opened {
+			listeners = append(listeners, boundListener{name: spec.raw, Listener: ln})
+		}
+	}
+	return listeners, nil
+}
+
+func (s listenerSpec) open() ([]net.Listener, error) {
+	if s.ln != nil {
+		return []net.Listener{s.ln}, nil
+	}
+	// o processo anterior passou o socket em Restart
+	inherited, err := inheritedListenersNamed(s.raw)
+	if err != nil || len(inherited) > 0 {
+		return inherited, err
+	}
+
+	switch {
+	case s.systemd:
+		return systemdListenersNamed(s.addr)
+	case s.network == "unix":
+		// um socket que ficou de uma execução anterior impede o Listen
+		if info, err := os.Stat(s.addr); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			os.Remove(s.addr)
+		}
+		ln, err := net.Listen("unix", s.addr)
+		if err != nil {
+			return nil, err
+		}
+		if s.mode != 0 {
+			if err := os.Chmod(s.addr, s.mode); err != nil {
+				ln.Close()
+				return nil, err
+			}
+		}
+		return []net.Listener{ln}, nil
+	}
+	ln, err := net.Listen(s.network, s.addr)
+	if err != nil {
+		return nil, err
+	}
+	return []net.Listener{ln}, nil
+}
+
+// Start abre todos os listeners e começa a atender sem bloquear, junto com o reload dos certificados e o
+// redirect HTTP de Config.TLS. New() chama Start e espera um sinal para o Shutdown; quem controla o
+// próprio ciclo de vida pode chamar Start e Shutdown
+func (a *APIServer) Start() error {
+	a.server = a.newHTTPServer()
+
+	listeners, err := a.openListeners()
+	if err != nil {
+		closeInheritedListeners()
+		return err
+	}
+	a.listeners = listeners
+
+	for _, l := range listeners {
+		ln := l.Listener
+		fmt.Println(FmtBlue("Servidor iniciado em: " + ln.Addr().Network() + " " + ln.Addr().String()))
+		go func(ln net.Listener) {
+			var err error
+			if a.tlsConfig != nil && ln.Addr().Network() != "unix" {
+				// o certificado vem do GetCertificate do CertReloader
+				err = a.server.ServeTLS(ln, "", "")
+			} else {
+				err = a.server.Serve(ln)
+			}
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(FmtRed("Erro ao iniciar servidor: "), err)
+			}
+		}(ln)
+	}
+	err = a.startTLSServices()
+	// sockets herdados que nenhum endereço usou ( o processo novo tirou um listener da config )
+	// ficariam abertos sem ninguém aceitar conexões
+	closeInheritedListeners()
+	if err != nil {
+		for _, l := range listeners {
+			l.Close()
+		}
+		return err
+	}
+	// se este processo veio de um Restart, avisa o anterior que já está atendendo
+	signalRestartReady()
+	return nil
+}
+
+// Addrs retorna os endereços em que o servidor está escutando depois de Start, útil com a porta 0
+func (a *APIServer) Addrs() []net.Addr {
+	addrs := make([]net.Addr, 0, len(a.listeners))
+	for _, ln := range a.listeners {
+		if !ln.redirect {
+			addrs = append(addrs, ln.Addr())
+		}
+	}
+	return addrs
+}
+
+type systemdListener struct {
+	name string
+	ln   net.Listener
+}
+
+var (
+	systemdOnce      sync.Once
+	systemdInherited []systemdListener
+	systemdErr       error
+)
+
+// systemdListenersNamed devolve os sockets passados pelo systemd ( sd_listen_fds ). Os descritores
+// só podem ser usados uma vez, então são lidos uma vez por processo e as variáveis são removidas
+func systemdListenersNamed(name string) ([]net.Listener, error) {
+	systemdOnce.Do(func() {
+		systemdInherited, systemdErr = systemdListeners()
+	})
+	if systemdErr != nil {
+		return nil, systemdErr
+	}
+
+	var listeners []net.Listener
+	for _, l := range systemdInherited {
+		if name == "" || l.name == name {
+			listeners = append(listeners, l.ln)
+		}
+	}
+	if len(listeners) == 0 {
+		if name == "" {
+			return nil, errors.New("nenhum socket recebido do systemd ( LISTEN_FDS )")
+		}
+		return nil, fmt.Errorf("nenhum socket do systemd com o nome %q", name)
+	}
+	return listeners, nil
+}
+
+// o systemd passa os sockets a partir do descritor 3, Restart faz o mesmo
+const systemdFirstFD = 3
+
+func systemdListeners() ([]systemdListener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		// as variáveis são de outro processo ( herdadas por engano ) ou não existem
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make([]systemdListener, 0, count)
+	for i := 0; i < count; i++ {
+		name := "LISTEN_FD_" + strconv.Itoa(systemdFirstFD+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		ln, err := fileListener(systemdFirstFD+i, name)
+		if err != nil {
+			return nil, fmt.Errorf("socket %s do systemd: %w", name, err)
+		}
+		listeners = append(listeners, systemdListener{name: name, ln: ln})
+	}
+	return listeners, nil
+}
+
+func fileListener(fd int, name string) (net.Listener, error) {
+	file := os.NewFile(uintptr(fd), name)
+	ln, err := net.FileListener(file)
+	// FileListener duplica o descritor, o original pode ser fechado
+	file.Close()
+	return ln, err
+}
@@ -0,0 +1,157 @@
+package tupa
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestMultipleListeners(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "tupa.sock")
+	// socket que ficou de uma execução anterior
+	stale, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip("sem suporte a socket unix: ", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	extra, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewAPIServer("127.0.0.1:0", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/listeners/ping", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		return tc.SendString("pong")
+	}}})
+	if err := server.AddListener("unix://" + socket + "?mode=0660"); err != nil {
+		t.Fatal(err)
+	}
+	server.AddNetListener(extra)
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := server.Addrs()
+	if len(addrs) != 3 {
+		t.Fatalf("esperava 3 listeners, recebeu %v", addrs)
+	}
+
+	get := func(addr net.Addr) (string, error) {
+		transport := &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, addr.Network(), addr.String())
+		}}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("http://tupa/listeners/ping")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	t.Run("Teste mesmo router em todos os listeners", func(t *testing.T) {
+		for _, addr := range addrs {
+			if body, err := get(addr); err != nil || body != "pong" {
+				t.Errorf("%s %s: recebeu %q %v", addr.Network(), addr, body, err)
+			}
+		}
+		info, err := os.Stat(socket)
+		if err != nil || info.Mode().Perm() != 0o660 {
+			t.Errorf("permissão do socket inesperada: %v %v", info.Mode(), err)
+		}
+	})
+
+	t.Run("Teste shutdown fecha todos os listeners", func(t *testing.T) {
+		server.Shutdown()
+		for _, addr := range addrs {
+			if _, err := get(addr); err == nil {
+				t.Errorf("%s %s continua aceitando conexões", addr.Network(), addr)
+			}
+		}
+		if _, err := os.Stat(socket); !os.IsNotExist(err) {
+			t.Errorf("arquivo do socket deveria ser removido")
+		}
+	})
+}
+
+func TestParseListenAddr(t *testing.T) {
+	for addr, want := range map[string]listenerSpec{
+		":8080":                       {network: "tcp", addr: ":8080"},
+		"tcp://127.0.0.1:9000":        {network: "tcp", addr: "127.0.0.1:9000"},
+		"unix:///run/app.sock":        {network: "unix", addr: "/run/app.sock"},
+		"unix:app.sock":               {network: "unix", addr: "app.sock"},
+		"unix:///tmp/a.sock?mode=600": {network: "unix", addr: "/tmp/a.sock", mode: 0o600},
+		"systemd":                     {systemd: true},
+		"systemd:http":                {systemd: true, addr: "http"},
+	} {
+		t.Run("Teste "+addr, func(t *testing.T) {
+			got, err := parseListenAddr(addr)
+			want.raw = addr
+			if err != nil || got != want {
+				t.Errorf("recebeu %+v %v, queria %+v", got, err, want)
+			}
+		})
+	}
+
+	for _, addr := range []string{"8080", "unix://", "unix:///a.sock?mode=rw"} {
+		t.Run("Teste inválido "+addr, func(t *testing.T) {
+			if _, err := parseListenAddr(addr); err == nil {
+				t.Errorf("esperava erro")
+			}
+		})
+	}
+}
+
+// TestSystemdActivation roda o próprio binário de teste com o socket no descritor 3, como o systemd faz
+func TestSystemdActivation(t *testing.T) {
+	if os.Getenv("TUPA_TEST_SYSTEMD_CHILD") == "1" {
+		// o pid só é conhecido dentro do processo filho
+		os.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		listeners, err := systemdListenersNamed("http")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := listeners[0].Addr().String(); got != os.Getenv("TUPA_TEST_SYSTEMD_ADDR") {
+			t.Fatalf("socket recebido %s, queria %s", got, os.Getenv("TUPA_TEST_SYSTEMD_ADDR"))
+		}
+		if os.Getenv("LISTEN_FDS") != "" {
+			t.Fatalf("LISTEN_FDS deveria ser removido")
+		}
+		if _, err := systemdListenersNamed("admin"); err == nil {
+			t.Fatalf("esperava erro para nome inexistente")
+		}
+		return
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	file, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestSystemdActivation$")
+	cmd.ExtraFiles = []*os.File{file}
+	cmd.Env = append(os.Environ(),
+		"TUPA_TEST_SYSTEMD_CHILD=1",
+		"TUPA_TEST_SYSTEMD_ADDR="+ln.Addr().String(),
+		"LISTEN_FDS=1",
+		"LISTEN_FDNAMES=http",
+	)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Errorf("processo filho falhou: %v\n%s", err, out)
+	}
+}
@@ -0,0 +1,413 @@
+package tupa
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsRegistry guarda métricas e as exporta no formato texto do Prometheus, sem dependências.
+// Além das métricas HTTP do middleware Metrics, a aplicação pode criar as suas com NewCounter,
+// NewGauge, NewHistogram e GaugeFunc
+type MetricsRegistry struct {
+	mu      sync.RWMutex
+	metrics map[string]*metric
+}
+
+func NewMetricsRegistry() *MetricsRegistry {
+	return &MetricsRegistry{metrics: map[string]*metric{}}
+}
+
+// DefaultMetricsRegistry é usado pelo middleware Metrics quando MetricsConfig.Registry é nulo
+var DefaultMetricsRegistry = NewMetricsRegistry()
+
+type metricType string
+
+const (
+	counterType   metricType = "counter"
+	gaugeType     metricType = "gauge"
+	histogramType metricType = "histogram"
+)
+
+type metric struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+	fn      func() float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// só para histogramas
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+func (r *MetricsRegistry) register(name, help string, typ metricType, buckets []float64, labels []string) *metric {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.metrics[name]; ok {
+		// registrar de novo com a mesma definição devolve a métrica existente
+		if m.typ != typ || strings.Join(m.labels, ",") != strings.Join(labels, ",") {
+			log.Fatalf("%s%s", FmtRed("Métrica já registrada com outro tipo ou labels: "), name)
+		}
+		return m
+	}
+
+	m := &metric{name: name, help: help, typ: typ, labels: labels, buckets: buckets, series: map[string]*series{}}
+	r.metrics[name] = m
+	return m
+}
+
+// with devolve a série dos labels. Com a quantidade errada de labels o erro vai para o log e a
+// amostra é descartada, já que isso roda no meio de uma request
+func (m *metric) with(labelValues []string) *series {
+	if len(labelValues) != len(m.labels) {
+		slog.Error("Metrics", "err:", fmt.Sprintf("métrica %s espera %d labels, recebeu %d", m.name, len(m.labels), len(labelValues)))
+		return &series{counts: make([]uint64, len(m.buckets))}
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if m.typ == histogramType {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+type Counter struct{ m *metric }
+
+// CounterSeries é um counter com os valores dos labels já definidos
+type CounterSeries struct {
+	m *metric
+	s *series
+}
+
+func (r *MetricsRegistry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{m: r.register(name, help, counterType, nil, labels)}
+}
+
+func (c *Counter) With(labelValues ...string) CounterSeries {
+	return CounterSeries{m: c.m, s: c.m.with(labelValues)}
+}
+
+func (c *Counter) Inc() { c.With().Inc() }
+
+func (c CounterSeries) Inc() { c.Add(1) }
+
+// Add soma v ao counter. Counters só crescem, então valores negativos são ignorados
+func (c CounterSeries) Add(v float64) {
+	if v < 0 {
+		return
+	}
+	c.m.mu.Lock()
+	c.s.value += v
+	c.m.mu.Unlock()
+}
+
+type Gauge struct{ m *metric }
+
+type GaugeSeries struct {
+	m *metric
+	s *series
+}
+
+func (r *MetricsRegistry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{m: r.register(name, help, gaugeType, nil, labels)}
+}
+
+// GaugeFunc registra um gauge cujo valor é lido de fn a cada coleta, e.g. tamanho de uma fila
+func (r *MetricsRegistry) GaugeFunc(name, help string, fn func() float64) {
+	m := r.register(name, help, gaugeType, nil, nil)
+	m.mu.Lock()
+	m.fn = fn
+	m.mu.Unlock()
+}
+
+func (g *Gauge) With(labelValues ...string) GaugeSeries {
+	return GaugeSeries{m: g.m, s: g.m.with(labelValues)}
+}
+
+func (g *Gauge) Set(v float64) { g.With().Set(v) }
+
+func (g GaugeSeries) Set(v float64) {
+	g.m.mu.Lock()
+	g.s.value = v
+	g.m.mu.Unlock()
+}
+
+func (g GaugeSeries) Add(v float64) {
+	g.m.mu.Lock()
+	g.s.value += v
+	g.m.mu.Unlock()
+}
+
+func (g GaugeSeries) Inc() { g.Add(1) }
+func (g GaugeSeries) Dec() { g.Add(-1) }
+
+type Histogram struct{ m *metric }
+
+type HistogramSeries struct {
+	m *metric
+	s *series
+}
+
+// DefaultLatencyBuckets são os mesmos buckets padrão do client oficial do Prometheus, em segundos
+var DefaultLatencyBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// DefaultSizeBuckets vão de 100 bytes a 10 MB
+var DefaultSizeBuckets = []float64{100, 1000, 10_000, 100_000, 1_000_000, 10_000_000}
+
+func (r *MetricsRegistry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefaultLatencyBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &Histogram{m: r.register(name, help, histogramType, buckets, labels)}
+}
+
+func (h *Histogram) With(labelValues ...string) HistogramSeries {
+	return HistogramSeries{m: h.m, s: h.m.with(labelValues)}
+}
+
+func (h *Histogram) Observe(v float64) { h.With().Observe(v) }
+
+func (h HistogramSeries) Observe(v float64) {
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	// os buckets são cumulativos só na exportação, aqui cada valor conta em um bucket só
+	if i := sort.SearchFloat64s(h.m.buckets, v); i < len(h.m.buckets) {
+		h.s.counts[i]++
+	}
+	h.s.sum += v
+	h.s.count++
+}
+
+// Handler retorna um APIFunc que exporta as métricas, para registrar com RegisterRoutes, e.g.
+// {Path: "/metrics", Method: tupa.MethodGet, Handler: registry.Handler()}
+func (r *MetricsRegistry) Handler() APIFunc {
+	return func(tc *TupaContext) error {
+		tc.Resp.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		tc.Resp.WriteHeader(http.StatusOK)
+		_, err := tc.Resp.Write([]byte(r.Expose()))
+		return err
+	}
+}
+
+// Expose monta o texto no formato de exposição do Prometheus, com as métricas em ordem alfabética
+func (r *MetricsRegistry) Expose() string {
+	// o map pode receber métricas novas enquanto exporta, então só a lista sai do lock
+	r.mu.RLock()
+	metrics := make([]*metric, 0, len(r.metrics))
+	for _, m := range r.metrics {
+		metrics = append(metrics, m)
+	}
+	r.mu.RUnlock()
+	sort.Slice(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	var b strings.Builder
+	for _, m := range metrics {
+		m.write(&b)
+	}
+	return b.String()
+}
+
+func (m *metric) write(b *strings.Builder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.help != "" {
+		b.WriteString("# HELP " + m.name + " " + strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(m.help) + "\n")
+	}
+	b.WriteString("# TYPE " + m.name + " " + string(m.typ) + "\n")
+
+	if m.fn != nil {
+		b.WriteString(m.name + " " + formatMetricValue(m.fn()) + "\n")
+		return
+	}
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.typ != histogramType {
+			b.WriteString(m.name + formatLabels(m.labels, s.labelValues, "", "") + " " + formatMetricValue(s.value) + "\n")
+			continue
+		}
+
+		var cumulative uint64
+		for i, upper := range m.buckets {
+			cumulative += s.counts[i]
+			b.WriteString(m.name + "_bucket" + formatLabels(m.labels, s.labelValues, "le", formatMetricValue(upper)) +
+				" " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		b.WriteString(m.name + "_bucket" + formatLabels(m.labels, s.labelValues, "le", "+Inf") + " " + strconv.FormatUint(s.count, 10) + "\n")
+		b.WriteString(m.name + "_sum" + formatLabels(m.labels, s.labelValues, "", "") + " " + formatMetricValue(s.sum) + "\n")
+		b.WriteString(m.name + "_count" + formatLabels(m.labels, s.labelValues, "", "") + " " + strconv.FormatUint(s.count, 10) + "\n")
+	}
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	parts := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		parts = append(parts, name+`="`+labelValueReplacer.Replace(values[i])+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatMetricValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type MetricsConfig struct {
+	// Registry recebe as métricas. Nulo usa DefaultMetricsRegistry
+	Registry *MetricsRegistry
+	// Namespace é o prefixo dos nomes. Vazio usa "tupa"
+	Namespace      string
+	LatencyBuckets []float64
+	SizeBuckets    []float64
+}
+
+// Metrics mede cada request: total por método, rota e status, latência, requests em andamento e
+// tamanho da resposta. O label route é o pattern da rota ( "/users/{id}" ) e não o path da request,
+// para o número de séries não crescer sem limite. Requests sem rota ficam com route="unmatched"
+func Metrics(cfg ...MetricsConfig) MiddlewareFunc {
+	config := MetricsConfig{}
+	if len(cfg) > 0 {
+		config = cfg[0]
+	}
+	if config.Registry == nil {
+		config.Registry = DefaultMetricsRegistry
+	}
+	if config.Namespace == "" {
+		config.Namespace = "tupa"
+	}
+	if len(config.SizeBuckets) == 0 {
+		config.SizeBuckets = DefaultSizeBuckets
+	}
+
+	reg, ns := config.Registry, config.Namespace
+	requests := reg.NewCounter(ns+"_http_requests_total", "Total de requests HTTP.", "method", "route", "status")
+	duration := reg.NewHistogram(ns+"_http_request_duration_seconds", "Duração das requests HTTP em segundos.", config.LatencyBuckets, "method", "route")
+	inFlight := reg.NewGauge(ns+"_http_requests_in_flight", "Requests HTTP em andamento.", "method", "route")
+	size := reg.NewHistogram(ns+"_http_response_size_bytes", "Tamanho do corpo das respostas HTTP em bytes.", config.SizeBuckets, "method", "route")
+
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			start := time.Now()
+			method := tc.Req.Method
+			route := tc.RoutePattern()
+			if route == "" {
+				route = "unmatched"
+			}
+
+			gauge := inFlight.With(method, route)
+			gauge.Inc()
+
+			mw := &statusResponseWriter{ResponseWriter: tc.Resp}
+			tc.Resp = mw
+			tc.onFinish(func() {
+				gauge.Dec()
+				status := mw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				requests.With(method, route, strconv.Itoa(status)).Inc()
+				duration.With(method, route).Observe(time.Since(start).Seconds())
+				size.With(method, route).Observe(float64(mw.written))
+			})
+
+			return next(tc)
+		}
+	}
+}
+
+// statusResponseWriter guarda o status e quantos bytes foram escritos
+type statusResponseWriter struct {
+	http.ResponseWriter
+	mu      sync.Mutex
+	status  int
+	written int64
+}
+
+func (w *statusResponseWriter) WriteHeader(status int) {
+	w.mu.Lock()
+	if w.status == 0 {
+		w.status = status
+	}
+	w.mu.Unlock()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusResponseWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.mu.Lock()
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.written += int64(n)
+	w.mu.Unlock()
+	return n, err
+}
+
+func (w *statusResponseWriter) Flush() {
+	http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack registra o 101 de um upgrade ( WebSocket ) antes de entregar a conexão
+func (w *statusResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.mu.Lock()
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	w.mu.Unlock()
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+func (w *statusResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,186 @@
+package tupa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := NewMetricsRegistry()
+	server := NewAPIServer(":8080", nil)
+	server.UseGlobalMiddlewares(Metrics(MetricsConfig{Registry: registry}))
+	server.RegisterRoutes([]RouteInfo{
+		{Path: "/metricas/users/{id}", Method: MethodGet, Handler: func(tc *TupaContext) error {
+			return tc.SendString("user " + tc.Param("id"))
+		}},
+		{Path: "/metricas/erro", Method: MethodGet, Handler: func(tc *TupaContext) error {
+			return tc.SendString("ok")
+		}, Middlewares: []MiddlewareFunc{func(next APIFunc) APIFunc {
+			return func(tc *TupaContext) error {
+				tc.Resp.WriteHeader(http.StatusTeapot)
+				return nil
+			}
+		}}},
+		{Path: "/metrics", Method: MethodGet, Handler: registry.Handler()},
+	})
+
+	do := func(path string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr
+	}
+
+	do("/metricas/users/1")
+	do("/metricas/users/2")
+	do("/metricas/erro")
+	do("/metricas/nada")
+
+	rr := do("/metrics")
+	body := rr.Body.String()
+
+	t.Run("Teste content type da exposição", func(t *testing.T) {
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+			t.Errorf("Content-Type recebido %q", ct)
+		}
+	})
+
+	t.Run("Teste label route usa o pattern e não o path", func(t *testing.T) {
+		want := `tupa_http_requests_total{method="GET",route="/metricas/users/{id}",status="200"} 2`
+		if !strings.Contains(body, want) {
+			t.Errorf("esperava %q em:\n%s", want, body)
+		}
+		if strings.Contains(body, "/metricas/users/1") {
+			t.Errorf("path da request não deveria virar label:\n%s", body)
+		}
+	})
+
+	t.Run("Teste status e requests sem rota", func(t *testing.T) {
+		for _, want := range []string{
+			`tupa_http_requests_total{method="GET",route="/metricas/erro",status="418"} 1`,
+			`tupa_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("esperava %q em:\n%s", want, body)
+			}
+		}
+	})
+
+	t.Run("Teste histogramas e in flight", func(t *testing.T) {
+		for _, want := range []string{
+			"# TYPE tupa_http_request_duration_seconds histogram",
+			`tupa_http_request_duration_seconds_bucket{method="GET",route="/metricas/users/{id}",le="+Inf"} 2`,
+			`tupa_http_request_duration_seconds_count{method="GET",route="/metricas/users/{id}"} 2`,
+			`tupa_http_response_size_bytes_bucket{method="GET",route="/metricas/users/{id}",le="100"} 2`,
+			`tupa_http_response_size_bytes_sum{method="GET",route="/metricas/users/{id}"} 12`,
+			`tupa_http_requests_in_flight{method="GET",route="/metricas/users/{id}"} 0`,
+			// a própria request de /metrics ainda está em andamento
+			`tupa_http_requests_in_flight{method="GET",route="/metrics"} 1`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("esperava %q em:\n%s", want, body)
+			}
+		}
+	})
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	t.Run("Teste métricas da aplicação", func(t *testing.T) {
+		registry := NewMetricsRegistry()
+		jobs := registry.NewCounter("jobs_total", "Jobs processados.", "queue")
+		jobs.With("emails").Inc()
+		jobs.With("emails").Add(2)
+		jobs.With(`fila "lenta"`).Inc()
+
+		temp := registry.NewGauge("temperatura", "")
+		temp.Set(21.5)
+
+		registry.GaugeFunc("fila_tamanho", "Itens na fila.", func() float64 { return 7 })
+
+		hist := registry.NewHistogram("job_segundos", "Duração dos jobs.", []float64{1, 0.5})
+		hist.Observe(0.2)
+		hist.Observe(0.7)
+		hist.Observe(3)
+
+		want := `# HELP fila_tamanho Itens na fila.
+# TYPE fila_tamanho gauge
+fila_tamanho 7
+# HELP job_segundos Duração dos jobs.
+# TYPE job_segundos histogram
+job_segundos_bucket{le="0.5"} 1
+job_segundos_bucket{le="1"} 2
+job_segundos_bucket{le="+Inf"} 3
+job_segundos_sum 3.9
+job_segundos_count 3
+# HELP jobs_total Jobs processados.
+# TYPE jobs_total counter
+jobs_total{queue="emails"} 3
+jobs_total{queue="fila \"lenta\""} 1
+# TYPE temperatura gauge
+temperatura 21.5
+`
+		if got := registry.Expose(); got != want {
+			t.Errorf("exposição recebida:\n%s\nqueria:\n%s", got, want)
+		}
+	})
+
+	t.Run("Teste registrar de novo devolve a mesma métrica", func(t *testing.T) {
+		registry := NewMetricsRegistry()
+		registry.NewCounter("c", "", "a").With("x").Inc()
+		registry.NewCounter("c", "", "a").With("x").Inc()
+		if got := registry.Expose(); !strings.Contains(got, `c{a="x"} 2`) {
+			t.Errorf("esperava contador compartilhado:\n%s", got)
+		}
+	})
+
+	t.Run("Teste quantidade errada de labels descarta a amostra", func(t *testing.T) {
+		registry := NewMetricsRegistry()
+		counter := registry.NewCounter("c", "", "a")
+		counter.With("x", "y").Inc()
+		counter.Inc()
+		if got := registry.Expose(); strings.Contains(got, "c{") || strings.Contains(got, "\nc ") {
+			t.Errorf("amostras com labels errados deveriam ser descartadas:\n%s", got)
+		}
+	})
+
+	t.Run("Teste registro concorrente com a exportação", func(t *testing.T) {
+		registry := NewMetricsRegistry()
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				registry.NewCounter("c_"+strconv.Itoa(i), "").Inc()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				registry.Expose()
+			}
+		}()
+		wg.Wait()
+	})
+}
+
+// TestMetricsRedefinition roda o próprio binário de teste, já que uma definição diferente encerra o processo
+func TestMetricsRedefinition(t *testing.T) {
+	if os.Getenv("TUPA_TEST_METRICS_REDEFINE") == "1" {
+		registry := NewMetricsRegistry()
+		registry.NewCounter("c", "", "a")
+		registry.NewGauge("c", "", "a")
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestMetricsRedefinition$")
+	cmd.Env = append(os.Environ(), "TUPA_TEST_METRICS_REDEFINE=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "Métrica já registrada com outro tipo ou labels") {
+		t.Errorf("esperava o processo encerrado, recebeu %v:\n%s", err, out)
+	}
+}
@@ -31,11 +31,18 @@ func (chain *MiddlewareChain) execute(tc *TupaContext) error {
 			return nil
 		})
 
+		// com o middleware Tracing ativo cada middleware ganha um span filho
+		span := startMiddlewareSpan(tc, middleware)
+
 		// chamando a função retornada por middleware(...)
 		if err := next(tc); err != nil {
+			span.RecordError(err)
+			span.SetStatus(StatusError, err.Error())
+			span.End()
 			// se qualquer middleware na chain tiver erro vai retornar o erro
 			return err
 		}
+		span.End()
 	}
 
 	return nil
@@ -0,0 +1,31 @@
+package tupa
+
+import "net/http"
+
+// SetNotFoundHandler troca a resposta para requests que não casam com nenhuma rota.
+// O handler roda depois dos middlewares globais, como uma rota normal
+func (a *APIServer) SetNotFoundHandler(handler APIFunc) {
+	a.router.NotFound = a.fallbackHandler(handler)
+}
+
+// SetMethodNotAllowedHandler troca a resposta para quando o path existe mas não para o método da
+// request. O header Allow já vem preenchido em tc.Resp com os métodos aceitos
+func (a *APIServer) SetMethodNotAllowedHandler(handler APIFunc) {
+	a.router.MethodNotAllowed = a.fallbackHandler(handler)
+}
+
+func defaultNotFoundHandler(tc *TupaContext) error {
+	return APIHandlerErr{Status: http.StatusNotFound, Msg: "Rota não encontrada"}
+}
+
+func defaultMethodNotAllowedHandler(tc *TupaContext) error {
+	return APIHandlerErr{Status: http.StatusMethodNotAllowed, Msg: "Método HTTP não permitido"}
+}
+
+// fallbackHandler passa o handler pelo mesmo caminho das rotas ( middlewares globais, limite
+// de corpo, erros em JSON ), aceitando qualquer método
+func (a *APIServer) fallbackHandler(handler APIFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.MakeHTTPHandlerFuncHelper(RouteInfo{Method: HTTPMethod(r.Method), Handler: handler})(w, r)
+	})
+}
@@ -0,0 +1,98 @@
+package tupa
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNotFoundAndMethodNotAllowed(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	server.UseGlobalMiddlewares(func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			tc.Resp.Header().Set("X-Global", "sim")
+			return next(tc)
+		}
+	})
+	handler := func(tc *TupaContext) error { return tc.SendString(tc.Req.Method) }
+	server.RegisterRoutes([]RouteInfo{
+		{Path: "/", Method: MethodGet, Handler: handler},
+		{Path: "/fallback/users", Method: MethodGet, Handler: handler},
+		{Path: "/fallback/users", Method: MethodPost, Handler: handler},
+	})
+
+	t.Run("Teste mesmo path com métodos diferentes", func(t *testing.T) {
+		for _, method := range []string{http.MethodGet, http.MethodPost} {
+			req := httptest.NewRequest(method, "/fallback/users", nil)
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK || rr.Body.String() != method {
+				t.Errorf("%s: recebeu %d %q", method, rr.Code, rr.Body.String())
+			}
+		}
+	})
+
+	t.Run("Teste 404 padrão em JSON", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/fallback/nada", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		var body APIError
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		if rr.Code != http.StatusNotFound || body.Error != "Rota não encontrada" {
+			t.Errorf("recebeu %d %q", rr.Code, rr.Body.String())
+		}
+		if rr.Header().Get("X-Global") != "sim" {
+			t.Error("middlewares globais não rodaram no 404")
+		}
+	})
+
+	t.Run("Teste 405 padrão com header Allow", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodDelete, "/fallback/users", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusMethodNotAllowed {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusMethodNotAllowed)
+		}
+		if got := rr.Header().Get("Allow"); got != "GET, HEAD, POST" {
+			t.Errorf("Allow recebido %q", got)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "application/json" {
+			t.Errorf("Content-Type recebido %q", got)
+		}
+	})
+
+	t.Run("Teste handlers customizados", func(t *testing.T) {
+		custom := NewAPIServer(":8080", nil)
+		custom.RegisterRoutes([]RouteInfo{{Path: "/fallback/custom", Method: MethodGet, Handler: handler}})
+		custom.SetNotFoundHandler(func(tc *TupaContext) error {
+			return WriteJSONHelper(tc.Resp, http.StatusNotFound, map[string]string{"path": tc.Req.URL.Path})
+		})
+		custom.SetMethodNotAllowedHandler(func(tc *TupaContext) error {
+			return APIHandlerErr{Status: http.StatusMethodNotAllowed, Msg: "use " + tc.Resp.Header().Get("Allow")}
+		})
+
+		rr := httptest.NewRecorder()
+		custom.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/fallback/outro", nil))
+		if rr.Body.String() != "{\"path\":\"/fallback/outro\"}\n" {
+			t.Errorf("404 customizado recebido %q", rr.Body.String())
+		}
+
+		rr = httptest.NewRecorder()
+		custom.router.ServeHTTP(rr, httptest.NewRequest(http.MethodPut, "/fallback/custom", nil))
+		if rr.Body.String() != "{\"Error\":\"use GET, HEAD\"}\n" {
+			t.Errorf("405 customizado recebido %q", rr.Body.String())
+		}
+	})
+
+	t.Run("Teste rota / não engole os outros paths", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/", nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("status de / recebido %d", rr.Code)
+		}
+	})
+}
@@ -0,0 +1,614 @@
+package tupa
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"math"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// OpenAPISecurityScheme segue o Security Scheme Object da especificação, e.g.
+// {Type: "http", Scheme: "bearer", BearerFormat: "JWT"} ou {Type: "apiKey", In: "header", Name: "X-API-Key"}
+type OpenAPISecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+type OpenAPIConfig struct {
+	Info            OpenAPIInfo
+	Servers         []string
+	SecuritySchemes map[string]OpenAPISecurityScheme
+	// Path é onde o documento é servido, com .json e .yaml no final. Vazio usa "/openapi"
+	Path string
+	// DocsPath é a página com a UI. Vazio usa "/docs" e "-" desliga a página
+	DocsPath string
+	// UI escolhe entre "swagger" ( padrão ) e "redoc". Os assets vêm do CDN em versões fixas
+	// ( SwaggerUIVersion e RedocVersion ) ou de Assets. Veja DocsCSP para o CSP que a página precisa
+	UI string
+	// Assets serve a UI do próprio servidor em DocsPath+"/assets", sem CDN. Precisa ter swagger-ui.css e
+	// swagger-ui-bundle.js ( pacote swagger-ui-dist ) ou redoc.standalone.js ( pacote redoc ) na raiz,
+	// e.g. um embed.FS com //go:embed e fs.Sub
+	Assets fs.FS
+	// AssetsIntegrity são os hashes SRI por nome de arquivo, e.g. {"swagger-ui-bundle.js": "sha384-..."},
+	// gerados com `openssl dgst -sha384 -binary arquivo | openssl base64 -A`
+	AssetsIntegrity map[string]string
+	// Middlewares das rotas de documentação, e.g. auth para não expor a API publicamente
+	Middlewares []MiddlewareFunc
+}
+
+// OpenAPI registra as rotas com o documento OpenAPI 3.1 e a página de documentação. O documento é
+// gerado a cada request, então rotas registradas depois dessa chamada também aparecem
+func (a *APIServer) OpenAPI(cfg OpenAPIConfig) {
+	if cfg.Path == "" {
+		cfg.Path = "/openapi"
+	}
+	if cfg.DocsPath == "" {
+		cfg.DocsPath = "/docs"
+	}
+	if cfg.Info.Title == "" {
+		cfg.Info.Title = "API"
+	}
+	if cfg.Info.Version == "" {
+		cfg.Info.Version = "0.0.0"
+	}
+
+	routes := []RouteInfo{
+		{
+			Path:        cfg.Path + ".json",
+			Method:      MethodGet,
+			Middlewares: cfg.Middlewares,
+			Hidden:      true,
+			Handler: func(tc *TupaContext) error {
+				return WriteJSONHelper(tc.Resp, http.StatusOK, a.OpenAPIDocument(cfg))
+			},
+		},
+		{
+			Path:        cfg.Path + ".yaml",
+			Method:      MethodGet,
+			Middlewares: cfg.Middlewares,
+			Hidden:      true,
+			Handler: func(tc *TupaContext) error {
+				doc, err := toYAML(a.OpenAPIDocument(cfg))
+				if err != nil {
+					return err
+				}
+				tc.Resp.Header().Set("Content-Type", "application/yaml")
+				tc.Resp.WriteHeader(http.StatusOK)
+				_, err = tc.Resp.Write([]byte(doc))
+				return err
+			},
+		},
+	}
+
+	if cfg.DocsPath != "-" {
+		page := swaggerUIPage
+		if cfg.UI == "redoc" {
+			page = redocPage
+		}
+		if cfg.Assets != nil {
+			static := DefaultStaticConfig()
+			static.Middlewares = cfg.Middlewares
+			a.StaticFS(cfg.DocsPath+"/assets", cfg.Assets, static)
+		}
+		routes = append(routes, RouteInfo{
+			Path:        cfg.DocsPath,
+			Method:      MethodGet,
+			Middlewares: cfg.Middlewares,
+			Hidden:      true,
+			Handler: func(tc *TupaContext) error {
+				tc.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+				tc.Resp.WriteHeader(http.StatusOK)
+				return page.Execute(tc.Resp, map[string]any{
+					"Title":   cfg.Info.Title,
+					"SpecURL": cfg.Path + ".json",
+					"Asset":   cfg.docsAsset,
+					// o script que inicia a UI é inline, então precisa do nonce do CSP
+					"Nonce": tc.CSPNonce(),
+				})
+			},
+		})
+	}
+
+	a.RegisterRoutes(routes)
+}
+
+// versões dos assets da página de documentação quando vêm do CDN
+const (
+	SwaggerUIVersion = "5.17.14"
+	RedocVersion     = "2.1.5"
+)
+
+const openAPIAssetsCDN = "https://cdn.jsdelivr.net"
+
+type docsAsset struct {
+	URL, Integrity string
+}
+
+func (cfg OpenAPIConfig) docsAsset(name string) docsAsset {
+	asset := docsAsset{Integrity: cfg.AssetsIntegrity[name]}
+	switch {
+	case cfg.Assets != nil:
+		asset.URL = cfg.DocsPath + "/assets/" + name
+	case name == "redoc.standalone.js":
+		asset.URL = openAPIAssetsCDN + "/npm/redoc@" + RedocVersion + "/bundles/" + name
+	default:
+		asset.URL = openAPIAssetsCDN + "/npm/swagger-ui-dist@" + SwaggerUIVersion + "/" + name
+	}
+	return asset
+}
+
+// DocsCSP é o CSP que a página de documentação precisa. O de DefaultSecureHeadersConfig bloqueia os
+// assets e o script inline, então use nos Middlewares da config:
+//
+//	cfg.Middlewares = []tupa.MiddlewareFunc{tupa.SecureHeaders(tupa.SecureHeadersConfig{CSP: cfg.DocsCSP()})}
+//
+// Sem Assets libera o CDN; o script inline usa o nonce e as UIs precisam de estilos inline
+func (cfg OpenAPIConfig) DocsCSP() *CSP {
+	sources := []string{"'self'"}
+	if cfg.Assets == nil {
+		sources = append(sources, openAPIAssetsCDN)
+	}
+	return NewCSP().
+		DefaultSrc("'self'").
+		ScriptSrc(sources...).
+		StyleSrc(append(sources, "'unsafe-inline'")...).
+		ImgSrc("'self'", "data:").
+		// a busca do redoc roda em um worker criado de um blob
+		Add("worker-src", "blob:").
+		ObjectSrc("'none'").
+		BaseURI("'self'").
+		FrameAncestors("'none'").
+		WithNonce("script-src")
+}
+
+// OpenAPIDocument monta o documento a partir das rotas registradas até agora
+func (a *APIServer) OpenAPIDocument(cfg OpenAPIConfig) map[string]any {
+	gen := &schemaGenerator{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+	paths := map[string]map[string]any{}
+
+	// a mesma rota em vários hosts ( AddHostRoutes ) vira uma operação só, com os hosts em servers.
+	// A primeira rota registrada define a operação
+	hosts := map[[2]string][]string{}
+	anyHost := map[[2]string]bool{}
+
+	for _, route := range a.routes {
+		if route.Hidden {
+			continue
+		}
+		path, params := openAPIPath(route.Path)
+		method := strings.ToLower(string(route.Method))
+		if paths[path] == nil {
+			paths[path] = map[string]any{}
+		}
+		if _, ok := paths[path][method]; !ok {
+			paths[path][method] = gen.operation(route, params)
+		}
+
+		key := [2]string{path, method}
+		if route.Host == "" {
+			anyHost[key] = true
+		} else if !containsString(hosts[key], route.Host) {
+			hosts[key] = append(hosts[key], route.Host)
+		}
+	}
+	for key, list := range hosts {
+		// uma rota sem Host atende qualquer host, então não restringe os servers
+		if anyHost[key] {
+			continue
+		}
+		paths[key[0]][key[1]].(map[string]any)["servers"] = openAPIHostServers(list)
+	}
+
+	doc := map[string]any{
+		"openapi": "3.1.0",
+		"info":    cfg.Info,
+		"paths":   paths,
+	}
+	if len(cfg.Servers) > 0 {
+		servers := make([]map[string]string, 0, len(cfg.Servers))
+		for _, url := range cfg.Servers {
+			servers = append(servers, map[string]string{"url": url})
+		}
+		doc["servers"] = servers
+	}
+
+	components := map[string]any{}
+	if len(gen.schemas) > 0 {
+		components["schemas"] = gen.schemas
+	}
+	if len(cfg.SecuritySchemes) > 0 {
+		components["securitySchemes"] = cfg.SecuritySchemes
+	}
+	if len(components) > 0 {
+		doc["components"] = components
+	}
+	return doc
+}
+
+var openAPIParamRegex = regexp.MustCompile(`\{([^}]+)\}`)
+
+// openAPIPath converte o pattern do ServeMux para o formato do OpenAPI: {$} some e {path...} vira {path}
+func openAPIPath(pattern string) (string, []string) {
+	pattern = strings.TrimSuffix(pattern, "{$}")
+	var params []string
+	path := openAPIParamRegex.ReplaceAllStringFunc(pattern, func(m string) string {
+		name := strings.TrimSuffix(m[1:len(m)-1], "...")
+		params = append(params, name)
+		return "{" + name + "}"
+	})
+	return path, params
+}
+
+// openAPIHostServers descreve os hosts de uma operação. O scheme fica de fora ( "//host" ) e cada
+// {nome} do host vira uma variável do server
+func openAPIHostServers(hosts []string) []map[string]any {
+	servers := make([]map[string]any, 0, len(hosts))
+	for _, host := range hosts {
+		server := map[string]any{"url": "//" + host}
+		variables := map[string]any{}
+		for _, label := range strings.Split(host, ".") {
+			if isHostParam(label) {
+				name := label[1 : len(label)-1]
+				variables[name] = map[string]any{"default": name}
+			}
+		}
+		if len(variables) > 0 {
+			server["variables"] = variables
+		}
+		servers = append(servers, server)
+	}
+	return servers
+}
+
+func (g *schemaGenerator) operation(route RouteInfo, params []string) map[string]any {
+	op := map[string]any{}
+	if route.Summary != "" {
+		op["summary"] = route.Summary
+	}
+	if route.Description != "" {
+		op["description"] = route.Description
+	}
+	if len(route.Tags) > 0 {
+		op["tags"] = route.Tags
+	}
+	if route.OperationID != "" {
+		op["operationId"] = route.OperationID
+	}
+	if route.Deprecated {
+		op["deprecated"] = true
+	}
+
+	if len(params) > 0 {
+		parameters := make([]map[string]any, 0, len(params))
+		for _, name := range params {
+			parameters = append(parameters, map[string]any{
+				"name":     name,
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]any{"type": "string"},
+			})
+		}
+		op["parameters"] = parameters
+	}
+
+	if route.Request != nil {
+		op["requestBody"] = map[string]any{
+			"required": true,
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": g.schemaFor(reflect.TypeOf(route.Request))},
+			},
+		}
+	}
+
+	responses := map[string]any{}
+	for status, body := range route.Responses {
+		response := map[string]any{"description": http.StatusText(status)}
+		if body != nil {
+			response["content"] = map[string]any{
+				"application/json": map[string]any{"schema": g.schemaFor(reflect.TypeOf(body))},
+			}
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+	if len(responses) == 0 {
+		responses["200"] = map[string]any{"description": http.StatusText(http.StatusOK)}
+	}
+	op["responses"] = responses
+
+	if len(route.Security) > 0 {
+		security := make([]map[string][]string, 0, len(route.Security))
+		for _, name := range route.Security {
+			security = append(security, map[string][]string{name: {}})
+		}
+		op["security"] = security
+	}
+	return op
+}
+
+// schemaGenerator transforma tipos Go em JSON Schema. Structs nomeadas vão para components/schemas
+// e são referenciadas com $ref, o que também resolve tipos recursivos
+type schemaGenerator struct {
+	schemas map[string]any
+	names   map[reflect.Type]string
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func (g *schemaGenerator) schemaFor(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType):
+		// o formato depende do MarshalJSON, não tem como saber. O método com receiver ponteiro também
+		// vale, o encoding/json usa quando o valor é endereçável
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+		return map[string]any{"type": "integer", "format": "int32"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer", "minimum": 0}
+	case reflect.Float32:
+		return map[string]any{"type": "number", "format": "float"}
+	case reflect.Float64:
+		return map[string]any{"type": "number", "format": "double"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json manda []byte como base64
+			return map[string]any{"type": "string", "contentEncoding": "base64"}
+		}
+		return map[string]any{"type": "array", "items": g.schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": g.schemaFor(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + g.register(t)}
+	}
+	// interface e afins aceitam qualquer valor
+	return map[string]any{}
+}
+
+func (g *schemaGenerator) register(t reflect.Type) string {
+	if name, ok := g.names[t]; ok {
+		return name
+	}
+
+	name := t.Name()
+	if _, taken := g.schemas[name]; taken {
+		// mesmo nome em pacotes diferentes
+		pkg := t.PkgPath()
+		name = pkg[strings.LastIndex(pkg, "/")+1:] + "." + name
+	}
+	g.names[t] = name
+	// reserva o nome antes de descer nos campos, para o caso de o tipo apontar para ele mesmo
+	g.schemas[name] = nil
+	g.schemas[name] = g.structSchema(t)
+	return name
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	g.collectFields(t, properties, &required)
+
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (g *schemaGenerator) collectFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+
+		ft := field.Type
+		for ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		// campos embutidos sem nome no json são achatados, como o encoding/json faz
+		if field.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			g.collectFields(ft, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		schema := g.schemaFor(field.Type)
+		if strings.Contains(opts, "string") {
+			schema = map[string]any{"type": "string"}
+		}
+		if desc := field.Tag.Get("description"); desc != "" {
+			if _, isRef := schema["$ref"]; isRef {
+				// no 3.1 irmãos de $ref são permitidos
+				schema = map[string]any{"$ref": schema["$ref"], "description": desc}
+			} else {
+				schema["description"] = desc
+			}
+		}
+		properties[name] = schema
+
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Pointer {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// toYAML converte o documento passando por JSON, assim as tags json e os MarshalJSON são respeitados
+func toYAML(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	var generic any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	writeYAML(&b, generic, 0)
+	return b.String(), nil
+}
+
+func writeYAML(b *strings.Builder, v any, indent int) {
+	pad := strings.Repeat("  ", indent)
+	switch val := v.(type) {
+	case map[string]any:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteString(pad + yamlScalar(k) + ":")
+			writeYAMLValue(b, val[k], indent)
+		}
+	case []any:
+		for _, item := range val {
+			if m, ok := item.(map[string]any); ok && len(m) > 0 {
+				// mapa dentro de lista começa na mesma linha do "- "
+				var nested strings.Builder
+				writeYAML(&nested, m, indent+1)
+				b.WriteString(pad + "- " + strings.TrimPrefix(nested.String(), pad+"  "))
+				continue
+			}
+			b.WriteString(pad + "-")
+			writeYAMLValue(b, item, indent)
+		}
+	}
+}
+
+func writeYAMLValue(b *strings.Builder, v any, indent int) {
+	switch val := v.(type) {
+	case map[string]any:
+		if len(val) == 0 {
+			b.WriteString(" {}\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAML(b, val, indent+1)
+	case []any:
+		if len(val) == 0 {
+			b.WriteString(" []\n")
+			return
+		}
+		b.WriteString("\n")
+		writeYAML(b, val, indent+1)
+	default:
+		b.WriteString(" " + yamlScalar(val) + "\n")
+	}
+}
+
+func yamlScalar(v any) string {
+	switch val := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(val)
+	case float64:
+		if val == math.Trunc(val) && math.Abs(val) < 1e15 {
+			return strconv.FormatInt(int64(val), 10)
+		}
+		return strconv.FormatFloat(val, 'g', -1, 64)
+	case string:
+		if yamlNeedsQuotes(val) {
+			// string JSON é um escalar YAML válido com aspas duplas
+			quoted, _ := json.Marshal(val)
+			return string(quoted)
+		}
+		return val
+	}
+	return fmt.Sprint(v)
+}
+
+func yamlNeedsQuotes(s string) bool {
+	if s == "" || strings.TrimSpace(s) != s {
+		return true
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return true
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return true
+	}
+	if strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") {
+		return true
+	}
+	return strings.HasSuffix(s, ":") || strings.Contains(s, ": ") || strings.Contains(s, " #") ||
+		strings.ContainsAny(s, "\n\t")
+}
+
+var swaggerUIPage = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  {{with call .Asset "swagger-ui.css"}}<link rel="stylesheet" href="{{.URL}}"{{with .Integrity}} integrity="{{.}}" crossorigin="anonymous"{{end}}>{{end}}
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  {{with call .Asset "swagger-ui-bundle.js"}}<script src="{{.URL}}"{{with .Integrity}} integrity="{{.}}" crossorigin="anonymous"{{end}}></script>{{end}}
+  <script{{with .Nonce}} nonce="{{.}}"{{end}}>
+    window.ui = SwaggerUIBundle({ url: "{{.SpecURL}}", dom_id: "#swagger-ui" });
+  </script>
+</body>
+</html>
+`))
+
+var redocPage = template.Must(template.New("redoc").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+</head>
+<body>
+  <redoc spec-url="{{.SpecURL}}"></redoc>
+  {{with call .Asset "redoc.standalone.js"}}<script src="{{.URL}}"{{with .Integrity}} integrity="{{.}}" crossorigin="anonymous"{{end}}></script>{{end}}
+</body>
+</html>
+`))
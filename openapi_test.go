@@ -0,0 +1,308 @@
+package tupa
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type openAPIAddress struct {
+	Street string `json:"street"`
+}
+
+type openAPIUser struct {
+	ID        int64           `json:"id"`
+	Name      string          `json:"name" description:"Nome completo"`
+	Email     string          `json:"email,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	Address   *openAPIAddress `json:"address"`
+	Friends   []openAPIUser   `json:"friends,omitempty"`
+	Meta      map[string]any  `json:"meta,omitempty"`
+	password  string
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	server.RegisterRoutes([]RouteInfo{
+		{
+			Path:        "/openapi/users/{id}",
+			Method:      MethodGet,
+			Handler:     func(tc *TupaContext) error { return nil },
+			Summary:     "Busca um usuário",
+			Tags:        []string{"users"},
+			OperationID: "getUser",
+			Responses:   map[int]any{http.StatusOK: openAPIUser{}, http.StatusNotFound: APIError{}},
+			Security:    []string{"bearer"},
+		},
+		{
+			Path:      "/openapi/users",
+			Method:    MethodPost,
+			Handler:   func(tc *TupaContext) error { return nil },
+			Request:   &openAPIUser{},
+			Responses: map[int]any{http.StatusNoContent: nil},
+		},
+		{
+			Path:    "/openapi/files/{path...}",
+			Method:  MethodGet,
+			Handler: func(tc *TupaContext) error { return nil },
+		},
+		{
+			Path:    "/openapi/interna",
+			Method:  MethodGet,
+			Handler: func(tc *TupaContext) error { return nil },
+			Hidden:  true,
+		},
+	})
+	server.OpenAPI(OpenAPIConfig{
+		Info:            OpenAPIInfo{Title: "Teste", Version: "1.0.0"},
+		Path:            "/openapi/spec",
+		DocsPath:        "/openapi/docs",
+		SecuritySchemes: map[string]OpenAPISecurityScheme{"bearer": {Type: "http", Scheme: "bearer"}},
+	})
+
+	t.Run("Teste documento JSON", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/openapi/spec.json", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("status recebido %d, queria %d", rr.Code, http.StatusOK)
+		}
+
+		var doc struct {
+			OpenAPI    string                               `json:"openapi"`
+			Paths      map[string]map[string]map[string]any `json:"paths"`
+			Components struct {
+				Schemas         map[string]map[string]any `json:"schemas"`
+				SecuritySchemes map[string]any            `json:"securitySchemes"`
+			} `json:"components"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+			t.Fatal(err)
+		}
+
+		if doc.OpenAPI != "3.1.0" {
+			t.Errorf("versão recebida %q", doc.OpenAPI)
+		}
+		for _, path := range []string{"/openapi/spec.json", "/openapi/interna"} {
+			if _, ok := doc.Paths[path]; ok {
+				t.Errorf("rota %s não deveria estar no documento", path)
+			}
+		}
+		if _, ok := doc.Paths["/openapi/files/{path}"]["get"]; !ok {
+			t.Errorf("catch-all não foi convertido, paths: %v", reflect.ValueOf(doc.Paths).MapKeys())
+		}
+
+		get := doc.Paths["/openapi/users/{id}"]["get"]
+		if get["operationId"] != "getUser" || get["summary"] != "Busca um usuário" {
+			t.Errorf("metadados da operação inesperados: %v", get)
+		}
+		params, _ := json.Marshal(get["parameters"])
+		if !strings.Contains(string(params), `"in":"path"`) || !strings.Contains(string(params), `"name":"id"`) {
+			t.Errorf("parâmetro de path não documentado: %s", params)
+		}
+		responses, _ := json.Marshal(get["responses"])
+		if !strings.Contains(string(responses), `"#/components/schemas/openAPIUser"`) || !strings.Contains(string(responses), `"404"`) {
+			t.Errorf("respostas inesperadas: %s", responses)
+		}
+		if _, ok := doc.Paths["/openapi/users"]["post"]["requestBody"]; !ok {
+			t.Error("requestBody não foi documentado")
+		}
+
+		user := doc.Components.Schemas["openAPIUser"]
+		props := user["properties"].(map[string]any)
+		if _, ok := props["password"]; ok {
+			t.Error("campo não exportado apareceu no schema")
+		}
+		if got := props["created_at"].(map[string]any)["format"]; got != "date-time" {
+			t.Errorf("formato de time.Time recebido %v", got)
+		}
+		if got := props["name"].(map[string]any)["description"]; got != "Nome completo" {
+			t.Errorf("descrição recebida %v", got)
+		}
+		friends, _ := json.Marshal(props["friends"])
+		if string(friends) != `{"items":{"$ref":"#/components/schemas/openAPIUser"},"type":"array"}` {
+			t.Errorf("tipo recursivo recebido %s", friends)
+		}
+		required, _ := json.Marshal(user["required"])
+		if string(required) != `["created_at","id","name"]` {
+			t.Errorf("required recebido %s", required)
+		}
+		if _, ok := doc.Components.SecuritySchemes["bearer"]; !ok {
+			t.Error("securitySchemes não foi incluído")
+		}
+	})
+
+	t.Run("Teste documento YAML", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/openapi/spec.yaml", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		body := rr.Body.String()
+		for _, want := range []string{
+			"openapi: 3.1.0\n",
+			"  version: 1.0.0\n",
+			"  /openapi/users/{id}:\n",
+			"        - in: path\n          name: id\n",
+			"      responses:\n        \"204\":\n          description: No Content\n",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("YAML não contém %q:\n%s", want, body)
+			}
+		}
+	})
+
+	t.Run("Teste página de documentação", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/openapi/docs", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if !strings.Contains(rr.Body.String(), `url: "\/openapi\/spec.json"`) {
+			t.Errorf("página não aponta para o documento:\n%s", rr.Body.String())
+		}
+		if want := "https://cdn.jsdelivr.net/npm/swagger-ui-dist@" + SwaggerUIVersion + "/swagger-ui-bundle.js"; !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("página não usa a versão fixa %s:\n%s", want, rr.Body.String())
+		}
+	})
+}
+
+func TestOpenAPIHosts(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	server.RegisterRoutes([]RouteInfo{
+		{Host: "admin.example.com", Path: "/openapi/hosts/painel", Method: MethodGet, Summary: "admin", Handler: func(tc *TupaContext) error { return nil }},
+		{Host: "{tenant}.example.com", Path: "/openapi/hosts/painel", Method: MethodGet, Summary: "tenant", Handler: func(tc *TupaContext) error { return nil }},
+		{Host: "{tenant}.example.com", Path: "/openapi/hosts/painel", Method: MethodPost, Handler: func(tc *TupaContext) error { return nil }},
+		{Host: "admin.example.com", Path: "/openapi/hosts/todos", Method: MethodGet, Handler: func(tc *TupaContext) error { return nil }},
+		{Path: "/openapi/hosts/todos", Method: MethodGet, Handler: func(tc *TupaContext) error { return nil }},
+	})
+
+	paths := server.OpenAPIDocument(OpenAPIConfig{})["paths"].(map[string]map[string]any)
+
+	t.Run("Teste mesma rota em dois hosts vira uma operação com os dois servers", func(t *testing.T) {
+		get := paths["/openapi/hosts/painel"]["get"].(map[string]any)
+		if get["summary"] != "admin" {
+			t.Errorf("a primeira rota registrada deveria definir a operação, summary %v", get["summary"])
+		}
+		want := []map[string]any{
+			{"url": "//admin.example.com"},
+			{"url": "//{tenant}.example.com", "variables": map[string]any{"tenant": map[string]any{"default": "tenant"}}},
+		}
+		if !reflect.DeepEqual(get["servers"], want) {
+			t.Errorf("servers recebidos %v, queria %v", get["servers"], want)
+		}
+		if _, ok := paths["/openapi/hosts/painel"]["post"]; !ok {
+			t.Error("o POST do outro host não deveria sumir")
+		}
+	})
+
+	t.Run("Teste rota sem Host não restringe os servers", func(t *testing.T) {
+		if servers, ok := paths["/openapi/hosts/todos"]["get"].(map[string]any)["servers"]; ok {
+			t.Errorf("rota que atende qualquer host não deveria ter servers, recebeu %v", servers)
+		}
+	})
+}
+
+func TestYAMLNeedsQuotes(t *testing.T) {
+	tests := map[string]bool{
+		"simples":         false,
+		"com espaço":      false,
+		"termina com:":    true,
+		"chave: valor":    true,
+		"texto #coment":   true,
+		"texto#hashtag":   false,
+		"true":            true,
+		"1.0":             true,
+		"-começa":         true,
+		"http://a.b/c:80": false,
+	}
+	for input, want := range tests {
+		if got := yamlNeedsQuotes(input); got != want {
+			t.Errorf("yamlNeedsQuotes(%q) = %v, queria %v", input, got, want)
+		}
+	}
+}
+
+func TestOpenAPIDocsAssets(t *testing.T) {
+	t.Run("Teste assets embutidos com SRI e CSP", func(t *testing.T) {
+		server := NewAPIServer(":8080", func() {})
+		cfg := OpenAPIConfig{
+			Info:     OpenAPIInfo{Title: "Teste", Version: "1.0.0"},
+			Path:     "/openapi/spec",
+			DocsPath: "/openapi/docs",
+			Assets: fstest.MapFS{
+				"swagger-ui.css":       {Data: []byte("body{}")},
+				"swagger-ui-bundle.js": {Data: []byte("var SwaggerUIBundle")},
+			},
+			AssetsIntegrity: map[string]string{"swagger-ui-bundle.js": "sha384-abc"},
+		}
+		cfg.Middlewares = []MiddlewareFunc{SecureHeaders(SecureHeadersConfig{CSP: cfg.DocsCSP()})}
+		server.OpenAPI(cfg)
+
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi/docs", nil))
+		body, csp := rr.Body.String(), rr.Header().Get("Content-Security-Policy")
+		for _, want := range []string{
+			`href="/openapi/docs/assets/swagger-ui.css">`,
+			`src="/openapi/docs/assets/swagger-ui-bundle.js" integrity="sha384-abc" crossorigin="anonymous"`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("página não contém %q:\n%s", want, body)
+			}
+		}
+		if strings.Contains(csp, "cdn.jsdelivr.net") || !strings.Contains(csp, "script-src 'self' 'nonce-") {
+			t.Errorf("CSP inesperado %q", csp)
+		}
+		nonce := csp[strings.Index(csp, "'nonce-")+len("'nonce-"):]
+		nonce = nonce[:strings.Index(nonce, "'")]
+		if !strings.Contains(body, `<script nonce="`+nonce+`">`) {
+			t.Errorf("script inline sem o nonce %q:\n%s", nonce, body)
+		}
+
+		rr = httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/openapi/docs/assets/swagger-ui-bundle.js", nil))
+		if rr.Code != http.StatusOK || rr.Body.String() != "var SwaggerUIBundle" {
+			t.Errorf("asset não servido: %d %q", rr.Code, rr.Body.String())
+		}
+	})
+
+	t.Run("Teste redoc do CDN", func(t *testing.T) {
+		server := NewAPIServer(":8080", func() {})
+		cfg := OpenAPIConfig{Info: OpenAPIInfo{Title: "Teste", Version: "1.0.0"}, UI: "redoc"}
+		server.OpenAPI(cfg)
+
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/docs", nil))
+		if want := "https://cdn.jsdelivr.net/npm/redoc@" + RedocVersion + "/bundles/redoc.standalone.js"; !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("página não usa a versão fixa %s:\n%s", want, rr.Body.String())
+		}
+		if csp := cfg.DocsCSP().Build(""); !strings.Contains(csp, "script-src 'self' https://cdn.jsdelivr.net") || !strings.Contains(csp, "worker-src blob:") {
+			t.Errorf("CSP inesperado %q", csp)
+		}
+	})
+}
+
+type openAPIMoney struct{ cents int64 }
+
+// receiver ponteiro, o encoding/json usa para campos de structs endereçáveis
+func (m *openAPIMoney) MarshalJSON() ([]byte, error) {
+	return json.Marshal(float64(m.cents) / 100)
+}
+
+func TestOpenAPISchemaMarshaler(t *testing.T) {
+	gen := &schemaGenerator{schemas: map[string]any{}, names: map[reflect.Type]string{}}
+	for name, typ := range map[string]reflect.Type{
+		"valor":    reflect.TypeOf(openAPIMoney{}),
+		"ponteiro": reflect.TypeOf(&openAPIMoney{}),
+	} {
+		t.Run("Teste MarshalJSON com receiver ponteiro "+name, func(t *testing.T) {
+			if schema := gen.schemaFor(typ); len(schema) != 0 {
+				t.Errorf("esperava schema livre, recebeu %v", schema)
+			}
+		})
+	}
+}
@@ -0,0 +1,258 @@
+package tupa
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// atalhos aceitos em SetTrustedProxies
+var trustedProxyAliases = map[string][]string{
+	"loopback": {"127.0.0.0/8", "::1/128"},
+	"private":  {"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"},
+}
+
+// headers que um proxy confiável pode usar para informar o cliente, um por servidor
+const (
+	// ProxyHeaderForwarded é o header da RFC 7239, com for, proto e host no mesmo elemento
+	ProxyHeaderForwarded = "Forwarded"
+	// ProxyHeaderXForwardedFor usa X-Forwarded-For para o IP e X-Forwarded-Proto / X-Forwarded-Host
+	ProxyHeaderXForwardedFor = "X-Forwarded-For"
+	// ProxyHeaderXRealIP só informa o IP, scheme e host continuam sendo os da conexão
+	ProxyHeaderXRealIP = "X-Real-IP"
+)
+
+// trustedProxies são os proxies cujo header de encaminhamento vale
+type trustedProxies struct {
+	header   string
+	prefixes []netip.Prefix
+	// unix confia em quem conecta pelos sockets unix de AddListener, e.g. o nginx na mesma máquina
+	unix bool
+}
+
+func parseTrustedProxies(header string, entries []string) (trustedProxies, error) {
+	proxies := trustedProxies{header: http.CanonicalHeaderKey(header)}
+	if proxies.header == "X-Real-Ip" {
+		proxies.header = ProxyHeaderXRealIP
+	}
+	switch proxies.header {
+	case ProxyHeaderForwarded, ProxyHeaderXForwardedFor, ProxyHeaderXRealIP:
+	case "":
+		if len(entries) > 0 {
+			return proxies, errors.New("header dos proxies confiáveis é obrigatório, use Forwarded, X-Forwarded-For ou X-Real-IP")
+		}
+	default:
+		return proxies, fmt.Errorf("header de proxy inválido %q, use Forwarded, X-Forwarded-For ou X-Real-IP", header)
+	}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "unix" {
+			proxies.unix = true
+			continue
+		}
+		cidrs, ok := trustedProxyAliases[entry]
+		if !ok {
+			cidrs = []string{entry}
+		}
+		for _, cidr := range cidrs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				addr, addrErr := netip.ParseAddr(cidr)
+				if addrErr != nil {
+					return proxies, fmt.Errorf("proxy confiável inválido %q, use um IP, um CIDR, loopback, private ou unix", entry)
+				}
+				prefix = netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen())
+			}
+			proxies.prefixes = append(proxies.prefixes, prefix.Masked())
+		}
+	}
+	return proxies, nil
+}
+
+func (p trustedProxies) contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetTrustedProxies define quais proxies podem informar o cliente e por qual header: ProxyHeaderForwarded,
+// ProxyHeaderXForwardedFor ou ProxyHeaderXRealIP. Só esse header é lido por tc.RealIP(), tc.Scheme()
+// e tc.Host(); os outros são ignorados, já que o proxy normalmente repassa os que o cliente enviou.
+// Aceita IPs, CIDRs, "loopback", "private" ( redes RFC 1918 e ULA ) e "unix" para conexões por socket
+// unix. Sem proxies confiáveis nenhum header é lido
+func (a *APIServer) SetTrustedProxies(header string, proxies ...string) error {
+	parsed, err := parseTrustedProxies(header, proxies)
+	if err != nil {
+		return err
+	}
+	a.trustedProxies = parsed
+	return nil
+}
+
+// forwardedHop é o que o proxy mais próximo do cliente informou sobre a conexão
+type forwardedHop struct {
+	ip, proto, host string
+}
+
+// forwarded percorre a cadeia de proxies da direita para a esquerda, pulando os confiáveis. O primeiro
+// endereço que não é de um proxy confiável é o cliente, e o proto e host vêm do mesmo salto. ok é
+// falso quando a conexão não veio de um proxy confiável
+func (tc *TupaContext) forwarded() (hop forwardedHop, ok bool) {
+	peer := remoteIP(tc.Req)
+	hop.ip = peer
+	if tc.api == nil || !tc.api.trustsPeer(tc.Req, peer) {
+		return hop, false
+	}
+	proxies := tc.api.trustedProxies
+
+	switch proxies.header {
+	case ProxyHeaderForwarded:
+		elements := parseForwarded(tc.Req.Header.Values("Forwarded"))
+		if len(elements) == 0 {
+			return hop, true
+		}
+		i := clientHop(len(elements), func(i int) string { return elements[i]["for"] }, proxies)
+		el := elements[i]
+		if ip := forwardedIP(el["for"]); ip != "" {
+			hop.ip = ip
+		}
+		hop.proto, hop.host = strings.ToLower(el["proto"]), el["host"]
+	case ProxyHeaderXForwardedFor:
+		values := splitHeaderList(tc.Req.Header.Values("X-Forwarded-For"))
+		if len(values) == 0 {
+			return hop, true
+		}
+		i := clientHop(len(values), func(i int) string { return values[i] }, proxies)
+		if ip := forwardedIP(values[i]); ip != "" {
+			hop.ip = ip
+		}
+		hop.proto = strings.ToLower(alignedValue(tc.Req.Header.Values("X-Forwarded-Proto"), len(values), i))
+		hop.host = alignedValue(tc.Req.Header.Values("X-Forwarded-Host"), len(values), i)
+	case ProxyHeaderXRealIP:
+		if ip := forwardedIP(tc.Req.Header.Get("X-Real-IP")); ip != "" {
+			hop.ip = ip
+		}
+	}
+	return hop, true
+}
+
+// clientHop devolve o índice do cliente em uma lista de n endereços, o mais à direita que não é um
+// proxy confiável, ou o primeiro se todos forem. n é maior que zero
+func clientHop(n int, addrAt func(int) string, proxies trustedProxies) int {
+	for i := n - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(forwardedIP(addrAt(i)))
+		if err != nil || !proxies.contains(addr) {
+			return i
+		}
+	}
+	return 0
+}
+
+// alignedValue pega o valor do mesmo salto quando cada proxy acrescenta o seu ao header, ou o mais à
+// direita ( do proxy mais próximo ) quando o header tem um número diferente de valores
+func alignedValue(header []string, n, i int) string {
+	values := splitHeaderList(header)
+	if len(values) == 0 {
+		return ""
+	}
+	if len(values) == n {
+		return values[i]
+	}
+	return values[len(values)-1]
+}
+
+func splitHeaderList(header []string) []string {
+	var values []string
+	for _, h := range header {
+		for _, v := range strings.Split(h, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// parseForwarded lê o header Forwarded da RFC 7239, e.g. `for=192.0.2.60;proto=https, for="[2001:db8::1]:4711"`
+func parseForwarded(header []string) []map[string]string {
+	var elements []map[string]string
+	for _, h := range header {
+		for _, element := range strings.Split(h, ",") {
+			el := map[string]string{}
+			for _, pair := range strings.Split(element, ";") {
+				key, value, found := strings.Cut(strings.TrimSpace(pair), "=")
+				if !found {
+					continue
+				}
+				el[strings.ToLower(key)] = strings.Trim(value, `"`)
+			}
+			if len(el) > 0 {
+				elements = append(elements, el)
+			}
+		}
+	}
+	return elements
+}
+
+// forwardedIP tira a porta e os colchetes de um endereço de header, "" se não for um IP
+// ( e.g. "unknown" ou um identificador ofuscado do Forwarded )
+func forwardedIP(value string) string {
+	value = strings.TrimSpace(value)
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
+	}
+	addr, err := netip.ParseAddr(strings.Trim(value, "[]"))
+	if err != nil {
+		return ""
+	}
+	return addr.Unmap().String()
+}
+
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (a *APIServer) trustsPeer(r *http.Request, peer string) bool {
+	if local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok && local.Network() == "unix" {
+		return a.trustedProxies.unix
+	}
+	addr, err := netip.ParseAddr(peer)
+	return err == nil && a.trustedProxies.contains(addr)
+}
+
+// RealIP retorna o IP do cliente. Atrás de um proxy confiável vem do header escolhido em
+// SetTrustedProxies, senão é o endereço da conexão. Use como chave em logs e limites por cliente
+func (tc *TupaContext) RealIP() string {
+	hop, _ := tc.forwarded()
+	return hop.ip
+}
+
+// Scheme retorna "https" ou "http" como o cliente vê, considerando o proto informado por um proxy confiável
+func (tc *TupaContext) Scheme() string {
+	if hop, ok := tc.forwarded(); ok && (hop.proto == "https" || hop.proto == "http") {
+		return hop.proto
+	}
+	if tc.Req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host retorna o host pedido pelo cliente, considerando o host informado por um proxy confiável
+func (tc *TupaContext) Host() string {
+	if hop, ok := tc.forwarded(); ok && hop.host != "" {
+		return hop.host
+	}
+	return tc.Req.Host
+}
@@ -0,0 +1,132 @@
+package tupa
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	server := NewAPIServer(":8080", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/proxy/eco", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		return tc.SendString(tc.RealIP() + " " + tc.Scheme() + " " + tc.Host())
+	}}})
+
+	for name, tc := range map[string]struct {
+		header  string
+		remote  string
+		headers map[string]string
+		want    string
+	}{
+		"sem proxy": {ProxyHeaderXForwardedFor, "203.0.113.9:5000", nil, "203.0.113.9 http example.com"},
+		"headers de cliente não confiável são ignorados": {ProxyHeaderXForwardedFor, "203.0.113.9:5000",
+			map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https", "X-Real-IP": "1.2.3.4"},
+			"203.0.113.9 http example.com"},
+		"X-Forwarded-* de proxy confiável": {ProxyHeaderXForwardedFor, "10.0.0.2:80",
+			map[string]string{"X-Forwarded-For": "198.51.100.7", "X-Forwarded-Proto": "https", "X-Forwarded-Host": "api.tupa.dev"},
+			"198.51.100.7 https api.tupa.dev"},
+		"X-Forwarded-For forjado pelo cliente": {ProxyHeaderXForwardedFor, "10.0.0.2:80",
+			map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.0.0.3"},
+			"198.51.100.7 http example.com"},
+		"cada proxy acrescenta o proto": {ProxyHeaderXForwardedFor, "10.0.0.2:80",
+			map[string]string{"X-Forwarded-For": "198.51.100.7, 10.0.0.3", "X-Forwarded-Proto": "https, http"},
+			"198.51.100.7 https example.com"},
+		"X-Real-IP": {ProxyHeaderXRealIP, "[::1]:80", map[string]string{"X-Real-IP": "2001:db8::1"}, "2001:db8::1 http example.com"},
+		"X-Real-IP ignora Forwarded e X-Forwarded-* do cliente": {ProxyHeaderXRealIP, "10.0.0.5:80",
+			map[string]string{"X-Real-IP": "203.0.113.7", "Forwarded": "for=10.9.9.9;proto=https", "X-Forwarded-For": "10.9.9.9", "X-Forwarded-Proto": "https"},
+			"203.0.113.7 http example.com"},
+		"Forwarded": {ProxyHeaderForwarded, "10.0.0.2:80",
+			map[string]string{
+				"Forwarded":       `for=1.2.3.4, for="[2001:db8::7]:4711";proto=https;host=tupa.dev, for=10.0.0.3;proto=http`,
+				"X-Forwarded-For": "198.51.100.7",
+			},
+			"2001:db8::7 https tupa.dev"},
+		"Forwarded ignora X-Forwarded-For forjado": {ProxyHeaderForwarded, "10.0.0.2:80",
+			map[string]string{"X-Forwarded-For": "10.9.9.9", "X-Real-IP": "10.9.9.9"},
+			"10.0.0.2 http example.com"},
+		"Forwarded com cliente desconhecido": {ProxyHeaderForwarded, "10.0.0.2:80", map[string]string{"Forwarded": "for=unknown"}, "10.0.0.2 http example.com"},
+	} {
+		t.Run("Teste "+name, func(t *testing.T) {
+			if err := server.SetTrustedProxies(tc.header, "10.0.0.0/8", "::1", "unix"); err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/proxy/eco", nil)
+			req.RemoteAddr = tc.remote
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, req)
+			if rr.Body.String() != tc.want {
+				t.Errorf("recebeu %q, queria %q", rr.Body.String(), tc.want)
+			}
+		})
+	}
+
+	if err := server.SetTrustedProxies(ProxyHeaderXForwardedFor, "10.0.0.0/8", "::1", "unix"); err != nil {
+		t.Fatal(err)
+	}
+	t.Run("Teste conexão por socket unix", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/proxy/eco", nil)
+		req.RemoteAddr = "@"
+		req.Header.Set("X-Forwarded-For", "198.51.100.7")
+		local := &net.UnixAddr{Name: "/run/tupa.sock", Net: "unix"}
+		req = req.WithContext(context.WithValue(req.Context(), http.LocalAddrContextKey, local))
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if rr.Body.String() != "198.51.100.7 http example.com" {
+			t.Errorf("recebeu %q", rr.Body.String())
+		}
+	})
+
+	t.Run("Teste HSTS e IPAllowlist atrás do proxy", func(t *testing.T) {
+		server.RegisterRoutes([]RouteInfo{{Path: "/proxy/admin", Method: MethodGet,
+			Middlewares: MiddlewareChain{SecureHeaders(), IPAllowlist("198.51.100.0/24")},
+			Handler: func(tc *TupaContext) error {
+				return tc.SendString("ok")
+			}}})
+		get := func(forwardedFor string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/proxy/admin", nil)
+			req.RemoteAddr = "10.0.0.2:80"
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+			req.Header.Set("X-Forwarded-Proto", "https")
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, req)
+			return rr
+		}
+		if rr := get("198.51.100.7"); rr.Code != http.StatusOK || rr.Header().Get("Strict-Transport-Security") == "" {
+			t.Errorf("esperava 200 com HSTS, recebeu %d %v", rr.Code, rr.Header())
+		}
+		if rr := get("203.0.113.9"); rr.Code != http.StatusForbidden {
+			t.Errorf("esperava 403 para cliente fora da allowlist, recebeu %d", rr.Code)
+		}
+	})
+
+	t.Run("Teste proxies inválidos", func(t *testing.T) {
+		for _, proxy := range []string{"10.0.0.0/33", "proxy.local", ""} {
+			if err := server.SetTrustedProxies(ProxyHeaderXRealIP, proxy); err == nil {
+				t.Errorf("esperava erro para %q", proxy)
+			}
+		}
+		if err := server.SetTrustedProxies("", "10.0.0.1"); err == nil {
+			t.Errorf("esperava erro sem header")
+		}
+		if err := server.SetTrustedProxies("X-Client-IP", "10.0.0.1"); err == nil {
+			t.Errorf("esperava erro com header desconhecido")
+		}
+		if err := server.SetTrustedProxies("x-real-ip", "loopback", "private"); err != nil {
+			t.Errorf("atalhos deveriam ser aceitos: %v", err)
+		}
+		cfg := DefaultConfig()
+		cfg.TrustedProxies = []string{"10.0.0.1"}
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("esperava erro na Config sem trusted_proxy_header")
+		}
+		cfg.TrustedProxyHeader = "Forwarded"
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Config válida: %v", err)
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package tupa
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type RateLimitConfig struct {
+	// Rate é quantas requests por segundo cada cliente pode fazer em média
+	Rate float64
+	// Burst é quantas requests seguidas o cliente pode fazer antes de ser limitado. 0 usa Rate
+	// arredondado para cima
+	Burst int
+	// Key identifica o cliente. Nulo usa tc.RealIP(), que só lê os headers de proxy vindos dos
+	// proxies confiáveis ( SetTrustedProxies ), então um cliente não escapa do limite forjando headers
+	Key func(tc *TupaContext) string
+	// IdleTTL é quanto tempo um cliente sem requests fica na memória. 0 usa 10 minutos
+	IdleTTL time.Duration
+}
+
+// RateLimit limita as requests de cada cliente com um token bucket. Quem passa do limite recebe 429
+// com Retry-After. Os contadores ficam na memória do processo, então com várias instâncias cada uma
+// limita separado. Rate inválido encerra o processo
+func RateLimit(cfg RateLimitConfig) MiddlewareFunc {
+	if cfg.Rate <= 0 {
+		log.Fatalf("%s%v", FmtRed("RateLimit precisa de Rate maior que zero, recebeu: "), cfg.Rate)
+	}
+	if cfg.Burst <= 0 {
+		cfg.Burst = int(math.Ceil(cfg.Rate))
+	}
+	if cfg.Key == nil {
+		cfg.Key = func(tc *TupaContext) string { return tc.RealIP() }
+	}
+	if cfg.IdleTTL <= 0 {
+		cfg.IdleTTL = 10 * time.Minute
+	}
+	limiter := &rateLimiter{cfg: cfg, buckets: map[string]*rateBucket{}, now: time.Now}
+
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			if wait, ok := limiter.allow(cfg.Key(tc)); !ok {
+				seconds := int(math.Ceil(wait.Seconds()))
+				tc.Resp.Header().Set("Retry-After", strconv.Itoa(max(seconds, 1)))
+				return APIHandlerErr{Status: http.StatusTooManyRequests, Msg: "Muitas requisições, tente novamente mais tarde"}
+			}
+			return next(tc)
+		}
+	}
+}
+
+type rateLimiter struct {
+	cfg RateLimitConfig
+	// now é trocado nos testes
+	now func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*rateBucket
+	lastSweep time.Time
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow gasta um token do cliente. Sem token, diz quanto falta para o próximo
+func (l *rateLimiter) allow(key string) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &rateBucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(l.cfg.Burst), b.tokens+now.Sub(b.last).Seconds()*l.cfg.Rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.cfg.Rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+// sweepLocked tira da memória os clientes parados há mais de IdleTTL, no máximo uma vez por IdleTTL
+func (l *rateLimiter) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < l.cfg.IdleTTL {
+		return
+	}
+	l.lastSweep = now
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= l.cfg.IdleTTL {
+			delete(l.buckets, key)
+		}
+	}
+}
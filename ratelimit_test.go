@@ -0,0 +1,115 @@
+package tupa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	if err := server.SetTrustedProxies(ProxyHeaderXRealIP, "10.0.0.0/8"); err != nil {
+		t.Fatal(err)
+	}
+	server.RegisterRoutes([]RouteInfo{{
+		Path:        "/ratelimit",
+		Method:      MethodGet,
+		Middlewares: MiddlewareChain{RateLimit(RateLimitConfig{Rate: 0.5, Burst: 2})},
+		Handler:     func(tc *TupaContext) error { return tc.SendString("ok") },
+	}})
+
+	request := func(remote, realIP string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/ratelimit", nil)
+		req.RemoteAddr = remote
+		if realIP != "" {
+			req.Header.Set("X-Real-IP", realIP)
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Teste cliente passa do limite", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			if rr := request("203.0.113.1:5000", ""); rr.Code != http.StatusOK {
+				t.Fatalf("request %d: esperava 200, recebeu %d", i, rr.Code)
+			}
+		}
+		rr := request("203.0.113.1:5000", "")
+		if rr.Code != http.StatusTooManyRequests {
+			t.Fatalf("esperava 429, recebeu %d", rr.Code)
+		}
+		if got := rr.Header().Get("Retry-After"); got != "2" {
+			t.Errorf("Retry-After recebido %q, queria %q", got, "2")
+		}
+		if rr := request("203.0.113.2:5000", ""); rr.Code != http.StatusOK {
+			t.Errorf("outro cliente não deveria ser limitado, recebeu %d", rr.Code)
+		}
+	})
+
+	t.Run("Teste clientes atrás do proxy confiável têm limites separados", func(t *testing.T) {
+		for _, client := range []string{"198.51.100.1", "198.51.100.2"} {
+			for i := 0; i < 2; i++ {
+				if rr := request("10.0.0.5:80", client); rr.Code != http.StatusOK {
+					t.Fatalf("%s request %d: esperava 200, recebeu %d", client, i, rr.Code)
+				}
+			}
+		}
+		if rr := request("10.0.0.5:80", "198.51.100.1"); rr.Code != http.StatusTooManyRequests {
+			t.Errorf("esperava 429 para o cliente real, recebeu %d", rr.Code)
+		}
+	})
+
+	t.Run("Teste X-Real-IP forjado não troca de cliente", func(t *testing.T) {
+		for i := 0; i < 2; i++ {
+			request("203.0.113.3:5000", "192.0.2.1")
+		}
+		if rr := request("203.0.113.3:5000", "192.0.2.2"); rr.Code != http.StatusTooManyRequests {
+			t.Errorf("header de cliente não confiável não deveria valer como chave, recebeu %d", rr.Code)
+		}
+	})
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	now := time.Unix(0, 0)
+	limiter := &rateLimiter{
+		cfg:     RateLimitConfig{Rate: 1, Burst: 1, IdleTTL: time.Minute},
+		buckets: map[string]*rateBucket{},
+		now:     func() time.Time { return now },
+	}
+
+	if _, ok := limiter.allow("a"); !ok {
+		t.Fatal("primeira request deveria passar")
+	}
+	if wait, ok := limiter.allow("a"); ok || wait != time.Second {
+		t.Errorf("esperava espera de 1s, recebeu %v %v", wait, ok)
+	}
+	now = now.Add(time.Second)
+	if _, ok := limiter.allow("a"); !ok {
+		t.Error("o token deveria ter voltado depois de 1s")
+	}
+
+	now = now.Add(2 * time.Minute)
+	limiter.allow("b")
+	if _, ok := limiter.buckets["a"]; ok {
+		t.Error("cliente parado deveria sair da memória depois do IdleTTL")
+	}
+}
+
+func TestRateLimitInvalidRate(t *testing.T) {
+	if os.Getenv("TUPA_TEST_RATE_LIMIT_INVALID") == "1" {
+		RateLimit(RateLimitConfig{})
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestRateLimitInvalidRate$")
+	cmd.Env = append(os.Environ(), "TUPA_TEST_RATE_LIMIT_INVALID=1")
+	out, err := cmd.CombinedOutput()
+	if want := "RateLimit precisa de Rate maior que zero"; err == nil || !strings.Contains(string(out), want) {
+		t.Errorf("esperava o processo encerrado com %q, recebeu %v:\n%s", want, err, out)
+	}
+}
@@ -0,0 +1,254 @@
+package tupa
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Renderer é usado por tc.Render. HTMLRenderer é a implementação padrão, com html/template
+type Renderer interface {
+	Render(w io.Writer, name string, data any, tc *TupaContext) error
+}
+
+func (a *APIServer) SetRenderer(renderer Renderer) {
+	a.renderer = renderer
+}
+
+// Render renderiza o template name com data e escreve na response com o status informado.
+// O template é renderizado num buffer antes, então um erro no template não deixa uma resposta pela metade
+func (tc *TupaContext) Render(status int, name string, data any) error {
+	if tc.api == nil || tc.api.renderer == nil {
+		return errors.New("Nenhum Renderer configurado, use APIServer.SetRenderer")
+	}
+
+	var buf bytes.Buffer
+	if err := tc.api.renderer.Render(&buf, name, data, tc); err != nil {
+		return err
+	}
+
+	if tc.Resp.Header().Get("Content-Type") == "" {
+		tc.Resp.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	tc.Resp.WriteHeader(status)
+	_, err := buf.WriteTo(tc.Resp)
+	return err
+}
+
+// RequestID retorna o id da request definido com SetRequestID ou, se não tiver, o header X-Request-ID
+func (tc *TupaContext) RequestID() string {
+	if id, ok := tc.value(requestIDKey).(string); ok {
+		return id
+	}
+	if tc.Req != nil {
+		return tc.Req.Header.Get("X-Request-ID")
+	}
+	return ""
+}
+
+func (tc *TupaContext) SetRequestID(id string) {
+	tc.setValue(requestIDKey, id)
+}
+
+// CSRFToken retorna o token definido pelo middleware de CSRF da aplicação com SetCSRFToken
+func (tc *TupaContext) CSRFToken() string {
+	if token, ok := tc.value(csrfTokenKey).(string); ok {
+		return token
+	}
+	return ""
+}
+
+func (tc *TupaContext) SetCSRFToken(token string) {
+	tc.setValue(csrfTokenKey, token)
+}
+
+type HTMLRendererConfig struct {
+	// FS de onde os templates são lidos, e.g. um embed.FS. Se for nulo usa os.DirFS(Dir)
+	FS  fs.FS
+	Dir string
+	// Extension dos arquivos de template. Vazio usa ".html"
+	Extension string
+	// Layout é o arquivo que envolve todas as páginas, e.g. "layouts/base.html". A página define os
+	// blocos ( {{define "content"}} ) que o layout usa com {{block "content" .}}
+	Layout string
+	// PartialsDir é o diretório com templates que ficam disponíveis em todas as páginas com {{template "nome" .}}
+	PartialsDir string
+	Funcs       template.FuncMap
+	// DevMode confere os arquivos a cada render e relê os templates quando algum muda, para não precisar
+	// reiniciar o servidor. É para desenvolvimento: cada render percorre o diretório dos templates
+	DevMode bool
+}
+
+// HTMLRenderer carrega cada página junto com o layout e os partials. O nome usado no Render é o path
+// do arquivo sem a extensão, e.g. "users/show" para users/show.html.
+//
+// Além das Funcs configuradas, os templates tem acesso a valores da request atual:
+// {{csrfToken}}, {{requestID}} e {{cspNonce}}
+type HTMLRenderer struct {
+	config HTMLRendererConfig
+
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+	// version muda quando arquivos são alterados, criados ou apagados, usado pelo DevMode
+	version templatesVersion
+}
+
+type templatesVersion struct {
+	files   int
+	modTime time.Time
+}
+
+func NewHTMLRenderer(cfg HTMLRendererConfig) (*HTMLRenderer, error) {
+	if cfg.FS == nil {
+		if cfg.Dir == "" {
+			return nil, errors.New("HTMLRendererConfig precisa de FS ou Dir")
+		}
+		cfg.FS = os.DirFS(cfg.Dir)
+	}
+	if cfg.Extension == "" {
+		cfg.Extension = ".html"
+	}
+
+	r := &HTMLRenderer{config: cfg}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// requestFuncs são registradas vazias no parse e trocadas pelos valores da request em cada Render
+func requestFuncs(tc *TupaContext) template.FuncMap {
+	return template.FuncMap{
+		"csrfToken": func() string {
+			if tc == nil {
+				return ""
+			}
+			return tc.CSRFToken()
+		},
+		"requestID": func() string {
+			if tc == nil {
+				return ""
+			}
+			return tc.RequestID()
+		},
+		"cspNonce": func() string {
+			if tc == nil {
+				return ""
+			}
+			return tc.CSPNonce()
+		},
+	}
+}
+
+// currentVersion percorre os templates só com stat, sem ler os arquivos
+func (r *HTMLRenderer) currentVersion() (templatesVersion, error) {
+	var version templatesVersion
+	err := fs.WalkDir(r.config.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(p, r.config.Extension) {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		version.files++
+		if info.ModTime().After(version.modTime) {
+			version.modTime = info.ModTime()
+		}
+		return nil
+	})
+	return version, err
+}
+
+func (r *HTMLRenderer) load() error {
+	cfg := r.config
+
+	version, err := r.currentVersion()
+	if err != nil {
+		return err
+	}
+
+	var partials, pages []string
+	err = fs.WalkDir(cfg.FS, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(p, cfg.Extension) || p == cfg.Layout {
+			return nil
+		}
+		if cfg.PartialsDir != "" && strings.HasPrefix(p, strings.TrimSuffix(cfg.PartialsDir, "/")+"/") {
+			partials = append(partials, p)
+			return nil
+		}
+		pages = append(pages, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	templates := make(map[string]*template.Template, len(pages))
+	for _, page := range pages {
+		name := strings.TrimSuffix(page, cfg.Extension)
+
+		files := []string{}
+		if cfg.Layout != "" {
+			files = append(files, cfg.Layout)
+		}
+		files = append(files, partials...)
+		files = append(files, page)
+
+		// o primeiro arquivo vira o template principal, que é o layout quando existe
+		t := template.New(path.Base(files[0])).Funcs(requestFuncs(nil)).Funcs(cfg.Funcs)
+		t, err := t.ParseFS(cfg.FS, files...)
+		if err != nil {
+			return fmt.Errorf("erro ao carregar o template %s: %w", page, err)
+		}
+		templates[name] = t
+	}
+
+	r.mu.Lock()
+	r.templates = templates
+	r.version = version
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *HTMLRenderer) Render(w io.Writer, name string, data any, tc *TupaContext) error {
+	if r.config.DevMode {
+		version, err := r.currentVersion()
+		if err != nil {
+			return err
+		}
+		r.mu.RLock()
+		changed := version != r.version
+		r.mu.RUnlock()
+		if changed {
+			if err := r.load(); err != nil {
+				return err
+			}
+		}
+	}
+
+	r.mu.RLock()
+	t, ok := r.templates[name]
+	r.mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("template não encontrado: %s", name)
+	}
+
+	// o template carregado nunca é executado, só os clones, senão não daria mais para clonar
+	clone, err := t.Clone()
+	if err != nil {
+		return err
+	}
+	return clone.Funcs(requestFuncs(tc)).Execute(w, data)
+}
@@ -0,0 +1,144 @@
+package tupa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestHTMLRenderer(t *testing.T) {
+	fsys := fstest.MapFS{
+		"layouts/base.html":    {Data: []byte(`<html>{{template "header.html" .}}{{block "content" .}}{{end}}<script nonce="{{cspNonce}}"></script></html>`)},
+		"partials/header.html": {Data: []byte(`<h1>{{.Title}}</h1>`)},
+		"users/show.html":      {Data: []byte(`{{define "content"}}<p>{{.Name | upper}}</p><input value="{{csrfToken}}"><i>{{requestID}}</i>{{end}}`)},
+	}
+
+	renderer, err := NewHTMLRenderer(HTMLRendererConfig{
+		FS:          fsys,
+		Layout:      "layouts/base.html",
+		PartialsDir: "partials",
+		Funcs:       map[string]any{"upper": strings.ToUpper},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := NewAPIServer(":8080", nil)
+	server.SetRenderer(renderer)
+	server.RegisterRoutes([]RouteInfo{
+		{
+			Path:   "/render/users/{name}",
+			Method: MethodGet,
+			Middlewares: []MiddlewareFunc{
+				SecureHeaders(SecureHeadersConfig{CSP: NewCSP().ScriptSrc("'self'").WithNonce("script-src")}),
+				func(next APIFunc) APIFunc {
+					return func(tc *TupaContext) error {
+						tc.SetCSRFToken("token-csrf")
+						return next(tc)
+					}
+				},
+			},
+			Handler: func(tc *TupaContext) error {
+				return tc.Render(http.StatusCreated, "users/show", map[string]string{
+					"Title": "Usuário",
+					"Name":  tc.Param("name"),
+				})
+			},
+		},
+		{
+			Path:   "/render/missing",
+			Method: MethodGet,
+			Handler: func(tc *TupaContext) error {
+				return tc.Render(http.StatusOK, "nao/existe", nil)
+			},
+		},
+	})
+
+	t.Run("Teste Render com layout, partial e valores da request", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/render/users/<victor>", nil)
+		req.Header.Set("X-Request-ID", "req-123")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("status recebido %d, queria %d: %s", rr.Code, http.StatusCreated, rr.Body.String())
+		}
+		if got := rr.Header().Get("Content-Type"); got != "text/html; charset=utf-8" {
+			t.Errorf("Content-Type recebido %q", got)
+		}
+
+		body := rr.Body.String()
+		csp := rr.Header().Get("Content-Security-Policy")
+		for _, want := range []string{
+			"<h1>Usuário</h1>",
+			"<p>&lt;VICTOR&gt;</p>",
+			`value="token-csrf"`,
+			"<i>req-123</i>",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("corpo %q não contém %q", body, want)
+			}
+		}
+
+		start := strings.Index(body, `nonce="`) + len(`nonce="`)
+		nonce := body[start : start+strings.Index(body[start:], `"`)]
+		if nonce == "" || !strings.Contains(csp, "'nonce-"+nonce+"'") {
+			t.Errorf("nonce do template %q não bate com o CSP %q", nonce, csp)
+		}
+	})
+
+	t.Run("Teste Render com template inexistente", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/render/missing", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusInternalServerError)
+		}
+	})
+
+	t.Run("Teste Render sem Renderer", func(t *testing.T) {
+		tc := &TupaContext{Req: httptest.NewRequest(http.MethodGet, "/", nil), Resp: httptest.NewRecorder()}
+		if err := tc.Render(http.StatusOK, "qualquer", nil); err == nil {
+			t.Error("esperava erro sem Renderer configurado")
+		}
+	})
+}
+
+func TestHTMLRendererDevMode(t *testing.T) {
+	dir := t.TempDir()
+	page := filepath.Join(dir, "home.html")
+	os.WriteFile(page, []byte("versão 1"), 0o644)
+
+	renderer, err := NewHTMLRenderer(HTMLRendererConfig{Dir: dir, DevMode: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	renderer.Render(&out, "home", nil, nil)
+	if out.String() != "versão 1" {
+		t.Fatalf("recebeu %q, queria %q", out.String(), "versão 1")
+	}
+
+	loaded := renderer.templates["home"]
+	renderer.Render(&out, "home", nil, nil)
+	if renderer.templates["home"] != loaded {
+		t.Errorf("template relido sem mudança nos arquivos")
+	}
+
+	os.WriteFile(page, []byte("versão 2"), 0o644)
+	// garante uma data diferente mesmo em sistemas de arquivo com resolução baixa
+	later := time.Now().Add(time.Second)
+	os.Chtimes(page, later, later)
+	out.Reset()
+	renderer.Render(&out, "home", nil, nil)
+	if out.String() != "versão 2" {
+		t.Errorf("template não foi recarregado, recebeu %q", out.String())
+	}
+}
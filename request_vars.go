@@ -3,6 +3,7 @@ package tupa
 import (
 	"context"
 	"net/http"
+	"net/url"
 	"strings"
 )
 
@@ -11,7 +12,15 @@ type contextKey int
 
 // o tipo iota é usado para criar uma sequencia em tempo de compilação
 // que vai ser usada para criar uma chave unica para o contexto
-const varsKey contextKey = iota
+const (
+	varsKey contextKey = iota
+	cspNonceKey
+	requestIDKey
+	csrfTokenKey
+	apiVersionKey
+	spanKey
+	traceMiddlewaresKey
+)
 
 // WithVars adiciona variáveis de rota para o contexto da request
 func WithVars(r *http.Request, vars map[string]string) *http.Request {
@@ -43,21 +52,38 @@ func extractParams(pattern, path string) map[string]string {
 	pathParts := strings.Split(path, "/")
 	params := make(map[string]string)
 
+	// {nome...} no fim da assinatura pega todo o resto do path, inclusive as '/'
+	// e.g. pattern = '/static/{filepath...}' e path = '/static/css/app.css' vai extrair filepath = 'css/app.css'
+	if last := patternParts[len(patternParts)-1]; strings.HasSuffix(last, "...}") && len(pathParts) >= len(patternParts)-1 {
+		n := len(patternParts) - 1
+		pathParts = append(pathParts[:n:n], strings.Join(pathParts[n:], "/"))
+	}
+
 	if len(patternParts) != len(pathParts) {
 		return params
 	}
 
+	// o path chega escapado para que um %2F dentro de um segmento não seja confundido com uma '/'.
+	// Cada parte só é decodificada depois da divisão
+	for i, part := range pathParts {
+		if unescaped, err := url.PathUnescape(part); err == nil {
+			pathParts[i] = unescaped
+		}
+	}
+
 	for i, patternPart := range patternParts {
 		if strings.HasPrefix(patternPart, "{") && strings.HasSuffix(patternPart, "}") {
 			// removendo os { }
-			paramKeySignature := patternPart[1 : len(patternPart)-1]
+			paramKeySignature := strings.TrimSuffix(patternPart[1:len(patternPart)-1], "...")
 			// se o index é válido, pega a parte correspondente e assina ao parametro
 			// e.g. pattern = '/{hello} e o path = '/hey', vai extrair o 'hey' e armazenar em 'params'
 			// funciona pois patternParts e pathParts vão ter a mesma quantidade de elementos, mas muda os valores - caso tenha parametro de rota
 			if i < len(pathParts) {
 				params[paramKeySignature] = pathParts[i]
 			}
-		} else if patternPart != pathParts[i] {
+		} else if !strings.EqualFold(patternPart, pathParts[i]) {
+			// o mux já garantiu o casamento, inclusive de maiúsculas quando o router não é
+			// case-insensitive, então aqui basta ignorar a diferença
 			// retorna um map vazio caso não tenha parâmetros
 			return make(map[string]string)
 		}
@@ -47,6 +47,24 @@ func TestExtractParams(t *testing.T) {
 			expected:   map[string]string{},
 			shouldFail: true,
 		},
+		{
+			pattern:    "/static/{filepath...}",
+			path:       "/static/css/app.css",
+			expected:   map[string]string{"filepath": "css/app.css"},
+			shouldFail: false,
+		},
+		{
+			pattern:    "/users/{id}/files/{filepath...}",
+			path:       "/users/123/files/",
+			expected:   map[string]string{"id": "123", "filepath": ""},
+			shouldFail: false,
+		},
+		{
+			pattern:    "/static/{filepath...}",
+			path:       "/assets/app.css",
+			expected:   map[string]string{},
+			shouldFail: true,
+		},
 		{
 			pattern:    "/",
 			path:       "/",
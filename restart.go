@@ -0,0 +1,227 @@
+package tupa
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// variáveis que Restart passa para o processo novo, no mesmo formato do LISTEN_FDS do systemd. O
+// LISTEN_PID não é usado porque o pid do filho só existe depois do exec
+const (
+	restartFDsEnv   = "TUPA_LISTEN_FDS"
+	restartNamesEnv = "TUPA_LISTEN_FDNAMES"
+	restartReadyEnv = "TUPA_READY_FD"
+)
+
+// DefaultRestartTimeout é quanto Restart espera o processo novo ficar pronto sem Config.RestartTimeout
+const DefaultRestartTimeout = 30 * time.Second
+
+// restartArgs são os argumentos do processo novo, trocados nos testes
+var restartArgs = func() []string { return os.Args[1:] }
+
+// Restart troca o processo sem derrubar conexões: executa o mesmo binário ( normalmente já
+// atualizado no disco ) com os mesmos argumentos, passando os sockets abertos. O processo novo usa
+// os sockets herdados nos endereços com o mesmo nome ( o listenAddr, os de AddListener e o redirect
+// HTTP de TLSConfig.HTTPRedirectAddr ) e avisa quando Start terminou. Só então este processo para de
+// aceitar conexões e termina as requests em andamento pelo Shutdown. Se o processo novo falhar ou não
+// ficar pronto em Config.RestartTimeout ele é encerrado e este continua atendendo.
+//
+// New() chama Restart no SIGUSR2. Chamado de um handler, precisa rodar em outra goroutine, já que o
+// Shutdown espera a própria request terminar
+func (a *APIServer) Restart() error {
+	a.restartMu.Lock()
+	defer a.restartMu.Unlock()
+	if len(a.listeners) == 0 {
+		return errors.New("servidor não iniciado")
+	}
+	if a.draining.Load() {
+		return errors.New("servidor já está encerrando")
+	}
+
+	var files []*os.File
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+		files = nil
+	}
+	defer closeFiles()
+
+	names := make([]string, 0, len(a.listeners))
+	for _, l := range a.listeners {
+		file, err := listenerFile(l.Listener)
+		if err != nil {
+			return fmt.Errorf("listener %s: %w", l.name, err)
+		}
+		files = append(files, file)
+		names = append(names, url.QueryEscape(l.name))
+	}
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, restartArgs()...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	cmd.Env = append(restartEnv(),
+		restartFDsEnv+"="+strconv.Itoa(len(names)),
+		restartNamesEnv+"="+strings.Join(names, ":"),
+		restartReadyEnv+"="+strconv.Itoa(systemdFirstFD+len(names)),
+	)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("erro ao iniciar processo novo: %w", err)
+	}
+	// as cópias deste processo precisam ser fechadas para o pipe dar EOF se o filho morrer
+	closeFiles()
+
+	timeout := DefaultRestartTimeout
+	if a.config != nil && a.config.RestartTimeout > 0 {
+		timeout = a.config.RestartTimeout
+	}
+	readyErr := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		if errors.Is(err, io.EOF) {
+			err = errors.New("processo terminou antes de ficar pronto")
+		}
+		readyErr <- err
+	}()
+	select {
+	case err = <-readyErr:
+	case <-time.After(timeout):
+		err = fmt.Errorf("processo não ficou pronto em %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return fmt.Errorf("restart cancelado, pid %d: %w", cmd.Process.Pid, err)
+	}
+	// o filho continua depois que este processo sair, Wait só evita um zumbi enquanto o Shutdown espera
+	go cmd.Wait()
+
+	for _, l := range a.listeners {
+		// o arquivo do socket unix agora é do processo novo
+		if unix, ok := l.Listener.(*net.UnixListener); ok {
+			unix.SetUnlinkOnClose(false)
+		}
+	}
+	slog.Info("Restart", "msg:", "processo novo pronto, encerrando este", "pid:", cmd.Process.Pid)
+	a.Shutdown()
+	return nil
+}
+
+// restartEnv é o ambiente atual sem as variáveis de sockets, que não valem para o processo novo
+func restartEnv() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		name, _, _ := strings.Cut(kv, "=")
+		switch name {
+		case "LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", restartFDsEnv, restartNamesEnv, restartReadyEnv:
+			continue
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+var (
+	inheritedOnce      sync.Once
+	inheritedMu        sync.Mutex
+	inheritedListeners []systemdListener
+	inheritedErr       error
+)
+
+// inheritedListenersNamed devolve e tira da lista os sockets que o processo anterior passou com o
+// nome name. Cada socket é usado por um endereço só
+func inheritedListenersNamed(name string) ([]net.Listener, error) {
+	inheritedOnce.Do(func() {
+		inheritedListeners, inheritedErr = readInheritedListeners()
+	})
+	if inheritedErr != nil {
+		return nil, inheritedErr
+	}
+
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	var listeners []net.Listener
+	rest := inheritedListeners[:0]
+	for _, l := range inheritedListeners {
+		if l.name == name {
+			listeners = append(listeners, l.ln)
+		} else {
+			rest = append(rest, l)
+		}
+	}
+	inheritedListeners = rest
+	return listeners, nil
+}
+
+// closeInheritedListeners fecha os sockets herdados que não foram usados
+func closeInheritedListeners() {
+	inheritedMu.Lock()
+	defer inheritedMu.Unlock()
+	for _, l := range inheritedListeners {
+		slog.Warn("Restart", "msg:", "socket herdado sem endereço correspondente", "name:", l.name)
+		l.ln.Close()
+	}
+	inheritedListeners = nil
+}
+
+func readInheritedListeners() ([]systemdListener, error) {
+	defer func() {
+		os.Unsetenv(restartFDsEnv)
+		os.Unsetenv(restartNamesEnv)
+	}()
+
+	count, err := strconv.Atoi(os.Getenv(restartFDsEnv))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv(restartNamesEnv), ":")
+	if len(names) != count {
+		return nil, fmt.Errorf("%s tem %d nomes para %d sockets", restartNamesEnv, len(names), count)
+	}
+
+	listeners := make([]systemdListener, 0, count)
+	for i, escaped := range names {
+		name, err := url.QueryUnescape(escaped)
+		if err != nil {
+			return nil, fmt.Errorf("nome de socket herdado inválido %q: %w", escaped, err)
+		}
+		ln, err := fileListener(systemdFirstFD+i, name)
+		if err != nil {
+			return nil, fmt.Errorf("socket herdado %s: %w", name, err)
+		}
+		listeners = append(listeners, systemdListener{name: name, ln: ln})
+	}
+	return listeners, nil
+}
+
+// signalRestartReady avisa o processo que chamou Restart que este já está atendendo
+func signalRestartReady() {
+	fd, err := strconv.Atoi(os.Getenv(restartReadyEnv))
+	os.Unsetenv(restartReadyEnv)
+	if err != nil {
+		return
+	}
+	ready := os.NewFile(uintptr(fd), "tupa-ready")
+	ready.Write([]byte{1})
+	ready.Close()
+}
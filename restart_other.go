@@ -0,0 +1,20 @@
+//go:build !unix
+
+package tupa
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// sem SIGUSR2 fora do unix, e sem herança de sockets o Restart não funciona
+var restartSignals []os.Signal
+
+func isRestartSignal(os.Signal) bool {
+	return false
+}
+
+func listenerFile(net.Listener) (*os.File, error) {
+	return nil, errors.New("Restart não é suportado neste sistema")
+}
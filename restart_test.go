@@ -0,0 +1,178 @@
+package tupa
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func restartChild(t *testing.T) {
+	served := make(chan struct{}, 1)
+	server := NewAPIServer("127.0.0.1:0", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/restart/quem", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		served <- struct{}{}
+		return tc.SendString("filho " + tc.Req.Host)
+	}}})
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-served:
+	case <-time.After(10 * time.Second):
+		t.Fatal("nenhuma request chegou no processo novo")
+	}
+	server.Shutdown()
+}
+
+// TestRestart roda o próprio binário de teste como o processo novo
+func TestRestart(t *testing.T) {
+	switch os.Getenv("TUPA_TEST_RESTART_CHILD") {
+	case "ok":
+		restartChild(t)
+		return
+	case "falha":
+		os.Exit(1)
+	}
+
+	args := restartArgs
+	restartArgs = func() []string { return []string{"-test.run=^TestRestart$"} }
+	defer func() { restartArgs = args }()
+
+	server := NewAPIServer("127.0.0.1:0", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/restart/quem", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		return tc.SendString("pai " + tc.Req.Host)
+	}}})
+	if err := server.Restart(); err == nil {
+		t.Errorf("esperava erro antes do Start")
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Addrs()[0].String()
+
+	get := func() string {
+		client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}, Timeout: 5 * time.Second}
+		resp, err := client.Get("http://" + addr + "/restart/quem")
+		if err != nil {
+			return err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	t.Run("Teste processo novo que falha mantém o atual", func(t *testing.T) {
+		t.Setenv("TUPA_TEST_RESTART_CHILD", "falha")
+		if err := server.Restart(); err == nil {
+			t.Errorf("esperava erro do processo novo")
+		}
+		if body := get(); body != "pai "+addr {
+			t.Errorf("servidor atual deveria continuar, recebeu %q", body)
+		}
+	})
+
+	t.Run("Teste processo novo herda o socket", func(t *testing.T) {
+		t.Setenv("TUPA_TEST_RESTART_CHILD", "ok")
+		if err := server.Restart(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-server.stopped:
+		default:
+			t.Errorf("Restart deveria terminar com o Shutdown")
+		}
+		if !server.Draining() {
+			t.Errorf("servidor antigo deveria estar em draining")
+		}
+		// mesma porta, atendida pelo processo novo
+		if body := get(); body != "filho "+addr {
+			t.Errorf("esperava o processo novo, recebeu %q", body)
+		}
+	})
+
+	t.Run("Teste ambiente do processo novo", func(t *testing.T) {
+		env := os.Environ()
+		t.Setenv("LISTEN_FDS", "1")
+		t.Setenv(restartReadyEnv, "9")
+		for _, kv := range restartEnv() {
+			if kv == "LISTEN_FDS=1" || kv == restartReadyEnv+"=9" {
+				t.Errorf("%s não deveria passar para o processo novo", kv)
+			}
+		}
+		if len(restartEnv()) != len(env) {
+			t.Errorf("esperava o resto do ambiente")
+		}
+	})
+}
+
+// TestRestartRedirect confere que o socket do redirect HTTP também passa para o processo novo, senão
+// ele não consegue abrir a mesma porta
+func TestRestartRedirect(t *testing.T) {
+	newServer := func(body string) *APIServer {
+		server := NewAPIServer("127.0.0.1:0", func() {})
+		server.RegisterRoutes([]RouteInfo{{Path: "/restart/quem", Method: MethodGet, Handler: func(tc *TupaContext) error {
+			return tc.SendString(body)
+		}}})
+		err := server.SetTLS(TLSConfig{
+			CertFile:         os.Getenv("TUPA_TEST_RESTART_CERT"),
+			KeyFile:          os.Getenv("TUPA_TEST_RESTART_KEY"),
+			HTTPRedirectAddr: os.Getenv("TUPA_TEST_RESTART_REDIRECT"),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return server
+	}
+
+	if os.Getenv("TUPA_TEST_RESTART_CHILD") == "redirect" {
+		server := newServer("filho")
+		if err := server.Start(); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(2 * time.Second)
+		server.Shutdown()
+		return
+	}
+
+	args := restartArgs
+	restartArgs = func() []string { return []string{"-test.run=^TestRestartRedirect$"} }
+	defer func() { restartArgs = args }()
+
+	certFile, keyFile := newTestCert(t, "restart", nil, false).write(t, t.TempDir())
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirectAddr := free.Addr().String()
+	free.Close()
+	t.Setenv("TUPA_TEST_RESTART_CERT", certFile)
+	t.Setenv("TUPA_TEST_RESTART_KEY", keyFile)
+	t.Setenv("TUPA_TEST_RESTART_REDIRECT", redirectAddr)
+
+	server := newServer("pai")
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(server.Addrs()[0].String())
+
+	t.Setenv("TUPA_TEST_RESTART_CHILD", "redirect")
+	if err := server.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		Transport:     &http.Transport{DisableKeepAlives: true},
+	}
+	resp, err := client.Get("http://" + redirectAddr + "/restart/quem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if want := "https://127.0.0.1:" + port + "/restart/quem"; resp.Header.Get("Location") != want {
+		t.Errorf("redirect do processo novo: recebeu %q, queria %q", resp.Header.Get("Location"), want)
+	}
+}
@@ -0,0 +1,49 @@
+//go:build unix
+
+package tupa
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// restartSignals são os sinais que fazem New() chamar Restart
+var restartSignals = []os.Signal{syscall.SIGUSR2}
+
+func isRestartSignal(sig os.Signal) bool {
+	return sig == syscall.SIGUSR2
+}
+
+// listenerFile duplica o descritor do listener para passar ao processo novo. O File() do net não
+// serve: o exec chama Fd(), que deixa o socket compartilhado em modo bloqueante, e o Accept deste
+// processo fica preso no kernel sem acordar no Shutdown
+func listenerFile(ln net.Listener) (*os.File, error) {
+	conn, ok := ln.(syscall.Conn)
+	if !ok {
+		return nil, fmt.Errorf("listener %T não tem descritor", ln)
+	}
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return nil, err
+	}
+	var dup int
+	var dupErr error
+	err = raw.Control(func(fd uintptr) {
+		// o ForkLock evita que outro exec herde o descritor antes do CloseOnExec
+		syscall.ForkLock.RLock()
+		defer syscall.ForkLock.RUnlock()
+		if dup, dupErr = syscall.Dup(int(fd)); dupErr == nil {
+			syscall.CloseOnExec(dup)
+		}
+	})
+	if err == nil {
+		err = dupErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	// NewFile com um descritor não bloqueante mantém o modo no Fd()
+	return os.NewFile(uintptr(dup), ln.Addr().String()), nil
+}
@@ -1,46 +1,325 @@
 package tupa
 
 import (
+	"log"
 	"net/http"
+	"net/url"
+	pathpkg "path"
+	"sort"
+	"strings"
 )
 
 // MIDDLEWARES NOT BEING USED LIKE THAT 'YET?'
 type Middleware func(http.Handler) http.Handler
 type MiddChain []Middleware
 
+type TrailingSlashPolicy int
+
+const (
+	// TrailingSlashStrict trata "/users/1" e "/users/1/" como rotas diferentes
+	TrailingSlashStrict TrailingSlashPolicy = iota
+	// TrailingSlashRedirect redireciona para a versão que foi registrada
+	TrailingSlashRedirect
+	// TrailingSlashTolerate atende as duas versões sem redirecionar
+	TrailingSlashTolerate
+)
+
+// RouterOptions muda como o path da request é comparado com as rotas. O valor zero mantém o
+// comportamento do http.ServeMux
+type RouterOptions struct {
+	TrailingSlash TrailingSlashPolicy
+	// CleanPath redireciona paths com "..", "." ou "//" para a versão limpa. GET e HEAD recebem 301,
+	// os outros métodos 308 para que o cliente repita o método e o corpo
+	CleanPath bool
+	// CaseInsensitive ignora maiúsculas nas partes fixas do path. Os parâmetros chegam como foram enviados.
+	// Precisa ser configurado antes de registrar as rotas
+	CaseInsensitive bool
+}
+
 type Router struct {
-	Mux *http.ServeMux
+	Mux     *http.ServeMux
+	Options RouterOptions
+	// NotFound responde quando nenhuma rota casa com o path. Nulo usa http.NotFound
+	NotFound http.Handler
+	// MethodNotAllowed responde quando o path existe mas não para o método da request.
+	// O header Allow já chega preenchido. Nulo responde 405 em texto
+	MethodNotAllowed http.Handler
 	// Middlewares []Middleware
+
+	// handlers por path e depois por método, já que o mux só recebe o path
+	routes map[string]*routeEntry
 }
 
 func NewRouter() *Router {
 	// func NewRouter(mw ...Middleware) *Router {
 	return &Router{
-		Mux: http.NewServeMux(),
+		Mux:    http.NewServeMux(),
+		routes: map[string]*routeEntry{},
 		// Middlewares: mw,
 	}
 }
 
 // MIDDLEWARES NÃO ESTÃO SENDO USADOS AINDA
 func (r *Router) Handle(method, path string, fn http.HandlerFunc, mw ...Middleware) {
+	r.HandleHost("", method, path, fn)
+}
+
+// HandleHost registra a rota só para requests cujo Host casa com host, e.g. "admin.example.com"
+// ou "{tenant}.example.com". host vazio atende qualquer Host
+func (r *Router) HandleHost(host, method, path string, fn http.HandlerFunc) {
 	// wrappedHandler := r.Wrap(fn, mw...)
-	r.Mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != method {
-			http.NotFound(w, r)
-			return
+	// com CaseInsensitive "/Users" e "/users" são a mesma rota, então a chave é o pattern do mux
+	pattern := muxPattern(path, r.Options.CaseInsensitive)
+	entry, exists := r.routes[pattern]
+	if !exists {
+		entry = &routeEntry{router: r, path: path}
+		r.handleMux(pattern, entry)
+		r.routes[pattern] = entry
+	}
+
+	methods := entry.methodsFor(host)
+	if _, dup := methods[method]; dup {
+		log.Fatalf("%s%s %s%s", FmtRed("Rota registrada mais de uma vez: "), method, host, path)
+	}
+	methods[method] = fn
+}
+
+// handleMux registra no mux, que entra em panic com patterns conflitantes, e.g. "/users/{id}" e
+// "/users/{name}"
+func (r *Router) handleMux(pattern string, entry *routeEntry) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Fatalf("%s%v", FmtRed("Rota conflitante: "), err)
+		}
+	}()
+	r.Mux.Handle(pattern, entry)
+}
+
+// muxPattern adapta o path para o ServeMux. "/" sozinho casaria com qualquer path e
+// nenhuma request chegaria no NotFound, então vira exato
+func muxPattern(path string, caseInsensitive bool) string {
+	if path == "/" {
+		return "/{$}"
+	}
+	if !caseInsensitive {
+		return path
+	}
+	// só as partes fixas, o nome dos parâmetros continua igual
+	parts := strings.Split(path, "/")
+	for i, part := range parts {
+		if !strings.HasPrefix(part, "{") {
+			parts[i] = strings.ToLower(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// routeEntry é o que fica registrado no mux para cada path. Ter um tipo próprio permite
+// diferenciar as rotas dos redirects que o mux devolve sozinho
+type routeEntry struct {
+	router *Router
+	path   string
+	// hosts fica ordenado do mais específico ( host fixo ) para o menos ( qualquer host )
+	hosts []*hostRoutes
+}
+
+type hostRoutes struct {
+	host    hostPattern
+	methods map[string]http.HandlerFunc
+}
+
+func (e *routeEntry) methodsFor(host string) map[string]http.HandlerFunc {
+	for _, h := range e.hosts {
+		if h.host.raw == host {
+			return h.methods
 		}
-		// path será a assinatura da URL na api. r.URL.Path será a assinatura da URL no client
-		println("path: ", path)
-		println("path: ", r.URL.Path)
-		params := extractParams(path, r.URL.Path)
-		r = WithVars(r, params)
-		fn.ServeHTTP(w, r)
+	}
+	h := &hostRoutes{host: parseHostPattern(host), methods: map[string]http.HandlerFunc{}}
+	e.hosts = append(e.hosts, h)
+	sort.SliceStable(e.hosts, func(i, j int) bool {
+		return e.hosts[i].host.priority() < e.hosts[j].host.priority()
 	})
+	return h.methods
+}
+
+func (e *routeEntry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var (
+		methods    map[string]http.HandlerFunc
+		hostParams map[string]string
+	)
+	for _, h := range e.hosts {
+		if params, ok := h.host.match(req.Host); ok {
+			methods, hostParams = h.methods, params
+			break
+		}
+	}
+	if methods == nil {
+		// o path existe, mas não para esse Host
+		e.router.notFound(w, req)
+		return
+	}
+
+	fn, ok := methods[req.Method]
+	if !ok && req.Method == http.MethodHead {
+		// rotas GET também respondem HEAD, o net/http se encarrega de não mandar o corpo
+		fn, ok = methods[http.MethodGet]
+	}
+	if !ok {
+		w.Header().Set("Allow", allowHeader(methods))
+		e.router.methodNotAllowed(w, req)
+		return
+	}
+
+	// path será a assinatura da URL na api. r.URL.Path será a assinatura da URL no client
+	params := extractParams(e.path, req.URL.EscapedPath())
+	// parâmetros do host entram junto, mas um parâmetro de path com o mesmo nome tem preferência
+	for name, value := range hostParams {
+		if _, exists := params[name]; !exists {
+			params[name] = value
+		}
+	}
+	for name, value := range params {
+		req.SetPathValue(name, value)
+	}
+	req = WithVars(req, params)
+	fn.ServeHTTP(w, req)
+}
+
+func allowHeader(methods map[string]http.HandlerFunc) string {
+	allowed := make([]string, 0, len(methods)+1)
+	for method := range methods {
+		allowed = append(allowed, method)
+	}
+	if _, ok := methods[http.MethodGet]; ok {
+		if _, ok := methods[http.MethodHead]; !ok {
+			allowed = append(allowed, http.MethodHead)
+		}
+	}
+	sort.Strings(allowed)
+	return strings.Join(allowed, ", ")
+}
+
+func (r *Router) notFound(w http.ResponseWriter, req *http.Request) {
+	if r.NotFound != nil {
+		r.NotFound.ServeHTTP(w, req)
+		return
+	}
+	http.NotFound(w, req)
+}
+
+func (r *Router) methodNotAllowed(w http.ResponseWriter, req *http.Request) {
+	if r.MethodNotAllowed != nil {
+		r.MethodNotAllowed.ServeHTTP(w, req)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// methodMatches diz se a request pode ser atendida pela rota. Rotas GET também respondem HEAD,
+// o net/http se encarrega de não mandar o corpo
+func methodMatches(routeMethod, reqMethod string) bool {
+	return routeMethod == reqMethod || (routeMethod == http.MethodGet && reqMethod == http.MethodHead)
+}
+
+// SetRouterOptions configura como o servidor compara paths com as rotas. CaseInsensitive só
+// vale para rotas registradas depois, então o ideal é chamar antes de RegisterRoutes
+func (a *APIServer) SetRouterOptions(opts RouterOptions) {
+	if len(a.routes) > 0 && opts.CaseInsensitive != a.router.Options.CaseInsensitive {
+		log.Fatalf("%s", FmtRed("CaseInsensitive precisa ser configurado antes de registrar as rotas"))
+	}
+	a.router.Options = opts
 }
 
 // implementando a interface Handler do método http para usar o router
 func (r *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	r.Mux.ServeHTTP(w, req)
+	path := req.URL.EscapedPath()
+	if r.Options.CleanPath {
+		if clean := cleanPath(path); clean != path {
+			redirectPath(w, req, clean)
+			return
+		}
+	}
+
+	handler, ok := r.lookup(req, path)
+	if _, isRoute := handler.(*routeEntry); !isRoute && r.Options.TrailingSlash != TrailingSlashStrict && path != "/" {
+		alternative := strings.TrimSuffix(path, "/")
+		if alternative == path {
+			alternative = path + "/"
+		}
+		if altHandler, _ := r.lookup(req, alternative); altHandler != nil {
+			if _, isRoute := altHandler.(*routeEntry); isRoute {
+				if r.Options.TrailingSlash == TrailingSlashRedirect {
+					redirectPath(w, req, alternative)
+					return
+				}
+				req = withEscapedPath(req, alternative)
+				handler, ok = altHandler, true
+			}
+		}
+	}
+
+	// sem pattern o mux responderia com o 404 em texto dele
+	if !ok {
+		r.notFound(w, req)
+		return
+	}
+	// além das rotas, pode ser um redirect do próprio mux ( path sujo ou "/lista" -> "/lista/" )
+	handler.ServeHTTP(w, req)
+}
+
+// lookup pergunta ao mux qual handler atende o path, sem alterar a request original
+func (r *Router) lookup(req *http.Request, escapedPath string) (http.Handler, bool) {
+	if r.Options.CaseInsensitive {
+		escapedPath = strings.ToLower(escapedPath)
+	}
+	handler, pattern := r.Mux.Handler(withEscapedPath(req, escapedPath))
+	if pattern == "" {
+		return nil, false
+	}
+	return handler, true
+}
+
+func withEscapedPath(req *http.Request, escapedPath string) *http.Request {
+	u := *req.URL
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		path = escapedPath
+	}
+	u.Path = path
+	u.RawPath = ""
+	if escapedPath != u.EscapedPath() {
+		u.RawPath = escapedPath
+	}
+
+	clone := *req
+	clone.URL = &u
+	return &clone
+}
+
+// cleanPath resolve "." e ".." e junta barras repetidas, mantendo a barra final se existir
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if p[0] != '/' {
+		p = "/" + p
+	}
+	clean := pathpkg.Clean(p)
+	if strings.HasSuffix(p, "/") && clean != "/" {
+		clean += "/"
+	}
+	return clean
+}
+
+func redirectPath(w http.ResponseWriter, req *http.Request, escapedPath string) {
+	status := http.StatusPermanentRedirect
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		status = http.StatusMovedPermanently
+	}
+	if req.URL.RawQuery != "" {
+		escapedPath += "?" + req.URL.RawQuery
+	}
+	http.Redirect(w, req, escapedPath, status)
 }
 
 // func (r *Router) Use(mw ...Middleware) {
@@ -4,6 +4,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
 	"testing"
 )
 
@@ -104,3 +107,102 @@ func TestRouterHandle(t *testing.T) {
 		})
 	}
 }
+
+func TestRouterOptions(t *testing.T) {
+	newRouter := func(opts RouterOptions) *Router {
+		router := NewRouter()
+		router.Options = opts
+		handler := func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.URL.Path + " " + Vars(r)["id"] + r.PathValue("path")))
+		}
+		router.Handle(http.MethodGet, "/Users/{id}", handler)
+		router.Handle(http.MethodPost, "/Users/{id}", handler)
+		router.Handle(http.MethodGet, "/files/{path...}", handler)
+		router.Handle(http.MethodGet, "/lista/", handler)
+		return router
+	}
+
+	tests := []struct {
+		name       string
+		opts       RouterOptions
+		method     string
+		reqPath    string
+		statusCode int
+		body       string
+		location   string
+	}{
+		{"strict com barra final", RouterOptions{}, "GET", "/Users/123/", http.StatusNotFound, "404 page not found\n", ""},
+		{"redirect de barra final", RouterOptions{TrailingSlash: TrailingSlashRedirect}, "GET", "/Users/123/?a=1", http.StatusMovedPermanently, "", "/Users/123?a=1"},
+		{"redirect adicionando barra", RouterOptions{TrailingSlash: TrailingSlashRedirect}, "GET", "/lista", http.StatusMovedPermanently, "", "/lista/"},
+		{"tolerate de barra final", RouterOptions{TrailingSlash: TrailingSlashTolerate}, "GET", "/Users/123/", http.StatusOK, "/Users/123 123", ""},
+		{"clean path GET", RouterOptions{CleanPath: true}, "GET", "/Users//123", http.StatusMovedPermanently, "", "/Users/123"},
+		{"clean path POST", RouterOptions{CleanPath: true}, "POST", "/files/../Users/123", http.StatusPermanentRedirect, "", "/Users/123"},
+		{"case sensitive", RouterOptions{}, "GET", "/users/AbC", http.StatusNotFound, "404 page not found\n", ""},
+		{"case insensitive mantém parâmetro", RouterOptions{CaseInsensitive: true}, "GET", "/uSeRs/AbC", http.StatusOK, "/uSeRs/AbC AbC", ""},
+		{"%2F fica dentro do parâmetro", RouterOptions{}, "GET", "/Users/a%2Fb", http.StatusOK, "/Users/a/b a/b", ""},
+		{"%2F no catch-all", RouterOptions{}, "GET", "/files/dir/a%2Fb%20c", http.StatusOK, "/files/dir/a/b c dir/a/b c", ""},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.reqPath, nil)
+			rr := httptest.NewRecorder()
+			newRouter(test.opts).ServeHTTP(rr, req)
+
+			if rr.Code != test.statusCode {
+				t.Errorf("expected status %d, got %d", test.statusCode, rr.Code)
+			}
+			if test.location != "" && rr.Header().Get("Location") != test.location {
+				t.Errorf("expected Location %q, got %q", test.location, rr.Header().Get("Location"))
+			}
+			if test.body != "" && rr.Body.String() != test.body {
+				t.Errorf("expected body %q, got %q", test.body, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouterCaseInsensitiveSamePath(t *testing.T) {
+	router := NewRouter()
+	router.Options.CaseInsensitive = true
+	router.Handle(http.MethodGet, "/Users", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("get")) })
+	router.Handle(http.MethodPost, "/users", func(w http.ResponseWriter, r *http.Request) { w.Write([]byte("post")) })
+
+	for method, want := range map[string]string{http.MethodGet: "get", http.MethodPost: "post"} {
+		t.Run("Teste "+method+" no mesmo path", func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, httptest.NewRequest(method, "/USERS", nil))
+			if rr.Body.String() != want {
+				t.Errorf("esperava %q, recebeu %d %q", want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+// TestRouterDuplicateRoute roda o próprio binário de teste, já que rota repetida encerra o processo
+func TestRouterDuplicateRoute(t *testing.T) {
+	switch os.Getenv("TUPA_TEST_ROUTER_DUP") {
+	case "metodo":
+		router := NewRouter()
+		router.Options.CaseInsensitive = true
+		router.Handle(http.MethodGet, "/Users", http.NotFound)
+		router.Handle(http.MethodGet, "/users", http.NotFound)
+		return
+	case "conflito":
+		router := NewRouter()
+		router.Handle(http.MethodGet, "/users/{id}", http.NotFound)
+		router.Handle(http.MethodPost, "/users/{name}", http.NotFound)
+		return
+	}
+
+	for name, want := range map[string]string{"metodo": "Rota registrada mais de uma vez", "conflito": "Rota conflitante"} {
+		t.Run("Teste "+name, func(t *testing.T) {
+			cmd := exec.Command(os.Args[0], "-test.run=^TestRouterDuplicateRoute$")
+			cmd.Env = append(os.Environ(), "TUPA_TEST_ROUTER_DUP="+name)
+			out, err := cmd.CombinedOutput()
+			if err == nil || !strings.Contains(string(out), want) {
+				t.Errorf("esperava o processo encerrado com %q, recebeu %v:\n%s", want, err, out)
+			}
+		})
+	}
+}
@@ -0,0 +1,108 @@
+package tupa
+
+import (
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// RegisteredRoute descreve uma rota como ela está de fato registrada no servidor
+type RegisteredRoute struct {
+	Method  HTTPMethod
+	Host    string
+	Pattern string
+	Name    string
+	// Middlewares são os nomes das funções, globais primeiro e depois os da rota, na ordem em que rodam
+	Middlewares      []string
+	AfterMiddlewares []string
+}
+
+// Routes retorna a tabela de rotas registradas, na ordem de registro
+func (a *APIServer) Routes() []RegisteredRoute {
+	table := make([]RegisteredRoute, 0, len(a.routes))
+	for _, route := range a.routes {
+		table = append(table, RegisteredRoute{
+			Method:           route.Method,
+			Host:             route.Host,
+			Pattern:          route.Path,
+			Name:             route.Name,
+			Middlewares:      middlewareNames(a.globalMiddlewares, route.Middlewares),
+			AfterMiddlewares: middlewareNames(a.globalAfterMiddlewares, route.AfterMiddlewares),
+		})
+	}
+	return table
+}
+
+var closureSuffixRegex = regexp.MustCompile(`(\.func\d+)+$`)
+
+func middlewareNames(chains ...[]MiddlewareFunc) []string {
+	var names []string
+	for _, chain := range chains {
+		for _, middleware := range chain {
+			names = append(names, funcName(middleware))
+		}
+	}
+	return names
+}
+
+// funcName devolve o nome de quem criou a função, e.g. "tupa.SecureHeaders" para o middleware
+// retornado por SecureHeaders()
+func funcName(fn any) string {
+	f := runtime.FuncForPC(reflect.ValueOf(fn).Pointer())
+	if f == nil {
+		return "desconhecido"
+	}
+	name := f.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	return closureSuffixRegex.ReplaceAllString(name, "")
+}
+
+// URLFor monta o path da rota com nome name, trocando cada {param} pelo valor escapado. Parâmetros
+// catch-all ( {path...} ) mantêm as barras. Falta de parâmetro é erro, para não gerar links quebrados
+func (a *APIServer) URLFor(name string, params map[string]string, query url.Values) (string, error) {
+	route, ok := a.namedRoutes[name]
+	if !ok {
+		return "", fmt.Errorf("rota %q não existe", name)
+	}
+
+	var missing []string
+	path := openAPIParamRegex.ReplaceAllStringFunc(strings.TrimSuffix(route.Path, "{$}"), func(m string) string {
+		param := m[1 : len(m)-1]
+		catchAll := strings.HasSuffix(param, "...")
+		param = strings.TrimSuffix(param, "...")
+
+		value, ok := params[param]
+		if !ok || (value == "" && !catchAll) {
+			missing = append(missing, param)
+			return m
+		}
+		if !catchAll {
+			return url.PathEscape(value)
+		}
+		segments := strings.Split(value, "/")
+		for i, segment := range segments {
+			segments[i] = url.PathEscape(segment)
+		}
+		return strings.Join(segments, "/")
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("rota %q precisa dos parâmetros: %s", name, strings.Join(missing, ", "))
+	}
+
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	return path, nil
+}
+
+// MustURLFor é URLFor com panic no lugar do erro, para uso em templates e rotas fixas
+func (a *APIServer) MustURLFor(name string, params map[string]string, query url.Values) string {
+	path, err := a.URLFor(name, params, query)
+	if err != nil {
+		panic(err)
+	}
+	return path
+}
@@ -0,0 +1,85 @@
+package tupa
+
+import (
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRoutesAndURLFor(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	server.UseGlobalMiddlewares(SecureHeaders())
+	server.RegisterRoutes([]RouteInfo{
+		{
+			Name:        "user.show",
+			Path:        "/urlfor/users/{id}/posts/{slug}",
+			Method:      MethodGet,
+			Handler:     func(tc *TupaContext) error { return nil },
+			Middlewares: []MiddlewareFunc{Timeout(0)},
+		},
+		{
+			Name:    "files",
+			Path:    "/urlfor/files/{path...}",
+			Method:  MethodGet,
+			Handler: func(tc *TupaContext) error { return nil },
+		},
+	})
+
+	t.Run("Teste tabela de rotas", func(t *testing.T) {
+		routes := server.Routes()
+		if len(routes) != 2 {
+			t.Fatalf("esperava 2 rotas, recebeu %d", len(routes))
+		}
+		want := RegisteredRoute{
+			Method:      MethodGet,
+			Pattern:     "/urlfor/users/{id}/posts/{slug}",
+			Name:        "user.show",
+			Middlewares: []string{"tupa.SecureHeaders", "tupa.Timeout"},
+		}
+		if !reflect.DeepEqual(routes[0], want) {
+			t.Errorf("rota recebida %+v, queria %+v", routes[0], want)
+		}
+	})
+
+	t.Run("Teste URLFor", func(t *testing.T) {
+		tests := []struct {
+			name   string
+			route  string
+			params map[string]string
+			query  url.Values
+			want   string
+		}{
+			{"params escapados", "user.show", map[string]string{"id": "a/b c", "slug": "olá"}, nil, "/urlfor/users/a%2Fb%20c/posts/ol%C3%A1"},
+			{"com query", "user.show", map[string]string{"id": "1", "slug": "x"}, url.Values{"page": {"2"}, "q": {"a&b"}}, "/urlfor/users/1/posts/x?page=2&q=a%26b"},
+			{"catch-all mantém barras", "files", map[string]string{"path": "docs/meu arquivo.txt"}, nil, "/urlfor/files/docs/meu%20arquivo.txt"},
+		}
+		for _, tt := range tests {
+			got, err := server.URLFor(tt.route, tt.params, tt.query)
+			if err != nil {
+				t.Errorf("%s: erro inesperado %v", tt.name, err)
+				continue
+			}
+			if got != tt.want {
+				t.Errorf("%s: recebeu %q, queria %q", tt.name, got, tt.want)
+			}
+		}
+	})
+
+	t.Run("Teste URLFor com parâmetro faltando", func(t *testing.T) {
+		_, err := server.URLFor("user.show", map[string]string{"id": "1"}, nil)
+		if err == nil || !strings.Contains(err.Error(), "slug") {
+			t.Errorf("esperava erro citando o parâmetro slug, recebeu %v", err)
+		}
+		if _, err := server.URLFor("nao.existe", nil, nil); err == nil {
+			t.Error("esperava erro para rota inexistente")
+		}
+
+		defer func() {
+			if recover() == nil {
+				t.Error("esperava panic de MustURLFor")
+			}
+		}()
+		server.MustURLFor("user.show", nil, nil)
+	})
+}
@@ -0,0 +1,266 @@
+package tupa
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CSP é um builder para o header Content-Security-Policy.
+// As diretivas são escritas na ordem em que foram adicionadas
+type CSP struct {
+	directives []cspDirective
+	nonceFor   map[string]bool
+}
+
+type cspDirective struct {
+	name    string
+	sources []string
+}
+
+func NewCSP() *CSP {
+	return &CSP{nonceFor: map[string]bool{}}
+}
+
+// Add adiciona fontes a uma diretiva. Chamar mais de uma vez com a mesma diretiva junta as fontes
+func (c *CSP) Add(directive string, sources ...string) *CSP {
+	for i := range c.directives {
+		if c.directives[i].name == directive {
+			c.directives[i].sources = append(c.directives[i].sources, sources...)
+			return c
+		}
+	}
+	c.directives = append(c.directives, cspDirective{name: directive, sources: sources})
+	return c
+}
+
+func (c *CSP) DefaultSrc(sources ...string) *CSP     { return c.Add("default-src", sources...) }
+func (c *CSP) ScriptSrc(sources ...string) *CSP      { return c.Add("script-src", sources...) }
+func (c *CSP) StyleSrc(sources ...string) *CSP       { return c.Add("style-src", sources...) }
+func (c *CSP) ImgSrc(sources ...string) *CSP         { return c.Add("img-src", sources...) }
+func (c *CSP) ConnectSrc(sources ...string) *CSP     { return c.Add("connect-src", sources...) }
+func (c *CSP) FontSrc(sources ...string) *CSP        { return c.Add("font-src", sources...) }
+func (c *CSP) ObjectSrc(sources ...string) *CSP      { return c.Add("object-src", sources...) }
+func (c *CSP) MediaSrc(sources ...string) *CSP       { return c.Add("media-src", sources...) }
+func (c *CSP) FrameSrc(sources ...string) *CSP       { return c.Add("frame-src", sources...) }
+func (c *CSP) FrameAncestors(sources ...string) *CSP { return c.Add("frame-ancestors", sources...) }
+func (c *CSP) BaseURI(sources ...string) *CSP        { return c.Add("base-uri", sources...) }
+func (c *CSP) FormAction(sources ...string) *CSP     { return c.Add("form-action", sources...) }
+func (c *CSP) ReportURI(uri string) *CSP             { return c.Add("report-uri", uri) }
+func (c *CSP) ReportTo(group string) *CSP            { return c.Add("report-to", group) }
+
+// UpgradeInsecureRequests adiciona a diretiva sem valor 'upgrade-insecure-requests'
+func (c *CSP) UpgradeInsecureRequests() *CSP { return c.Add("upgrade-insecure-requests") }
+
+// WithNonce faz com que as diretivas informadas recebam um 'nonce-<valor>' gerado a cada request.
+// O valor pode ser lido no handler com tc.CSPNonce()
+func (c *CSP) WithNonce(directives ...string) *CSP {
+	if len(directives) == 0 {
+		directives = []string{"script-src", "style-src"}
+	}
+	for _, d := range directives {
+		c.nonceFor[d] = true
+		c.Add(d)
+	}
+	return c
+}
+
+func (c *CSP) usesNonce() bool {
+	return len(c.nonceFor) > 0
+}
+
+// Build monta o valor do header. Se nonce estiver vazio as diretivas com nonce ficam sem ele
+func (c *CSP) Build(nonce string) string {
+	var b strings.Builder
+	for i, d := range c.directives {
+		if i > 0 {
+			b.WriteString("; ")
+		}
+		b.WriteString(d.name)
+		for _, src := range d.sources {
+			b.WriteByte(' ')
+			b.WriteString(src)
+		}
+		if nonce != "" && c.nonceFor[d.name] {
+			b.WriteString(" 'nonce-" + nonce + "'")
+		}
+	}
+	return b.String()
+}
+
+type HSTSConfig struct {
+	MaxAge            time.Duration
+	IncludeSubDomains bool
+	Preload           bool
+}
+
+func (h HSTSConfig) String() string {
+	v := "max-age=" + strconv.FormatInt(int64(h.MaxAge/time.Second), 10)
+	if h.IncludeSubDomains {
+		v += "; includeSubDomains"
+	}
+	if h.Preload {
+		v += "; preload"
+	}
+	return v
+}
+
+// SecureHeadersConfig define quais headers de segurança serão enviados.
+// Campos vazios ( ou nil ) não geram header nenhum
+type SecureHeadersConfig struct {
+	CSP           *CSP
+	CSPReportOnly bool
+	// HSTS só é enviado em conexões HTTPS, como manda a RFC 6797. Atrás de um proxy confiável
+	// ( SetTrustedProxies ) vale o proto informado por ele
+	HSTS                      *HSTSConfig
+	FrameOptions              string
+	ContentTypeNosniff        bool
+	XSSProtection             string
+	ReferrerPolicy            string
+	PermissionsPolicy         map[string][]string
+	CrossOriginOpenerPolicy   string
+	CrossOriginEmbedderPolicy string
+	CrossOriginResourcePolicy string
+}
+
+// DefaultSecureHeadersConfig retorna uma configuração restritiva que serve bem para APIs JSON.
+// Para páginas HTML provavelmente será preciso liberar mais fontes no CSP
+func DefaultSecureHeadersConfig() SecureHeadersConfig {
+	return SecureHeadersConfig{
+		CSP: NewCSP().
+			DefaultSrc("'self'").
+			ObjectSrc("'none'").
+			BaseURI("'self'").
+			FrameAncestors("'none'"),
+		HSTS: &HSTSConfig{
+			MaxAge:            180 * 24 * time.Hour,
+			IncludeSubDomains: true,
+		},
+		FrameOptions:       "DENY",
+		ContentTypeNosniff: true,
+		// o filtro XSS dos navegadores antigos mais atrapalha do que ajuda, o recomendado hoje é desligar
+		XSSProtection:  "0",
+		ReferrerPolicy: "strict-origin-when-cross-origin",
+		PermissionsPolicy: map[string][]string{
+			"camera":      {},
+			"geolocation": {},
+			"microphone":  {},
+		},
+		CrossOriginOpenerPolicy:   "same-origin",
+		CrossOriginResourcePolicy: "same-origin",
+	}
+}
+
+func buildPermissionsPolicy(policy map[string][]string) string {
+	features := make([]string, 0, len(policy))
+	for feature := range policy {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+
+	parts := make([]string, 0, len(features))
+	for _, feature := range features {
+		parts = append(parts, feature+"=("+strings.Join(policy[feature], " ")+")")
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SecureHeaders adiciona os headers de segurança na response. Pode ser usado como middleware global
+// ( UseGlobalMiddlewares ) ou de grupo ( AddRoutes ). Sem config usa DefaultSecureHeadersConfig
+func SecureHeaders(cfg ...SecureHeadersConfig) MiddlewareFunc {
+	config := DefaultSecureHeadersConfig()
+	if len(cfg) > 0 {
+		config = cfg[0]
+	}
+
+	// headers que não mudam entre requests são montados uma vez só
+	static := map[string]string{}
+	if config.FrameOptions != "" {
+		static["X-Frame-Options"] = config.FrameOptions
+	}
+	if config.ContentTypeNosniff {
+		static["X-Content-Type-Options"] = "nosniff"
+	}
+	if config.XSSProtection != "" {
+		static["X-XSS-Protection"] = config.XSSProtection
+	}
+	if config.ReferrerPolicy != "" {
+		static["Referrer-Policy"] = config.ReferrerPolicy
+	}
+	if len(config.PermissionsPolicy) > 0 {
+		static["Permissions-Policy"] = buildPermissionsPolicy(config.PermissionsPolicy)
+	}
+	if config.CrossOriginOpenerPolicy != "" {
+		static["Cross-Origin-Opener-Policy"] = config.CrossOriginOpenerPolicy
+	}
+	if config.CrossOriginEmbedderPolicy != "" {
+		static["Cross-Origin-Embedder-Policy"] = config.CrossOriginEmbedderPolicy
+	}
+	if config.CrossOriginResourcePolicy != "" {
+		static["Cross-Origin-Resource-Policy"] = config.CrossOriginResourcePolicy
+	}
+
+	cspHeader := "Content-Security-Policy"
+	if config.CSPReportOnly {
+		cspHeader = "Content-Security-Policy-Report-Only"
+	}
+	var staticCSP string
+	if config.CSP != nil && !config.CSP.usesNonce() {
+		staticCSP = config.CSP.Build("")
+	}
+
+	var hsts string
+	if config.HSTS != nil {
+		hsts = config.HSTS.String()
+	}
+
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			h := tc.Resp.Header()
+			for k, v := range static {
+				h.Set(k, v)
+			}
+
+			if hsts != "" && tc.Scheme() == "https" {
+				h.Set("Strict-Transport-Security", hsts)
+			}
+
+			if config.CSP != nil {
+				if staticCSP != "" {
+					h.Set(cspHeader, staticCSP)
+				} else {
+					nonce, err := generateNonce()
+					if err != nil {
+						return err
+					}
+					tc.setValue(cspNonceKey, nonce)
+					h.Set(cspHeader, config.CSP.Build(nonce))
+				}
+			}
+
+			return next(tc)
+		}
+	}
+}
+
+// generateNonce usa base64 URL-safe sem padding: o valor vai em atributos nonce="..." dos templates, e o
+// html/template escaparia "+", "/" e "=" do base64 padrão, deixando o atributo diferente do header
+func generateNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// CSPNonce retorna o nonce gerado pelo middleware SecureHeaders para a request atual.
+// Retorna vazio se o CSP configurado não usa nonce
+func (tc *TupaContext) CSPNonce() string {
+	if nonce, ok := tc.value(cspNonceKey).(string); ok {
+		return nonce
+	}
+	return ""
+}
@@ -0,0 +1,90 @@
+package tupa
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSecureHeaders(t *testing.T) {
+	t.Run("Teste SecureHeaders com config padrão", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		tc := &TupaContext{Req: req, Resp: w}
+
+		err := SecureHeaders()(func(tc *TupaContext) error { return nil })(tc)
+		if err != nil {
+			t.Fatalf("erro inesperado: %v", err)
+		}
+
+		want := map[string]string{
+			"X-Frame-Options":              "DENY",
+			"X-Content-Type-Options":       "nosniff",
+			"Referrer-Policy":              "strict-origin-when-cross-origin",
+			"Cross-Origin-Opener-Policy":   "same-origin",
+			"Cross-Origin-Resource-Policy": "same-origin",
+			"Permissions-Policy":           "camera=(), geolocation=(), microphone=()",
+			"Content-Security-Policy":      "default-src 'self'; object-src 'none'; base-uri 'self'; frame-ancestors 'none'",
+		}
+		for k, v := range want {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("header %s: recebeu %q, queria %q", k, got, v)
+			}
+		}
+
+		if got := w.Header().Get("Strict-Transport-Security"); got != "" {
+			t.Errorf("HSTS não deveria ser enviado sem TLS, recebeu %q", got)
+		}
+	})
+
+	t.Run("Teste SecureHeaders com HSTS em TLS", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.TLS = &tls.ConnectionState{}
+		w := httptest.NewRecorder()
+		tc := &TupaContext{Req: req, Resp: w}
+
+		cfg := SecureHeadersConfig{
+			HSTS: &HSTSConfig{MaxAge: time.Hour, IncludeSubDomains: true, Preload: true},
+		}
+		SecureHeaders(cfg)(func(tc *TupaContext) error { return nil })(tc)
+
+		if got, want := w.Header().Get("Strict-Transport-Security"), "max-age=3600; includeSubDomains; preload"; got != want {
+			t.Errorf("recebeu %q, queria %q", got, want)
+		}
+		if got := w.Header().Get("X-Frame-Options"); got != "" {
+			t.Errorf("X-Frame-Options não deveria ser enviado, recebeu %q", got)
+		}
+	})
+
+	t.Run("Teste SecureHeaders com nonce", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		tc := &TupaContext{Req: req, Resp: w}
+
+		cfg := SecureHeadersConfig{
+			CSP:           NewCSP().DefaultSrc("'self'").ScriptSrc("'self'").WithNonce("script-src"),
+			CSPReportOnly: true,
+		}
+
+		var nonce string
+		SecureHeaders(cfg)(func(tc *TupaContext) error {
+			nonce = tc.CSPNonce()
+			return nil
+		})(tc)
+
+		if nonce == "" {
+			t.Fatal("esperava nonce no context")
+		}
+		// o nonce vai em atributos HTML, que o html/template escaparia se tivesse "+", "/" ou "="
+		if strings.ContainsAny(nonce, "+/=") {
+			t.Errorf("nonce deveria ser base64 URL-safe sem padding: %q", nonce)
+		}
+		got := w.Header().Get("Content-Security-Policy-Report-Only")
+		if !strings.Contains(got, "script-src 'self' 'nonce-"+nonce+"'") {
+			t.Errorf("CSP não contém o nonce: %q", got)
+		}
+	})
+}
@@ -0,0 +1,342 @@
+package tupa
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type SSEEvent struct {
+	ID    string
+	Event string
+	// Data pode ter várias linhas, cada uma vira um campo 'data:' no evento
+	Data string
+	// Retry diz ao navegador quanto esperar antes de reconectar
+	Retry time.Duration
+}
+
+type SSEConfig struct {
+	// Heartbeat é o intervalo entre os comentários ': ping' que mantém a conexão viva através de proxies.
+	// 0 usa 15 segundos e um valor negativo desliga
+	Heartbeat time.Duration
+	// Retry é enviado logo na abertura do stream, se for maior que 0
+	Retry time.Duration
+}
+
+// SSEStream é um stream de Server-Sent Events aberto com tc.SSE()
+type SSEStream struct {
+	tc *TupaContext
+	rc *http.ResponseController
+
+	mu sync.Mutex
+	// closed é o canal de Done, fechado pelo Close ou quando tc.Ctx termina
+	closed  chan struct{}
+	once    sync.Once
+	stopCtx func() bool
+	// heartbeats espera a goroutine do heartbeat sair no Close
+	heartbeats sync.WaitGroup
+}
+
+// SSE prepara a response para Server-Sent Events e retorna o stream. O stream para quando tc.Ctx é
+// cancelado ( e.g. o cliente desconectou ), então o handler deve sair quando stream.Done() fechar
+func (tc *TupaContext) SSE(cfg ...SSEConfig) (*SSEStream, error) {
+	config := SSEConfig{}
+	if len(cfg) > 0 {
+		config = cfg[0]
+	}
+	if config.Heartbeat == 0 {
+		config.Heartbeat = 15 * time.Second
+	}
+	if tc.Ctx == nil {
+		tc.Ctx = tc.Req.Context()
+	}
+
+	h := tc.Resp.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// desliga o buffer do nginx, senão os eventos só chegam quando o buffer enche
+	h.Set("X-Accel-Buffering", "no")
+	tc.Resp.WriteHeader(http.StatusOK)
+
+	s := &SSEStream{
+		tc:     tc,
+		rc:     http.NewResponseController(tc.Resp),
+		closed: make(chan struct{}),
+	}
+
+	if config.Retry > 0 {
+		if err := s.write("retry: " + strconv.FormatInt(config.Retry.Milliseconds(), 10) + "\n\n"); err != nil {
+			return nil, err
+		}
+	} else if err := s.flush(); err != nil {
+		return nil, err
+	}
+
+	s.stopCtx = context.AfterFunc(tc.Ctx, func() {
+		s.mu.Lock()
+		s.closeLocked()
+		s.mu.Unlock()
+	})
+	if config.Heartbeat > 0 {
+		s.heartbeats.Add(1)
+		go s.heartbeat(config.Heartbeat)
+	}
+	// a response não pode ser usada depois que a request termina, mesmo que o handler esqueça o Close
+	tc.onFinish(s.Close)
+
+	return s, nil
+}
+
+// LastEventID é o id do último evento que o cliente recebeu antes de reconectar
+func (s *SSEStream) LastEventID() string {
+	if id := s.tc.Req.Header.Get("Last-Event-ID"); id != "" {
+		return id
+	}
+	// alguns polyfills de EventSource mandam como query param
+	return s.tc.QueryParam("lastEventId")
+}
+
+// Done fecha quando o cliente desconecta, o prazo de tc.Ctx acaba ou o stream é fechado
+func (s *SSEStream) Done() <-chan struct{} {
+	return s.closed
+}
+
+func (s *SSEStream) Send(event SSEEvent) error {
+	var b strings.Builder
+	if event.ID != "" {
+		b.WriteString("id: " + sanitizeSSEField(event.ID) + "\n")
+	}
+	if event.Event != "" {
+		b.WriteString("event: " + sanitizeSSEField(event.Event) + "\n")
+	}
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+	b.WriteString("\n")
+	return s.write(b.String())
+}
+
+// SendJSON envia v serializado em JSON no campo data
+func (s *SSEStream) SendJSON(event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.Send(SSEEvent{Event: event, Data: string(data)})
+}
+
+// Comment envia um comentário, que o navegador ignora
+func (s *SSEStream) Comment(text string) error {
+	return s.write(": " + sanitizeSSEField(text) + "\n\n")
+}
+
+// Close fecha o stream e espera o heartbeat parar. Depois dele nada mais é escrito na response. Roda
+// sozinho quando a request termina
+func (s *SSEStream) Close() {
+	s.mu.Lock()
+	s.closeLocked()
+	s.mu.Unlock()
+	if s.stopCtx != nil {
+		s.stopCtx()
+	}
+	s.heartbeats.Wait()
+}
+
+func (s *SSEStream) closeLocked() {
+	s.once.Do(func() { close(s.closed) })
+}
+
+var errSSEClosed = errors.New("stream SSE fechado")
+
+func (s *SSEStream) write(payload string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// conferido com o lock para não escrever depois do Close
+	select {
+	case <-s.closed:
+		return errSSEClosed
+	case <-s.tc.Ctx.Done():
+		return s.tc.Ctx.Err()
+	default:
+	}
+
+	if _, err := s.tc.Resp.Write([]byte(payload)); err != nil {
+		s.closeLocked()
+		return err
+	}
+	return s.flushLocked()
+}
+
+func (s *SSEStream) flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+func (s *SSEStream) flushLocked() error {
+	if err := s.rc.Flush(); err != nil {
+		s.closeLocked()
+		return fmt.Errorf("a response não suporta flush, necessário para SSE: %w", err)
+	}
+	return nil
+}
+
+func (s *SSEStream) heartbeat(interval time.Duration) {
+	defer s.heartbeats.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Comment("ping"); err != nil {
+				return
+			}
+		case <-s.closed:
+			return
+		}
+	}
+}
+
+// quebras de linha em id, event ou comentário quebrariam o framing do evento
+func sanitizeSSEField(v string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(v)
+}
+
+// SSEBroker distribui os eventos publicados para todos os clientes conectados e guarda um histórico
+// curto para que um cliente que reconecta com Last-Event-ID receba o que perdeu
+type SSEBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan sseMessage]struct{}
+	history     []sseMessage
+	historySize int
+	seq         uint64
+}
+
+type sseMessage struct {
+	seq   uint64
+	event SSEEvent
+}
+
+// sseSubscriberBuffer é quantos eventos um cliente lento pode acumular antes de ser desconectado.
+// Ao reconectar ele recupera os eventos perdidos pelo histórico
+const sseSubscriberBuffer = 64
+
+func NewSSEBroker(historySize int) *SSEBroker {
+	return &SSEBroker{
+		subscribers: map[chan sseMessage]struct{}{},
+		historySize: historySize,
+	}
+}
+
+// Publish envia o evento para todos os clientes. Eventos sem ID recebem um ID sequencial
+func (b *SSEBroker) Publish(event SSEEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	if event.ID == "" {
+		event.ID = strconv.FormatUint(b.seq, 10)
+	}
+	msg := sseMessage{seq: b.seq, event: event}
+
+	if b.historySize > 0 {
+		b.history = append(b.history, msg)
+		if len(b.history) > b.historySize {
+			b.history = b.history[len(b.history)-b.historySize:]
+		}
+	}
+
+	for ch := range b.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			// cliente lento: fecha o canal para que o handler encerre o stream
+			delete(b.subscribers, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *SSEBroker) Subscribers() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.subscribers)
+}
+
+func (b *SSEBroker) subscribe(lastEventID string) (chan sseMessage, []sseMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan sseMessage, sseSubscriberBuffer)
+	b.subscribers[ch] = struct{}{}
+
+	if lastEventID == "" {
+		return ch, nil
+	}
+	for i, msg := range b.history {
+		if msg.event.ID == lastEventID {
+			return ch, append([]sseMessage(nil), b.history[i+1:]...)
+		}
+	}
+	// o id é mais antigo que o histórico, manda tudo que ainda tem
+	return ch, append([]sseMessage(nil), b.history...)
+}
+
+func (b *SSEBroker) unsubscribe(ch chan sseMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, ok := b.subscribers[ch]; ok {
+		delete(b.subscribers, ch)
+		close(ch)
+	}
+}
+
+// Handler retorna um APIFunc que abre o stream e repassa os eventos do broker até o cliente desconectar
+func (b *SSEBroker) Handler(cfg ...SSEConfig) APIFunc {
+	return func(tc *TupaContext) error {
+		stream, err := tc.SSE(cfg...)
+		if err != nil {
+			return err
+		}
+		defer stream.Close()
+
+		ch, missed := b.subscribe(stream.LastEventID())
+		defer b.unsubscribe(ch)
+
+		var lastSeq uint64
+		for _, msg := range missed {
+			if err := stream.Send(msg.event); err != nil {
+				return nil
+			}
+			lastSeq = msg.seq
+		}
+
+		done := stream.Done()
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return nil
+				}
+				if msg.seq <= lastSeq {
+					continue
+				}
+				if err := stream.Send(msg.event); err != nil {
+					return nil
+				}
+			case <-done:
+				return nil
+			}
+		}
+	}
+}
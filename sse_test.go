@@ -0,0 +1,207 @@
+package tupa
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSSEStream(t *testing.T) {
+	t.Run("Teste framing dos eventos", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/eventos", nil)
+		req.Header.Set("Last-Event-ID", "41")
+		rr := httptest.NewRecorder()
+		tc := &TupaContext{Req: req, Resp: rr, Ctx: req.Context()}
+
+		stream, err := tc.SSE(SSEConfig{Heartbeat: -1, Retry: 3 * time.Second})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		if got := stream.LastEventID(); got != "41" {
+			t.Errorf("LastEventID recebido %q, queria %q", got, "41")
+		}
+
+		stream.Send(SSEEvent{ID: "42", Event: "mensagem", Data: "linha 1\nlinha 2"})
+		stream.SendJSON("json", map[string]int{"total": 1})
+
+		want := "retry: 3000\n\n" +
+			"id: 42\nevent: mensagem\ndata: linha 1\ndata: linha 2\n\n" +
+			"event: json\ndata: {\"total\":1}\n\n"
+		if rr.Body.String() != want {
+			t.Errorf("corpo recebido %q, queria %q", rr.Body.String(), want)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "text/event-stream" {
+			t.Errorf("Content-Type recebido %q", got)
+		}
+		if !rr.Flushed {
+			t.Error("esperava que os eventos fossem enviados com Flush")
+		}
+	})
+
+	t.Run("Teste stream para quando o context é cancelado", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req := httptest.NewRequest(http.MethodGet, "/eventos", nil).WithContext(ctx)
+		tc := &TupaContext{Req: req, Resp: httptest.NewRecorder(), Ctx: ctx}
+
+		stream, err := tc.SSE(SSEConfig{Heartbeat: time.Millisecond})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+
+		select {
+		case <-stream.Done():
+		case <-time.After(time.Second):
+			t.Fatal("stream não terminou depois do cancelamento")
+		}
+		if err := stream.Send(SSEEvent{Data: "tarde"}); err == nil {
+			t.Error("esperava erro ao enviar depois do cancelamento")
+		}
+	})
+
+	t.Run("Teste Done não cria goroutines", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/eventos", nil)
+		tc := &TupaContext{Req: req, Resp: httptest.NewRecorder(), Ctx: req.Context()}
+		stream, err := tc.SSE(SSEConfig{Heartbeat: -1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer stream.Close()
+
+		before := runtime.NumGoroutine()
+		for i := 0; i < 100; i++ {
+			select {
+			case <-stream.Done():
+			default:
+			}
+		}
+		if after := runtime.NumGoroutine(); after > before {
+			t.Errorf("Done criou %d goroutines", after-before)
+		}
+		if stream.Done() != stream.Done() {
+			t.Error("Done deveria devolver sempre o mesmo canal")
+		}
+	})
+}
+
+func TestSSEHandlerReturnsWithoutClose(t *testing.T) {
+	server := NewAPIServer(":8080", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/sse/esquecido", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		stream, err := tc.SSE(SSEConfig{Heartbeat: time.Millisecond})
+		if err != nil {
+			return err
+		}
+		time.Sleep(5 * time.Millisecond)
+		// sem stream.Close(), o fim da request fecha o stream
+		return stream.Send(SSEEvent{Data: "fim"})
+	}}})
+
+	// Background nunca é cancelado, então só o fim da request para o heartbeat
+	req := httptest.NewRequest(http.MethodGet, "/sse/esquecido", nil).WithContext(context.Background())
+	rr := httptest.NewRecorder()
+	server.router.ServeHTTP(rr, req)
+
+	body := rr.Body.String()
+	if !strings.Contains(body, ": ping\n\n") || !strings.Contains(body, "data: fim\n\n") {
+		t.Errorf("corpo inesperado %q", body)
+	}
+	time.Sleep(10 * time.Millisecond)
+	if rr.Body.String() != body {
+		t.Errorf("heartbeat continuou escrevendo depois da request terminar")
+	}
+}
+
+func readSSEEvent(t *testing.T, reader *bufio.Reader) map[string]string {
+	t.Helper()
+	event := map[string]string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("erro lendo evento: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			if len(event) == 0 {
+				continue
+			}
+			return event
+		}
+		if strings.HasPrefix(line, ":") {
+			continue
+		}
+		k, v, _ := strings.Cut(line, ": ")
+		event[k] = v
+	}
+}
+
+func TestSSEBroker(t *testing.T) {
+	broker := NewSSEBroker(10)
+
+	server := NewAPIServer(":8080", nil)
+	server.RegisterRoutes([]RouteInfo{
+		{Path: "/sse/eventos", Method: MethodGet, Handler: broker.Handler(SSEConfig{Heartbeat: -1})},
+	})
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	connect := func(lastEventID string) (*bufio.Reader, func()) {
+		ctx, cancel := context.WithCancel(context.Background())
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL+"/sse/eventos", nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return bufio.NewReader(resp.Body), func() {
+			cancel()
+			resp.Body.Close()
+		}
+	}
+
+	waitSubscribers := func(n int) {
+		deadline := time.Now().Add(time.Second)
+		for broker.Subscribers() != n {
+			if time.Now().After(deadline) {
+				t.Fatalf("esperava %d inscritos, tem %d", n, broker.Subscribers())
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	first, closeFirst := connect("")
+	second, closeSecond := connect("")
+	defer closeSecond()
+	waitSubscribers(2)
+
+	broker.Publish(SSEEvent{Event: "aviso", Data: "um"})
+	broker.Publish(SSEEvent{Event: "aviso", Data: "dois"})
+
+	for _, reader := range []*bufio.Reader{first, second} {
+		if ev := readSSEEvent(t, reader); ev["id"] != "1" || ev["data"] != "um" {
+			t.Errorf("primeiro evento inesperado: %v", ev)
+		}
+		if ev := readSSEEvent(t, reader); ev["id"] != "2" || ev["data"] != "dois" {
+			t.Errorf("segundo evento inesperado: %v", ev)
+		}
+	}
+
+	closeFirst()
+	waitSubscribers(1)
+	broker.Publish(SSEEvent{Event: "aviso", Data: "três"})
+
+	// reconectando com o último id recebido, o cliente recupera o que perdeu
+	resumed, closeResumed := connect("2")
+	defer closeResumed()
+	if ev := readSSEEvent(t, resumed); ev["id"] != "3" || ev["data"] != "três" {
+		t.Errorf("evento recuperado inesperado: %v", ev)
+	}
+}
@@ -0,0 +1,213 @@
+package tupa
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type StaticConfig struct {
+	// Index é o arquivo servido quando o path é um diretório. Vazio usa "index.html"
+	Index string
+	// NoIndex faz diretórios retornarem 404 em vez de servir o Index
+	NoIndex bool
+	// SPA serve o Index da raiz quando o arquivo não existe, para que o roteamento do front-end funcione.
+	// Paths com extensão ( ex: /app.js ) continuam retornando 404
+	SPA bool
+	// MaxAge vai no Cache-Control. 0 não envia Cache-Control
+	MaxAge time.Duration
+	// Precompressed serve o arquivo '<nome>.gz' quando ele existe e o cliente aceita gzip
+	Precompressed bool
+	Middlewares   []MiddlewareFunc
+}
+
+func DefaultStaticConfig() StaticConfig {
+	return StaticConfig{
+		Index:         "index.html",
+		Precompressed: true,
+	}
+}
+
+// Static serve os arquivos do diretório dir a partir de prefix, e.g. Static("/assets", "./public")
+func (a *APIServer) Static(prefix, dir string, cfg ...StaticConfig) {
+	a.StaticFS(prefix, os.DirFS(dir), cfg...)
+}
+
+// StaticFS serve os arquivos de qualquer fs.FS, inclusive embed.FS, a partir de prefix.
+// Respostas tem ETag e Last-Modified, e requests condicionais e com Range são tratadas pelo http.ServeContent
+func (a *APIServer) StaticFS(prefix string, fsys fs.FS, cfg ...StaticConfig) {
+	config := DefaultStaticConfig()
+	if len(cfg) > 0 {
+		config = cfg[0]
+		if config.Index == "" {
+			config.Index = "index.html"
+		}
+	}
+
+	s := &staticServer{fsys: fsys, config: config}
+	a.RegisterRoutes([]RouteInfo{
+		{
+			Path:        strings.TrimSuffix(prefix, "/") + "/{filepath...}",
+			Method:      MethodGet,
+			Handler:     s.serve,
+			Middlewares: config.Middlewares,
+			Hidden:      true,
+		},
+	})
+}
+
+type staticServer struct {
+	fsys   fs.FS
+	config StaticConfig
+	// etags de arquivos sem data de modificação ( embed.FS ), calculadas pelo conteúdo uma vez só
+	etags sync.Map
+}
+
+var errStaticNotFound = APIHandlerErr{Status: http.StatusNotFound, Msg: "Arquivo não encontrado"}
+
+func (s *staticServer) serve(tc *TupaContext) error {
+	// path.Clean resolve os '..' sem nunca sair da raiz, já que o path começa com '/'
+	name := strings.TrimPrefix(path.Clean("/"+tc.Param("filepath")), "/")
+	if name == "" {
+		name = "."
+	}
+	if !fs.ValidPath(name) || strings.Contains(name, "\\") {
+		return errStaticNotFound
+	}
+
+	requested := name
+	file, stat, name, err := s.open(name)
+	if errors.Is(err, fs.ErrNotExist) && s.config.SPA && path.Ext(requested) == "" {
+		file, stat, name, err = s.open(".")
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return errStaticNotFound
+		}
+		return err
+	}
+	defer file.Close()
+
+	h := tc.Resp.Header()
+	if s.config.MaxAge > 0 {
+		h.Set("Cache-Control", "public, max-age="+strconv.FormatInt(int64(s.config.MaxAge/time.Second), 10))
+	}
+
+	if s.config.Precompressed {
+		h.Add("Vary", "Accept-Encoding")
+		if acceptsGzip(tc.Req) {
+			if gz, gzStat, err := s.openFile(name + ".gz"); err == nil {
+				defer gz.Close()
+				ctype := mime.TypeByExtension(path.Ext(name))
+				if ctype == "" {
+					ctype = "application/octet-stream"
+				}
+				h.Set("Content-Type", ctype)
+				h.Set("Content-Encoding", "gzip")
+				return s.serveContent(tc, name+".gz", gz, gzStat)
+			}
+		}
+	}
+
+	return s.serveContent(tc, name, file, stat)
+}
+
+// open abre o arquivo e, se for diretório, o Index dentro dele
+func (s *staticServer) open(name string) (fs.File, fs.FileInfo, string, error) {
+	file, stat, err := s.openFile(name)
+	if err != nil {
+		return nil, nil, name, err
+	}
+	if !stat.IsDir() {
+		return file, stat, name, nil
+	}
+	file.Close()
+
+	if s.config.NoIndex {
+		return nil, nil, name, fs.ErrNotExist
+	}
+	name = path.Join(name, s.config.Index)
+	file, stat, err = s.openFile(name)
+	if err != nil {
+		return nil, nil, name, err
+	}
+	if stat.IsDir() {
+		file.Close()
+		return nil, nil, name, fs.ErrNotExist
+	}
+	return file, stat, name, nil
+}
+
+func (s *staticServer) openFile(name string) (fs.File, fs.FileInfo, error) {
+	file, err := s.fsys.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+	return file, stat, nil
+}
+
+func (s *staticServer) serveContent(tc *TupaContext, name string, file fs.File, stat fs.FileInfo) error {
+	content, ok := file.(io.ReadSeeker)
+	if !ok {
+		data, err := io.ReadAll(file)
+		if err != nil {
+			return err
+		}
+		content = bytes.NewReader(data)
+	}
+
+	etag, err := s.etag(name, content, stat)
+	if err != nil {
+		return err
+	}
+	tc.Resp.Header().Set("ETag", etag)
+
+	http.ServeContent(tc.Resp, tc.Req, name, stat.ModTime(), content)
+	return nil
+}
+
+func (s *staticServer) etag(name string, content io.ReadSeeker, stat fs.FileInfo) (string, error) {
+	if !stat.ModTime().IsZero() {
+		return fmt.Sprintf(`"%x-%x"`, stat.ModTime().UnixNano(), stat.Size()), nil
+	}
+
+	if etag, ok := s.etags.Load(name); ok {
+		return etag.(string), nil
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, content); err != nil {
+		return "", err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	etag := `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+	s.etags.Store(name, etag)
+	return etag, nil
+}
+
+func acceptsGzip(r *http.Request) bool {
+	qValues := acceptEncodingQValues(r.Header.Get("Accept-Encoding"))
+	q, ok := qValues["gzip"]
+	if !ok {
+		q, ok = qValues["*"]
+	}
+	return ok && q > 0
+}
@@ -0,0 +1,135 @@
+package tupa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestStaticFS(t *testing.T) {
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"index.html":         {Data: []byte("<h1>home</h1>"), ModTime: modTime},
+		"css/app.css":        {Data: []byte("body { color: red }"), ModTime: modTime},
+		"js/app.js":          {Data: []byte("console.log('tupã')"), ModTime: modTime},
+		"js/app.js.gz":       {Data: []byte("gzipado"), ModTime: modTime},
+		"docs/index.html":    {Data: []byte("<h1>docs</h1>"), ModTime: modTime},
+		"embed/sem-data.txt": {Data: []byte("sem data de modificação")},
+		"vazio/.gitkeep":     {Data: []byte{}},
+	}
+
+	server := NewAPIServer(":8080", nil)
+	server.StaticFS("/static", fsys, StaticConfig{Precompressed: true, MaxAge: time.Hour})
+	server.StaticFS("/app/", fsys, StaticConfig{SPA: true})
+	server.StaticFS("/noindex", fsys, StaticConfig{NoIndex: true})
+
+	do := func(path string, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		headers    map[string]string
+		statusCode int
+		body       string
+	}{
+		{"arquivo", "/static/css/app.css", nil, http.StatusOK, "body { color: red }"},
+		{"index do diretório", "/static/docs/", nil, http.StatusOK, "<h1>docs</h1>"},
+		{"index da raiz", "/static/", nil, http.StatusOK, "<h1>home</h1>"},
+		{"arquivo inexistente", "/static/nao-existe.css", nil, http.StatusNotFound, ""},
+		{"diretório sem index", "/static/vazio/", nil, http.StatusNotFound, ""},
+		{"NoIndex", "/noindex/docs/", nil, http.StatusNotFound, ""},
+		{"range", "/static/css/app.css", map[string]string{"Range": "bytes=0-3"}, http.StatusPartialContent, "body"},
+		{"SPA fallback", "/app/usuarios/123", nil, http.StatusOK, "<h1>home</h1>"},
+		{"SPA com extensão", "/app/nao-existe.js", nil, http.StatusNotFound, ""},
+		{"pré-comprimido", "/static/js/app.js", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK, "gzipado"},
+		{"pré-comprimido sem gzip", "/static/js/app.js", nil, http.StatusOK, "console.log('tupã')"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rr := do(test.path, test.headers)
+			if rr.Code != test.statusCode {
+				t.Fatalf("status recebido %d, queria %d", rr.Code, test.statusCode)
+			}
+			if test.body != "" && rr.Body.String() != test.body {
+				t.Errorf("corpo recebido %q, queria %q", rr.Body.String(), test.body)
+			}
+		})
+	}
+
+	t.Run("Teste headers do pré-comprimido", func(t *testing.T) {
+		rr := do("/static/js/app.js", map[string]string{"Accept-Encoding": "gzip"})
+		if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("Content-Encoding recebido %q, queria gzip", got)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "text/javascript; charset=utf-8" {
+			t.Errorf("Content-Type recebido %q", got)
+		}
+		if got := rr.Header().Get("Cache-Control"); got != "public, max-age=3600" {
+			t.Errorf("Cache-Control recebido %q", got)
+		}
+	})
+
+	t.Run("Teste ETag e If-None-Match", func(t *testing.T) {
+		rr := do("/static/css/app.css", nil)
+		etag := rr.Header().Get("ETag")
+		if etag == "" || rr.Header().Get("Last-Modified") == "" {
+			t.Fatalf("esperava ETag e Last-Modified, recebeu %v", rr.Header())
+		}
+
+		rr = do("/static/css/app.css", map[string]string{"If-None-Match": etag})
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusNotModified)
+		}
+	})
+
+	t.Run("Teste ETag sem data de modificação", func(t *testing.T) {
+		first := do("/static/embed/sem-data.txt", nil).Header().Get("ETag")
+		second := do("/static/embed/sem-data.txt", nil).Header().Get("ETag")
+		if first == "" || first != second {
+			t.Errorf("ETags diferentes ou vazias: %q %q", first, second)
+		}
+	})
+
+	t.Run("Teste HEAD", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodHead, "/static/css/app.css", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusOK)
+		}
+	})
+
+	t.Run("Teste path traversal", func(t *testing.T) {
+		root := t.TempDir()
+		os.Mkdir(filepath.Join(root, "public"), 0o755)
+		os.WriteFile(filepath.Join(root, "secret.txt"), []byte("nunca"), 0o644)
+
+		s := &staticServer{fsys: os.DirFS(filepath.Join(root, "public")), config: DefaultStaticConfig()}
+		for _, p := range []string{"../secret.txt", "a/../../secret.txt", "..\\secret.txt"} {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			rr := httptest.NewRecorder()
+			tc := &TupaContext{Req: WithVars(req, map[string]string{"filepath": p}), Resp: rr}
+
+			err := s.serve(tc)
+			if rr.Body.String() == "nunca" {
+				t.Errorf("path %q saiu da raiz", p)
+			}
+			if apiErr, ok := err.(APIHandlerErr); !ok || apiErr.Status != http.StatusNotFound {
+				t.Errorf("path %q: esperava 404, recebeu %v", p, err)
+			}
+		}
+	})
+}
@@ -0,0 +1,177 @@
+package tupa
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Timeout define um prazo para o handler. O prazo vai para tc.Ctx e para o context da request,
+// então chamadas que respeitam context ( banco, http client ) são canceladas junto. Se o handler
+// não terminar a tempo o cliente recebe 503 e tudo que o handler escrever depois é descartado.
+// Para uma rota só, também dá para usar RouteInfo.Timeout
+func Timeout(timeout time.Duration) MiddlewareFunc {
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			tc.withTimeout(timeout)
+			return next(tc)
+		}
+	}
+}
+
+func (tc *TupaContext) withTimeout(timeout time.Duration) {
+	if tc.Ctx == nil {
+		tc.Ctx = tc.Req.Context()
+	}
+	ctx, cancel := context.WithTimeout(tc.Ctx, timeout)
+	tc.Ctx = ctx
+	tc.Req = tc.Req.WithContext(ctx)
+	tc.onFinish(cancel)
+}
+
+// runHandler chama o handler da rota. Quando tc.Ctx tem prazo, o handler roda em outra goroutine
+// e a response fica atrás de um timeoutWriter, para que um handler atrasado não consiga mais escrever.
+// O timeout volta como um APIHandlerErr 503, que segue o caminho normal de erro
+func runHandler(tc *TupaContext, handler APIFunc) error {
+	if _, ok := tc.Ctx.Deadline(); !ok {
+		return handler(tc)
+	}
+
+	tw := newTimeoutWriter(tc.Resp)
+	tc.Resp = tw
+
+	// o handler recebe uma cópia do context. Se ele terminar a tempo a cópia volta para tc; se não,
+	// tc fica congelado para o resto da request e o que o handler atrasado mexer ( valores, onFinish )
+	// fica só na cópia. Clip faz o append da cópia alocar outro array de finishers
+	handlerTC := *tc
+	handlerTC.finishers = slices.Clip(tc.finishers)
+
+	done := make(chan error, 1)
+	panicCh := make(chan any, 1)
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicCh <- p
+			}
+		}()
+		done <- handler(&handlerTC)
+	}()
+
+	select {
+	case err := <-done:
+		*tc = handlerTC
+		return err
+	case p := <-panicCh:
+		// devolve o panic para a goroutine do net/http, que sabe se recuperar dele
+		panic(p)
+	case <-tc.Ctx.Done():
+		notStarted := tw.timeout()
+		if !errors.Is(tc.Ctx.Err(), context.DeadlineExceeded) {
+			// o cliente foi embora, não tem para quem responder
+			return nil
+		}
+		if notStarted {
+			// o 503 vai direto para a response, o timeoutWriter já não aceita escritas
+			tc.Resp = tw.w
+		}
+		return APIHandlerErr{
+			Status: http.StatusServiceUnavailable,
+			Msg:    "Tempo limite da requisição excedido",
+		}
+	}
+}
+
+// timeoutWriter tem seu próprio mapa de headers, que só é copiado para a response de verdade
+// na primeira escrita. Depois do timeout qualquer escrita retorna http.ErrHandlerTimeout
+type timeoutWriter struct {
+	w http.ResponseWriter
+	h http.Header
+
+	mu          sync.Mutex
+	timedOut    bool
+	wroteHeader bool
+}
+
+func newTimeoutWriter(w http.ResponseWriter) *timeoutWriter {
+	return &timeoutWriter{w: w, h: w.Header().Clone()}
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.h
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	return tw.w.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeaderLocked(status)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(status int) {
+	if tw.wroteHeader {
+		return
+	}
+	tw.wroteHeader = true
+	dst := tw.w.Header()
+	for k := range dst {
+		if _, ok := tw.h[k]; !ok {
+			delete(dst, k)
+		}
+	}
+	for k, v := range tw.h {
+		dst[k] = v
+	}
+	tw.w.WriteHeader(status)
+}
+
+func (tw *timeoutWriter) Flush() {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return
+	}
+	tw.writeHeaderLocked(http.StatusOK)
+	http.NewResponseController(tw.w).Flush()
+}
+
+// Hijack marca a response como já respondida, para que o timeout não tente escrever o 503
+// numa conexão que agora pertence ao handler ( e.g. WebSocket )
+func (tw *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.timedOut {
+		return nil, nil, http.ErrHandlerTimeout
+	}
+	tw.wroteHeader = true
+	return http.NewResponseController(tw.w).Hijack()
+}
+
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return tw.w
+}
+
+// timeout bloqueia novas escritas e diz se ainda dá para mandar a resposta de erro
+// ( ou seja, se o handler ainda não tinha começado a responder )
+func (tw *timeoutWriter) timeout() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.timedOut = true
+	return !tw.wroteHeader
+}
@@ -0,0 +1,124 @@
+package tupa
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	lateWrite := make(chan error, 1)
+
+	server := NewAPIServer(":8080", nil)
+	server.RegisterRoutes([]RouteInfo{
+		{
+			Path:    "/timeout/slow",
+			Method:  MethodGet,
+			Timeout: 20 * time.Millisecond,
+			Handler: func(tc *TupaContext) error {
+				<-tc.Ctx.Done()
+				// o handler atrasado tenta escrever depois do timeout
+				time.Sleep(10 * time.Millisecond)
+				_, err := tc.Resp.Write([]byte("tarde demais"))
+				lateWrite <- err
+				return nil
+			},
+		},
+		{
+			Path:        "/timeout/fast",
+			Method:      MethodGet,
+			Middlewares: []MiddlewareFunc{Timeout(time.Second)},
+			Handler: func(tc *TupaContext) error {
+				if _, ok := tc.Req.Context().Deadline(); !ok {
+					t.Error("esperava prazo no context da request")
+				}
+				return tc.SendString("rápido")
+			},
+		},
+		{
+			Path:   "/timeout/downstream",
+			Method: MethodGet,
+			Handler: func(tc *TupaContext) error {
+				ctx, cancel := context.WithTimeout(tc.Ctx, time.Millisecond)
+				defer cancel()
+				<-ctx.Done()
+				return ctx.Err()
+			},
+		},
+	})
+
+	t.Run("Teste Timeout excedido", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/timeout/slow", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusServiceUnavailable)
+		}
+
+		if err := <-lateWrite; err != http.ErrHandlerTimeout {
+			t.Errorf("escrita atrasada retornou %v, queria %v", err, http.ErrHandlerTimeout)
+		}
+		if body := rr.Body.String(); body == "" || body == "tarde demais" {
+			t.Errorf("corpo inesperado %q", body)
+		}
+	})
+
+	t.Run("Teste timeout passa pelo caminho de erro", func(t *testing.T) {
+		seen := make(chan error, 1)
+		lateDone := make(chan struct{})
+		server.RegisterRoutes([]RouteInfo{{
+			Path:    "/timeout/erro",
+			Method:  MethodGet,
+			Timeout: 20 * time.Millisecond,
+			Handler: func(tc *TupaContext) error {
+				<-tc.Ctx.Done()
+				// mexe no context depois do timeout, enquanto a request termina ( pego pelo -race )
+				for i := 0; i < 100; i++ {
+					tc.onFinish(func() {})
+					tc.setValue(requestIDKey, "tarde")
+				}
+				close(lateDone)
+				return nil
+			},
+			AfterMiddlewares: []MiddlewareFunc{func(next APIFunc) APIFunc {
+				return func(tc *TupaContext) error {
+					seen <- tc.err
+					return next(tc)
+				}
+			}},
+		}})
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/timeout/erro", nil))
+		<-lateDone
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusServiceUnavailable)
+		}
+		if err, ok := (<-seen).(APIHandlerErr); !ok || err.Status != http.StatusServiceUnavailable {
+			t.Errorf("after middleware deveria ver o erro de timeout, viu %v", err)
+		}
+	})
+
+	t.Run("Teste Timeout não excedido", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/timeout/fast", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK || rr.Body.String() != "rápido" {
+			t.Errorf("recebeu %d %q, queria 200 %q", rr.Code, rr.Body.String(), "rápido")
+		}
+	})
+
+	t.Run("Teste prazo excedido em dependência do handler", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/timeout/downstream", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusGatewayTimeout {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusGatewayTimeout)
+		}
+	})
+}
@@ -0,0 +1,314 @@
+package tupa
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertReloader guarda o certificado do servidor e troca por um novo quando os arquivos mudam
+// ( Watch ) ou quando Reload é chamado, e.g. no SIGHUP. Conexões abertas continuam com o
+// certificado antigo, só os próximos handshakes usam o novo
+type CertReloader struct {
+	certFile, keyFile string
+	// clientCAFile é recarregado junto, para trocar as CAs do mTLS sem reiniciar ( SetTLS )
+	clientCAFile string
+
+	mu        sync.RWMutex
+	cert      *tls.Certificate
+	clientCAs *x509.CertPool
+	modTime   time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload lê os arquivos de novo. Se o par novo ( ou o arquivo de CAs ) for inválido o certificado
+// atual continua valendo
+func (r *CertReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("erro ao carregar certificado %s: %w", r.certFile, err)
+	}
+	var clientCAs *x509.CertPool
+	if r.clientCAFile != "" {
+		if clientCAs, err = loadCertPool(r.clientCAFile); err != nil {
+			return err
+		}
+	}
+	modTime := r.latestModTime()
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTime = modTime
+	r.mu.Unlock()
+	return nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+	pem, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("%s não tem certificados PEM válidos", file)
+	}
+	return pool, nil
+}
+
+func (r *CertReloader) latestModTime() time.Time {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile, r.clientCAFile} {
+		if file == "" {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// GetCertificate é usado em tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Watch confere a data de modificação dos arquivos a cada interval e recarrega quando mudam,
+// até ctx terminar
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.mu.RLock()
+			changed := r.latestModTime().After(r.modTime)
+			r.mu.RUnlock()
+			if !changed {
+				continue
+			}
+			if err := r.Reload(); err != nil {
+				slog.Error("TLS", "err:", err)
+				continue
+			}
+			slog.Info("TLS", "msg:", "certificado recarregado", "file:", r.certFile)
+		}
+	}
+}
+
+var tlsClientAuthTypes = map[string]tls.ClientAuthType{
+	"":                   tls.NoClientCert,
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (c TLSConfig) validate() error {
+	var errs []error
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs = append(errs, errors.New("tls.cert_file e tls.key_file precisam ser informados juntos"))
+	}
+	auth, ok := tlsClientAuthTypes[strings.ToLower(c.ClientAuth)]
+	if !ok {
+		errs = append(errs, fmt.Errorf("tls.client_auth inválido %q, use none, request, require, verify_if_given ou require_and_verify", c.ClientAuth))
+	}
+	if (auth == tls.VerifyClientCertIfGiven || auth == tls.RequireAndVerifyClientCert) && c.ClientCAFile == "" {
+		errs = append(errs, errors.New("tls.client_ca_file é obrigatório para verificar certificados de cliente"))
+	}
+	if _, ok := tlsVersions[c.MinVersion]; !ok {
+		errs = append(errs, fmt.Errorf("tls.min_version inválida %q, use 1.2 ou 1.3", c.MinVersion))
+	}
+	if c.ReloadInterval < 0 {
+		errs = append(errs, errors.New("tls.reload_interval não pode ser negativo"))
+	}
+	if c.HTTPRedirectAddr != "" && c.CertFile == "" {
+		errs = append(errs, errors.New("tls.http_redirect_addr precisa de tls.cert_file"))
+	}
+	return errors.Join(errs...)
+}
+
+// SetTLS liga HTTPS em New(). Pode ser chamado direto ou vir de Config.TLS ( NewAPIServerFromConfig )
+func (a *APIServer) SetTLS(cfg TLSConfig) error {
+	if cfg.CertFile == "" {
+		return errors.New("tls.cert_file é obrigatório")
+	}
+	if err := cfg.validate(); err != nil {
+		return err
+	}
+	reloader := &CertReloader{certFile: cfg.CertFile, keyFile: cfg.KeyFile, clientCAFile: cfg.ClientCAFile}
+	if err := reloader.Reload(); err != nil {
+		return err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:     tlsVersions[cfg.MinVersion],
+		GetCertificate: reloader.GetCertificate,
+		ClientAuth:     tlsClientAuthTypes[strings.ToLower(cfg.ClientAuth)],
+	}
+	if cfg.ClientCAFile != "" {
+		// as CAs são lidas a cada handshake para valer o que o reloader carregou por último
+		base := tlsConfig.Clone()
+		tlsConfig.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := base.Clone()
+			reloader.mu.RLock()
+			cfg.ClientCAs = reloader.clientCAs
+			reloader.mu.RUnlock()
+			return cfg, nil
+		}
+	}
+
+	a.tls = &cfg
+	a.tlsConfig = tlsConfig
+	a.certReloader = reloader
+	return nil
+}
+
+// EnableH2C aceita HTTP/2 sem TLS ( h2c ), para quando o TLS termina em um proxy na frente.
+// Precisa de Go 1.24 ou mais novo, em versões anteriores encerra o programa
+func (a *APIServer) EnableH2C() {
+	if !h2cSupported {
+		log.Fatalf("%s%s", FmtRed("h2c precisa de Go 1.24 ou mais novo, versão atual: "), runtime.Version())
+	}
+	a.h2c = true
+}
+
+// ReloadCertificates recarrega o certificado e as CAs de cliente do SetTLS. New() chama no SIGHUP
+func (a *APIServer) ReloadCertificates() error {
+	if a.certReloader == nil {
+		return errors.New("TLS não configurado")
+	}
+	return a.certReloader.Reload()
+}
+
+// startTLSServices inicia o Watch do certificado e o redirect HTTP→HTTPS, parados no Shutdown
+func (a *APIServer) startTLSServices() error {
+	if a.tls == nil {
+		return nil
+	}
+	if a.tls.HTTPRedirectAddr != "" {
+		// aberto como os outros listeners para ser passado ao processo novo em Restart
+		spec, err := parseListenAddr(a.tls.HTTPRedirectAddr)
+		if err != nil {
+			return err
+		}
+		spec.raw = "redirect:" + spec.raw
+		opened, err := spec.open()
+		if err != nil {
+			return fmt.Errorf("redirect HTTP %s: %w", a.tls.HTTPRedirectAddr, err)
+		}
+		ln := opened[0]
+		a.listeners = append(a.listeners, boundListener{name: spec.raw, Listener: ln, redirect: true})
+		a.redirectServer = &http.Server{Handler: HTTPSRedirect(a.httpsPort()), ReadHeaderTimeout: 10 * time.Second}
+		fmt.Println(FmtBlue("Redirect HTTPS iniciado em: " + ln.Addr().String()))
+		go func() {
+			if err := a.redirectServer.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Fatal(FmtRed("Erro ao iniciar redirect HTTP: "), err)
+			}
+		}()
+	}
+	if a.tls.ReloadInterval > 0 {
+		ctx, cancel := context.WithCancel(context.Background())
+		a.stopWatch = cancel
+		go a.certReloader.Watch(ctx, a.tls.ReloadInterval)
+	}
+	return nil
+}
+
+// httpsPort é a porta do primeiro listener TCP, para onde o redirect manda. Com a porta 0 só se sabe
+// depois de abrir
+func (a *APIServer) httpsPort() string {
+	for _, ln := range a.listeners {
+		if !ln.redirect && ln.Addr().Network() == "tcp" {
+			_, port, _ := net.SplitHostPort(ln.Addr().String())
+			return port
+		}
+	}
+	return ""
+}
+
+// newHTTPServer monta o http.Server que New() coloca para rodar
+func (a *APIServer) newHTTPServer() *http.Server {
+	server := &http.Server{
+		Addr:      a.listenAddr,
+		Handler:   a.Handler(),
+		TLSConfig: a.tlsConfig,
+	}
+	if cfg := a.config; cfg != nil {
+		server.ReadTimeout = cfg.ReadTimeout
+		server.ReadHeaderTimeout = cfg.ReadHeaderTimeout
+		server.WriteTimeout = cfg.WriteTimeout
+		server.IdleTimeout = cfg.IdleTimeout
+	}
+	if a.h2c {
+		enableH2C(server)
+	}
+	return server
+}
+
+// HTTPSRedirect redireciona tudo para https no mesmo host. httpsPort vazio ou "443" gera URLs sem porta
+func HTTPSRedirect(httpsPort string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		if httpsPort != "" && httpsPort != "443" {
+			host += ":" + httpsPort
+		}
+
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
+
+// ClientCertificate retorna o certificado do cliente no mTLS, ou nil se ele não foi verificado
+// contra as CAs de ClientCAFile. Com client_auth request ou require o certificado não é verificado,
+// então só aparece em tc.TLS().PeerCertificates e não deve ser usado para autenticar
+func (tc *TupaContext) ClientCertificate() *x509.Certificate {
+	if tc.Req.TLS == nil || len(tc.Req.TLS.VerifiedChains) == 0 || len(tc.Req.TLS.PeerCertificates) == 0 {
+		return nil
+	}
+	return tc.Req.TLS.PeerCertificates[0]
+}
+
+// TLS retorna o estado da conexão TLS, nil para HTTP
+func (tc *TupaContext) TLS() *tls.ConnectionState {
+	return tc.Req.TLS
+}
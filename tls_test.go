@@ -0,0 +1,376 @@
+package tupa
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	}
+	signer, signerKey := tmpl, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) write(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, c.certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, c.keyPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// serveTest sobe o servidor com Start em uma porta livre
+func serveTest(t *testing.T, a *APIServer) string {
+	t.Helper()
+	a.listenAddr = "127.0.0.1:0"
+	if err := a.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { a.server.Close() })
+	return a.Addrs()[0].String()
+}
+
+func TestTLS(t *testing.T) {
+	ca := newTestCert(t, "CA de teste", nil, true)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "servidor-1", ca, false).write(t, dir)
+
+	server := NewAPIServer(":8443", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/tls/eco", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		cn := "anônimo"
+		if cert := tc.ClientCertificate(); cert != nil {
+			cn = cert.Subject.CommonName
+		}
+		return tc.SendString(tc.Req.Proto + " " + cn)
+	}}})
+	if err := server.SetTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTest(t, server)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(clientCerts ...tls.Certificate) (string, string, error) {
+		transport := &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: roots, Certificates: clientCerts},
+			ForceAttemptHTTP2: true,
+		}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + addr + "/tls/eco")
+		if err != nil {
+			return "", "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), resp.TLS.PeerCertificates[0].Subject.CommonName, nil
+	}
+
+	t.Run("Teste HTTPS com HTTP/2", func(t *testing.T) {
+		body, cn, err := get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if body != "HTTP/2.0 anônimo" || cn != "servidor-1" {
+			t.Errorf("recebeu %q do certificado %q", body, cn)
+		}
+	})
+
+	t.Run("Teste reload do certificado", func(t *testing.T) {
+		newTestCert(t, "servidor-2", ca, false).write(t, dir)
+		if err := server.ReloadCertificates(); err != nil {
+			t.Fatal(err)
+		}
+		if _, cn, err := get(); err != nil || cn != "servidor-2" {
+			t.Errorf("esperava o certificado novo, recebeu %q %v", cn, err)
+		}
+
+		// um par inválido mantém o certificado atual
+		os.WriteFile(keyFile, []byte("lixo"), 0o600)
+		if err := server.ReloadCertificates(); err == nil {
+			t.Errorf("esperava erro com chave inválida")
+		}
+		if _, cn, err := get(); err != nil || cn != "servidor-2" {
+			t.Errorf("certificado atual deveria continuar, recebeu %q %v", cn, err)
+		}
+	})
+
+	t.Run("Teste reload pela data de modificação", func(t *testing.T) {
+		reloader, err := NewCertReloader(newTestCert(t, "watch-1", ca, false).write(t, t.TempDir()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go reloader.Watch(ctx, 5*time.Millisecond)
+
+		newTestCert(t, "watch-2", ca, false).write(t, filepath.Dir(reloader.certFile))
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(reloader.certFile, future, future)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			cert, _ := reloader.GetCertificate(nil)
+			if cert.Leaf != nil && cert.Leaf.Subject.CommonName == "watch-2" {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Errorf("certificado não foi recarregado pelo Watch")
+	})
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "CA de teste", nil, true)
+	otherCA := newTestCert(t, "outra CA", nil, true)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "servidor", ca, false).write(t, dir)
+	caFile := filepath.Join(dir, "ca.pem")
+	os.WriteFile(caFile, ca.certPEM, 0o600)
+
+	server := NewAPIServer(":8443", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/mtls/eu", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		return tc.SendString(tc.ClientCertificate().Subject.CommonName)
+	}}})
+	err := server.SetTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientCAFile: caFile, ClientAuth: "require_and_verify", MinVersion: "1.3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTest(t, server)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	get := func(client *testCert) (string, error) {
+		cfg := &tls.Config{RootCAs: roots}
+		if client != nil {
+			pair, _ := tls.X509KeyPair(client.certPEM, client.keyPEM)
+			cfg.Certificates = []tls.Certificate{pair}
+		}
+		transport := &http.Transport{TLSClientConfig: cfg}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + addr + "/mtls/eu")
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body), nil
+	}
+
+	t.Run("Teste certificado de cliente válido", func(t *testing.T) {
+		body, err := get(newTestCert(t, "cliente-42", ca, false))
+		if err != nil || body != "cliente-42" {
+			t.Errorf("recebeu %q %v", body, err)
+		}
+	})
+
+	t.Run("Teste sem certificado ou de outra CA", func(t *testing.T) {
+		if _, err := get(nil); err == nil {
+			t.Errorf("esperava falha sem certificado de cliente")
+		}
+		if _, err := get(newTestCert(t, "intruso", otherCA, false)); err == nil {
+			t.Errorf("esperava falha com certificado de outra CA")
+		}
+	})
+
+	t.Run("Teste CAs de cliente recarregadas", func(t *testing.T) {
+		os.WriteFile(caFile, otherCA.certPEM, 0o600)
+		defer os.WriteFile(caFile, ca.certPEM, 0o600)
+		if err := server.ReloadCertificates(); err != nil {
+			t.Fatal(err)
+		}
+		if body, err := get(newTestCert(t, "cliente-novo", otherCA, false)); err != nil || body != "cliente-novo" {
+			t.Errorf("CA nova deveria valer, recebeu %q %v", body, err)
+		}
+		if _, err := get(newTestCert(t, "cliente-42", ca, false)); err == nil {
+			t.Errorf("CA antiga não deveria valer mais")
+		}
+	})
+}
+
+func TestClientCertificateUnverified(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "servidor", nil, false).write(t, dir)
+
+	server := NewAPIServer(":8443", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/mtls/eu", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		if tc.ClientCertificate() != nil {
+			return tc.SendString("verificado")
+		}
+		return tc.SendString(tc.TLS().PeerCertificates[0].Subject.CommonName)
+	}}})
+	if err := server.SetTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile, ClientAuth: "require"}); err != nil {
+		t.Fatal(err)
+	}
+	addr := serveTest(t, server)
+
+	t.Run("Teste certificado sem verificação não é retornado", func(t *testing.T) {
+		client := newTestCert(t, "qualquer", nil, false)
+		pair, _ := tls.X509KeyPair(client.certPEM, client.keyPEM)
+		transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true, Certificates: []tls.Certificate{pair}}}
+		defer transport.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: transport}).Get("https://" + addr + "/mtls/eu")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if body, _ := io.ReadAll(resp.Body); string(body) != "qualquer" {
+			t.Errorf("recebeu %q", body)
+		}
+	})
+}
+
+func TestHTTPSRedirect(t *testing.T) {
+	for name, tc := range map[string]struct {
+		port, method, target, location string
+		status                         int
+	}{
+		"porta padrão": {"443", http.MethodGet, "http://example.com/a?b=1", "https://example.com/a?b=1", http.StatusMovedPermanently},
+		"outra porta":  {"8443", http.MethodGet, "http://example.com:8080/a", "https://example.com:8443/a", http.StatusMovedPermanently},
+		"POST":         {"", http.MethodPost, "http://example.com/form", "https://example.com/form", http.StatusPermanentRedirect},
+		"IPv6":         {"8443", http.MethodGet, "http://[::1]:8080/", "https://[::1]:8443/", http.StatusMovedPermanently},
+	} {
+		t.Run("Teste "+name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			HTTPSRedirect(tc.port).ServeHTTP(rr, httptest.NewRequest(tc.method, tc.target, nil))
+			if rr.Code != tc.status || rr.Header().Get("Location") != tc.location {
+				t.Errorf("recebeu %d %q", rr.Code, rr.Header().Get("Location"))
+			}
+		})
+	}
+
+	t.Run("Teste validação da config TLS", func(t *testing.T) {
+		for _, cfg := range []TLSConfig{
+			{CertFile: "c", KeyFile: "k", ClientAuth: "sempre"},
+			{CertFile: "c", KeyFile: "k", ClientAuth: "require_and_verify"},
+			{CertFile: "c", KeyFile: "k", MinVersion: "1.0"},
+			{HTTPRedirectAddr: ":80"},
+		} {
+			if err := cfg.validate(); err == nil {
+				t.Errorf("esperava erro para %+v", cfg)
+			}
+		}
+	})
+}
+
+func TestTLSWithStart(t *testing.T) {
+	ca := newTestCert(t, "CA de teste", nil, true)
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "start-1", ca, false).write(t, dir)
+
+	// porta livre para o redirect
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirectAddr := free.Addr().String()
+	free.Close()
+
+	server := NewAPIServer("127.0.0.1:0", func() {})
+	server.RegisterRoutes([]RouteInfo{{Path: "/tls/start", Method: MethodGet, Handler: func(tc *TupaContext) error {
+		return tc.SendString("ok")
+	}}})
+	err = server.SetTLS(TLSConfig{CertFile: certFile, KeyFile: keyFile, ReloadInterval: 5 * time.Millisecond, HTTPRedirectAddr: redirectAddr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	addr := server.Addrs()[0].String()
+	_, port, _ := net.SplitHostPort(addr)
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	t.Run("Teste redirect HTTP iniciado pelo Start", func(t *testing.T) {
+		resp, err := client.Get("http://" + redirectAddr + "/tls/start")
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if want := "https://127.0.0.1:" + port + "/tls/start"; resp.Header.Get("Location") != want {
+			t.Errorf("recebeu %q, queria %q", resp.Header.Get("Location"), want)
+		}
+	})
+
+	t.Run("Teste Watch do certificado iniciado pelo Start", func(t *testing.T) {
+		newTestCert(t, "start-2", ca, false).write(t, dir)
+		future := time.Now().Add(time.Minute)
+		os.Chtimes(certFile, future, future)
+
+		deadline := time.Now().Add(2 * time.Second)
+		for time.Now().Before(deadline) {
+			cert, _ := server.certReloader.GetCertificate(nil)
+			if cert.Leaf != nil && cert.Leaf.Subject.CommonName == "start-2" {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Errorf("certificado não foi recarregado")
+	})
+
+	t.Run("Teste Shutdown para o redirect", func(t *testing.T) {
+		server.Shutdown()
+		if _, err := client.Get("http://" + redirectAddr + "/tls/start"); err == nil {
+			t.Errorf("redirect continua aceitando conexões")
+		}
+	})
+}
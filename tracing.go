@@ -0,0 +1,440 @@
+package tupa
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TraceID e SpanID seguem o W3C Trace Context, o mesmo formato do OpenTelemetry
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// SpanContext é a parte do span que atravessa processos pelo header traceparent
+type SpanContext struct {
+	TraceID    TraceID
+	SpanID     SpanID
+	Sampled    bool
+	TraceState string
+	// Remote indica que o contexto veio de outro processo
+	Remote bool
+}
+
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+type SpanKind string
+
+const (
+	SpanKindServer   SpanKind = "server"
+	SpanKindInternal SpanKind = "internal"
+	SpanKindClient   SpanKind = "client"
+)
+
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = iota
+	StatusOK
+	StatusError
+)
+
+type SpanStatus struct {
+	Code    StatusCode
+	Message string
+}
+
+type SpanEvent struct {
+	Name       string
+	Time       time.Time
+	Attributes map[string]any
+}
+
+// SpanData é o span finalizado, entregue ao SpanExporter
+type SpanData struct {
+	Name         string
+	ServiceName  string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	Start        time.Time
+	End          time.Time
+	Attributes   map[string]any
+	Events       []SpanEvent
+	Status       SpanStatus
+}
+
+// SpanExporter recebe os spans quando terminam. Para mandar para um coletor OpenTelemetry basta
+// converter SpanData para o modelo do SDK dentro de ExportSpans
+type SpanExporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+}
+
+// InMemoryExporter guarda os spans em memória, feito para testes
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+func (e *InMemoryExporter) ExportSpans(_ context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	e.spans = append(e.spans, spans...)
+	e.mu.Unlock()
+	return nil
+}
+
+// Spans retorna uma cópia dos spans exportados, na ordem em que terminaram
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	e.spans = nil
+	e.mu.Unlock()
+}
+
+type tracer struct {
+	exporter    SpanExporter
+	serviceName string
+}
+
+// Span é um trecho do trace em andamento. Todos os métodos aceitam um Span nulo e não fazem nada,
+// então o código do handler não precisa saber se o tracing está ligado
+type Span struct {
+	tracer *tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+// IsRecording é false para spans não amostrados, que não são exportados
+func (s *Span) IsRecording() bool {
+	return s != nil && s.data.SpanContext.Sampled
+}
+
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Name = name
+	s.mu.Unlock()
+}
+
+func (s *Span) SetAttribute(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+func (s *Span) AddEvent(name string, attributes map[string]any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Events = append(s.data.Events, SpanEvent{Name: name, Time: time.Now(), Attributes: attributes})
+	s.mu.Unlock()
+}
+
+func (s *Span) SetStatus(code StatusCode, message string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.data.Status = SpanStatus{Code: code, Message: message}
+	s.mu.Unlock()
+}
+
+// RecordError adiciona o evento "exception" com o tipo e a mensagem do erro, como o OpenTelemetry.
+// O status do span não muda, quem chama decide se o erro é uma falha
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.AddEvent("exception", map[string]any{
+		"exception.type":    fmt.Sprintf("%T", err),
+		"exception.message": err.Error(),
+	})
+}
+
+// End finaliza o span e o envia ao exporter. Chamadas depois da primeira são ignoradas
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+
+	if data.SpanContext.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.ExportSpans(context.Background(), []SpanData{data})
+	}
+}
+
+func (t *tracer) start(name string, kind SpanKind, parent SpanContext) *Span {
+	sc := SpanContext{SpanID: newSpanID(), Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+		sc.TraceState = parent.TraceState
+	} else {
+		sc.TraceID = newTraceID()
+	}
+
+	return &Span{tracer: t, data: SpanData{
+		Name:         name,
+		ServiceName:  t.serviceName,
+		Kind:         kind,
+		SpanContext:  sc,
+		ParentSpanID: parent.SpanID,
+		Start:        time.Now(),
+		Attributes:   map[string]any{},
+	}}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+// SpanFromContext retorna o span guardado no context, ou nil
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+// StartSpan cria um span filho do span em ctx, e.g. em volta de uma query:
+//
+//	ctx, span := tupa.StartSpan(tc.Ctx, "db.users.find")
+//	defer span.End()
+//
+// Sem span em ctx ( tracing desligado ) retorna ctx e um Span nulo
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	span := parent.tracer.start(name, SpanKindInternal, parent.SpanContext())
+	return context.WithValue(ctx, spanKey, span), span
+}
+
+// Span retorna o span da request criado pelo middleware Tracing
+func (tc *TupaContext) Span() *Span {
+	span, _ := tc.value(spanKey).(*Span)
+	return span
+}
+
+// InjectTraceContext escreve traceparent e tracestate do span em ctx no header, para propagar o
+// trace em chamadas a outros serviços
+func InjectTraceContext(ctx context.Context, header http.Header) {
+	sc := SpanFromContext(ctx).SpanContext()
+	if !sc.IsValid() {
+		return
+	}
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	header.Set("traceparent", "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+	if sc.TraceState != "" {
+		header.Set("tracestate", sc.TraceState)
+	}
+}
+
+// ExtractTraceContext lê traceparent e tracestate. Um traceparent inválido é ignorado e o
+// tracestate só vale junto com um traceparent válido, como pede a especificação
+func ExtractTraceContext(header http.Header) (SpanContext, bool) {
+	sc, err := parseTraceparent(header.Get("traceparent"))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	var states []string
+	for _, v := range header.Values("tracestate") {
+		if v = strings.TrimSpace(v); v != "" {
+			states = append(states, v)
+		}
+	}
+	sc.TraceState = strings.Join(states, ",")
+	sc.Remote = true
+	return sc, true
+}
+
+var errInvalidTraceparent = errors.New("traceparent inválido")
+
+func parseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	version, err := strconv.ParseUint(parts[0], 16, 8)
+	// a versão ff é proibida e a versão 00 não tem campos extras. Versões futuras podem ter
+	if err != nil || version == 0xff || (version == 0 && len(parts) != 4) || parts[0] != strings.ToLower(parts[0]) {
+		return SpanContext{}, errInvalidTraceparent
+	}
+
+	var sc SpanContext
+	if _, err := hex.Decode(sc.TraceID[:], []byte(parts[1])); err != nil || parts[1] != strings.ToLower(parts[1]) {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(parts[2])); err != nil || parts[2] != strings.ToLower(parts[2]) {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil || !sc.IsValid() {
+		return SpanContext{}, errInvalidTraceparent
+	}
+	sc.Sampled = flags&0x01 == 1
+	return sc, nil
+}
+
+type TracingConfig struct {
+	// Exporter recebe os spans. Nulo cria spans sem exportar, o que ainda propaga o trace
+	Exporter    SpanExporter
+	ServiceName string
+	// Sampler decide se um trace novo é gravado. Requests com traceparent seguem a decisão de quem
+	// chamou. Nulo grava todos
+	Sampler func(TraceID) bool
+	// Middlewares controla os spans filhos para cada middleware. Padrão true
+	Middlewares *bool
+}
+
+// Tracing cria um span por request chamado "<método> <pattern da rota>", continuando o trace do
+// header traceparent quando houver. O span fica em tc.Ctx ( tc.Span() e StartSpan(tc.Ctx, ...) ),
+// cada middleware registrado depois dele ganha um span filho e erros do handler viram o evento
+// "exception", com status de erro para respostas 5xx. Use como middleware global, o primeiro da lista
+func Tracing(cfg ...TracingConfig) MiddlewareFunc {
+	config := TracingConfig{}
+	if len(cfg) > 0 {
+		config = cfg[0]
+	}
+	t := &tracer{exporter: config.Exporter, serviceName: config.ServiceName}
+	traceMiddlewares := config.Middlewares == nil || *config.Middlewares
+
+	return func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			route := tc.RoutePattern()
+			name := tc.Req.Method
+			if route != "" {
+				name += " " + route
+			}
+
+			parent, _ := ExtractTraceContext(tc.Req.Header)
+			span := t.start(name, SpanKindServer, parent)
+			if !parent.IsValid() && config.Sampler != nil {
+				span.data.SpanContext.Sampled = config.Sampler(span.data.SpanContext.TraceID)
+			}
+
+			span.SetAttribute("http.request.method", tc.Req.Method)
+			span.SetAttribute("url.path", tc.Req.URL.Path)
+			if route != "" {
+				span.SetAttribute("http.route", route)
+			}
+			if ua := tc.Req.UserAgent(); ua != "" {
+				span.SetAttribute("user_agent.original", ua)
+			}
+			span.SetAttribute("server.address", tc.Host())
+			span.SetAttribute("url.scheme", tc.Scheme())
+			span.SetAttribute("client.address", tc.RealIP())
+
+			tc.setValue(spanKey, span)
+			if traceMiddlewares {
+				tc.setValue(traceMiddlewaresKey, true)
+			}
+
+			sw := &statusResponseWriter{ResponseWriter: tc.Resp}
+			tc.Resp = sw
+			tc.onFinish(func() {
+				status := sw.status
+				if status == 0 {
+					status = http.StatusOK
+				}
+				span.SetAttribute("http.response.status_code", status)
+
+				if tc.err != nil {
+					span.RecordError(tc.err)
+					if apiErr, ok := tc.err.(APIHandlerErr); ok {
+						span.SetAttribute("error.type", strconv.Itoa(apiErr.Status))
+					} else {
+						span.SetAttribute("error.type", fmt.Sprintf("%T", tc.err))
+					}
+				}
+				// para spans de servidor só 5xx é falha, 4xx é erro de quem chamou
+				if status >= 500 {
+					msg := http.StatusText(status)
+					if tc.err != nil {
+						msg = tc.err.Error()
+					}
+					span.SetStatus(StatusError, msg)
+				}
+				span.End()
+			})
+
+			return next(tc)
+		}
+	}
+}
+
+// startMiddlewareSpan cria o span filho de um middleware quando a request está sendo rastreada
+func startMiddlewareSpan(tc *TupaContext, middleware MiddlewareFunc) *Span {
+	if on, _ := tc.value(traceMiddlewaresKey).(bool); !on {
+		return nil
+	}
+	parent := tc.Span()
+	if parent == nil {
+		return nil
+	}
+	return parent.tracer.start("middleware "+funcName(middleware), SpanKindInternal, parent.SpanContext())
+}
@@ -0,0 +1,178 @@
+package tupa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	server := NewAPIServer(":8080", nil)
+	server.UseGlobalMiddlewares(Tracing(TracingConfig{Exporter: exporter, ServiceName: "api"}))
+
+	auth := func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			if tc.Req.Header.Get("Authorization") == "" {
+				return APIHandlerErr{Status: http.StatusUnauthorized, Msg: "sem token"}
+			}
+			return next(tc)
+		}
+	}
+	server.RegisterRoutes([]RouteInfo{
+		{Path: "/tracing/users/{id}", Method: MethodGet, Middlewares: []MiddlewareFunc{auth}, Handler: func(tc *TupaContext) error {
+			_, span := StartSpan(tc.Ctx, "db.users.find")
+			span.SetAttribute("db.user_id", tc.Param("id"))
+			span.End()
+
+			header := http.Header{}
+			InjectTraceContext(tc.Ctx, header)
+			return tc.SendString(header.Get("traceparent"))
+		}},
+		{Path: "/tracing/falha", Method: MethodGet, Handler: func(tc *TupaContext) error {
+			return APIHandlerErr{Status: http.StatusBadGateway, Msg: "upstream fora"}
+		}},
+	})
+
+	do := func(path string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Teste span da request com filhos de middleware e do handler", func(t *testing.T) {
+		exporter.Reset()
+		rr := do("/tracing/users/7", map[string]string{"Authorization": "Bearer x"})
+
+		spans := exporter.Spans()
+		if len(spans) != 3 {
+			t.Fatalf("esperava 3 spans, recebeu %d: %+v", len(spans), spans)
+		}
+		mwSpan, dbSpan, reqSpan := spans[0], spans[1], spans[2]
+
+		if reqSpan.Name != "GET /tracing/users/{id}" || reqSpan.Kind != SpanKindServer || reqSpan.ServiceName != "api" {
+			t.Errorf("span da request inesperado: %+v", reqSpan)
+		}
+		if reqSpan.ParentSpanID.IsValid() {
+			t.Errorf("span sem traceparent não deveria ter pai")
+		}
+		if reqSpan.Attributes["http.route"] != "/tracing/users/{id}" || reqSpan.Attributes["http.response.status_code"] != 200 {
+			t.Errorf("atributos inesperados: %v", reqSpan.Attributes)
+		}
+		if mwSpan.Name != "middleware tupa.TestTracing" || mwSpan.ParentSpanID != reqSpan.SpanContext.SpanID {
+			t.Errorf("span do middleware inesperado: %+v", mwSpan)
+		}
+		if dbSpan.Name != "db.users.find" || dbSpan.ParentSpanID != reqSpan.SpanContext.SpanID || dbSpan.Attributes["db.user_id"] != "7" {
+			t.Errorf("span do handler inesperado: %+v", dbSpan)
+		}
+		for _, s := range spans {
+			if s.SpanContext.TraceID != reqSpan.SpanContext.TraceID {
+				t.Errorf("span %s em outro trace", s.Name)
+			}
+		}
+
+		want := "00-" + reqSpan.SpanContext.TraceID.String() + "-" + reqSpan.SpanContext.SpanID.String() + "-01"
+		if rr.Body.String() != want {
+			t.Errorf("traceparent injetado %q, queria %q", rr.Body.String(), want)
+		}
+	})
+
+	t.Run("Teste continua o trace do traceparent", func(t *testing.T) {
+		exporter.Reset()
+		do("/tracing/users/7", map[string]string{
+			"Authorization": "Bearer x",
+			"traceparent":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			"tracestate":    "congo=t61rcWkgMzE",
+		})
+
+		spans := exporter.Spans()
+		reqSpan := spans[len(spans)-1]
+		if reqSpan.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" ||
+			reqSpan.ParentSpanID.String() != "00f067aa0ba902b7" ||
+			reqSpan.SpanContext.TraceState != "congo=t61rcWkgMzE" {
+			t.Errorf("trace não continuou: %+v", reqSpan)
+		}
+	})
+
+	t.Run("Teste traceparent não amostrado não exporta", func(t *testing.T) {
+		exporter.Reset()
+		rr := do("/tracing/users/7", map[string]string{
+			"Authorization": "Bearer x",
+			"traceparent":   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		})
+		if n := len(exporter.Spans()); n != 0 {
+			t.Errorf("esperava nenhum span exportado, recebeu %d", n)
+		}
+		// o trace continua sendo propagado
+		if !strings.HasPrefix(rr.Body.String(), "00-4bf92f3577b34da6a3ce929d0e0e4736-") || !strings.HasSuffix(rr.Body.String(), "-00") {
+			t.Errorf("traceparent injetado %q", rr.Body.String())
+		}
+	})
+
+	t.Run("Teste erro de middleware e de handler", func(t *testing.T) {
+		exporter.Reset()
+		do("/tracing/users/7", nil)
+
+		spans := exporter.Spans()
+		mwSpan, reqSpan := spans[0], spans[len(spans)-1]
+		if mwSpan.Status.Code != StatusError || len(mwSpan.Events) != 1 || mwSpan.Events[0].Name != "exception" {
+			t.Errorf("span do middleware deveria ter o erro: %+v", mwSpan)
+		}
+		// 4xx não é falha do servidor
+		if reqSpan.Status.Code != StatusUnset || reqSpan.Attributes["error.type"] != "401" {
+			t.Errorf("span da request com 401 inesperado: %+v", reqSpan)
+		}
+
+		exporter.Reset()
+		do("/tracing/falha", nil)
+		reqSpan = exporter.Spans()[0]
+		if reqSpan.Status.Code != StatusError || reqSpan.Status.Message != "upstream fora" ||
+			reqSpan.Attributes["http.response.status_code"] != http.StatusBadGateway {
+			t.Errorf("span da request com 502 inesperado: %+v", reqSpan)
+		}
+		if reqSpan.Events[0].Attributes["exception.message"] != "upstream fora" {
+			t.Errorf("evento exception inesperado: %+v", reqSpan.Events)
+		}
+	})
+}
+
+func TestExtractTraceContext(t *testing.T) {
+	for _, tp := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+	} {
+		t.Run("Teste traceparent inválido "+tp, func(t *testing.T) {
+			header := http.Header{"Traceparent": {tp}, "Tracestate": {"a=b"}}
+			if sc, ok := ExtractTraceContext(header); ok {
+				t.Errorf("esperava inválido, recebeu %+v", sc)
+			}
+		})
+	}
+
+	t.Run("Teste versão futura com campos extras", func(t *testing.T) {
+		header := http.Header{"Traceparent": {"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-03-extra"}}
+		sc, ok := ExtractTraceContext(header)
+		if !ok || !sc.Sampled || !sc.Remote {
+			t.Errorf("esperava contexto válido e amostrado, recebeu %+v", sc)
+		}
+	})
+
+	t.Run("Teste span nulo sem tracing", func(t *testing.T) {
+		ctx, span := StartSpan(httptest.NewRequest(http.MethodGet, "/", nil).Context(), "nada")
+		span.SetAttribute("a", 1)
+		span.End()
+		if span != nil || SpanFromContext(ctx) != nil {
+			t.Errorf("esperava span nulo")
+		}
+	})
+}
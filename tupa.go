@@ -2,6 +2,7 @@ package tupa
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,10 +11,13 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/rs/cors"
+	"github.com/tupatech/tupa/internal/hooks"
 )
 
 type (
@@ -38,6 +42,15 @@ type (
 		Req  *http.Request
 		Resp http.ResponseWriter
 		Ctx  context.Context
+
+		api       *APIServer
+		finishers []func()
+		// pattern da rota que atendeu a request, e.g. "/users/{id}"
+		routePattern string
+		// primeiro erro devolvido por um middleware ou pelo handler, para quem roda no onFinish ( e.g. Tracing )
+		err error
+		// hijacked indica que a conexão foi sequestrada ( WebSocket ) e não aceita mais uma resposta HTTP
+		hijacked bool
 	}
 )
 
@@ -52,6 +65,33 @@ type APIServer struct {
 	globalAfterMiddlewares MiddlewareChain
 	router                 *Router
 	routeManager           RouteManager
+	bodyLimit              int64
+	renderer               Renderer
+	routes                 []RouteInfo
+	namedRoutes            map[string]RouteInfo
+	versioning             VersioningConfig
+	versionedRoutes        map[string]*versionedRoute
+	handler                http.Handler
+	handlerOnce            sync.Once
+	draining               atomic.Bool
+	shutdownDelay          time.Duration
+	// config é a Config usada em NewAPIServerFromConfig, nula para NewAPIServer
+	config         *Config
+	logger         *slog.Logger
+	tls            *TLSConfig
+	tlsConfig      *tls.Config
+	certReloader   *CertReloader
+	h2c            bool
+	redirectServer *http.Server
+	// stopWatch para o CertReloader.Watch iniciado em Start
+	stopWatch      context.CancelFunc
+	listenerSpecs  []listenerSpec
+	listeners      []boundListener
+	trustedProxies trustedProxies
+	restartMu      sync.Mutex
+	shutdownOnce   sync.Once
+	// stopped é fechado no fim do Shutdown, inclusive quando ele vem de Restart
+	stopped chan struct{}
 }
 
 const (
@@ -76,72 +116,146 @@ var allRoutes []RouteInfo
 
 func (a *APIServer) New() {
 	if a.routeManager == nil {
-		defaultRouteManager()
-	} else {
-		a.routeManager()
+		a.routeManager = defaultRouteManager
+	}
+	if err := a.Start(); err != nil {
+		log.Fatal(FmtRed("Erro ao iniciar servidor: "), err)
 	}
 
-	a.RegisterRoutes(GetRoutes())
-
-	c := cors.New(cors.Options{
-		AllowedHeaders: []string{
-			"Authorization", "authorization", "Accept", "Content-Type", "X-Requested-With", "X-Frame-Options",
-			"X-XSS-Protection", "X-Content-Type-Options", "X-Permitted-Cross-Domain-Policies", "Referrer-Policy", "Expect-CT",
-			"Feature-Policy", "Content-Security-Policy", "Content-Security-Policy-Report-Only", "Strict-Transport-Security",
-			"Public-Key-Pins", "Public-Key-Pins-Report-Only", "Access-Control-Allow-Origin", "Access-Control-Allow-Methods",
-			"Access-Control-Allow-Headers", "Access-Control-Allow-Credentials", "X-Forwarded-For", "X-Real-IP",
-			"X-Csrf-Token", "X-HTTP-Method-Override",
-		},
-		AllowCredentials: true,
-	})
-	serverHandler := c.Handler(a.router)
-
-	a.server = &http.Server{
-		Addr:    a.listenAddr,
-		Handler: serverHandler,
+	signals := append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, restartSignals...)
+	if a.certReloader != nil {
+		// sem TLS o SIGHUP mantém o comportamento padrão ( encerra o processo )
+		signals = append(signals, syscall.SIGHUP)
+	}
+	signchan := make(chan os.Signal, 1)
+	signal.Notify(signchan, signals...)
+	// vai esperar um comando que encerra o servidor. SIGHUP só recarrega os certificados e SIGUSR2
+	// troca o processo por um novo ( Restart ), que já termina com o Shutdown deste
+wait:
+	for {
+		select {
+		case <-a.stopped:
+			// Restart chamado pela aplicação
+			break wait
+		case sig := <-signchan:
+			switch {
+			case sig == syscall.SIGHUP:
+				if err := a.ReloadCertificates(); err != nil {
+					slog.Error("TLS", "err:", err)
+				} else {
+					log.Println(FmtYellow("Certificado TLS recarregado"))
+				}
+			case isRestartSignal(sig):
+				if err := a.Restart(); err != nil {
+					slog.Error("Restart", "err:", err)
+				}
+			default:
+				break wait
+			}
+		}
 	}
 
-	fmt.Println(FmtBlue("Servidor iniciado na porta: " + a.listenAddr))
+	a.Shutdown()
 
-	go func() {
-		if err := a.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			log.Fatal(FmtRed("Erro ao iniciar servidor: "), err)
+	fmt.Println(FmtYellow("Servidor encerrado na porta: " + a.listenAddr))
+}
+
+// Handler registra as rotas globais ( AddRoutes e o routeManager ) e retorna o http.Handler do
+// servidor, o mesmo que New() coloca no http.Server. Serve para testes ( veja o pacote tupatest ) e
+// para quem quer controlar o próprio http.Server. As rotas só são registradas na primeira chamada
+func (a *APIServer) Handler() http.Handler {
+	a.handlerOnce.Do(func() {
+		if a.routeManager != nil {
+			a.routeManager()
 		}
-		log.Println(FmtYellow("Servidor parou de receber novas conexões"))
-	}()
 
-	signchan := make(chan os.Signal, 1)
-	signal.Notify(signchan, syscall.SIGINT, syscall.SIGTERM)
-	<-signchan // vai esperar um comando que encerra o servidor
+		var pending []RouteInfo
+		for _, route := range GetRoutes() {
+			// outro APIServer do mesmo processo ( comum em testes ) pode ter rodado o mesmo
+			// routeManager, que adiciona as rotas de novo na lista global
+			if !a.hasRoute(route) && !containsRoute(pending, route) {
+				pending = append(pending, route)
+			}
+		}
+		a.RegisterRoutes(pending)
 
-	ctx, shutdownRelease := context.WithTimeout(context.Background(), 10*time.Second)
-	defer shutdownRelease()
+		c := cors.New(a.corsOptions())
+		a.handler = c.Handler(a.router)
+	})
+	return a.handler
+}
+
+func sameRoute(a, b RouteInfo) bool {
+	return a.Host == b.Host && a.Method == b.Method && a.Path == b.Path && a.Version == b.Version
+}
 
-	if err := a.server.Shutdown(ctx); err != nil {
-		log.Fatal(FmtRed("Erro ao desligar servidor: "), err)
+func containsRoute(routes []RouteInfo, route RouteInfo) bool {
+	for _, r := range routes {
+		if sameRoute(r, route) {
+			return true
+		}
 	}
+	return false
+}
 
-	fmt.Println(FmtYellow("Servidor encerrado na porta: " + a.listenAddr))
+func (a *APIServer) hasRoute(route RouteInfo) bool {
+	if route.Version != "" {
+		// rotas com versão ficam registradas com o prefixo /v{n}
+		route.Version = normalizeVersion(route.Version)
+		route.Path = "/v" + route.Version + route.Path
+		if route.Path == "/v"+route.Version+"/" {
+			route.Path = "/v" + route.Version
+		}
+	}
+	return containsRoute(a.routes, route)
 }
 
+// Shutdown marca o servidor como draining ( /readyz passa a falhar ), espera o SetShutdownDelay e
+// então para de aceitar conexões, esperando as requests em andamento por até 10 segundos
+// ( Config.ShutdownTimeout )
 func (a *APIServer) Shutdown() {
+	// pode ser chamado de novo por New() depois de um Restart
+	a.shutdownOnce.Do(a.shutdown)
+}
+
+func (a *APIServer) shutdown() {
+	a.draining.Store(true)
+	if a.stopWatch != nil {
+		a.stopWatch()
+	}
 	if a.server != nil {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		time.Sleep(a.shutdownDelay)
+
+		timeout := 10 * time.Second
+		if a.config != nil && a.config.ShutdownTimeout > 0 {
+			timeout = a.config.ShutdownTimeout
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
 		defer cancel()
+		if a.redirectServer != nil {
+			a.redirectServer.Shutdown(ctx)
+		}
 		if err := a.server.Shutdown(ctx); err != nil {
 			log.Fatal(FmtRed("Erro ao desligar servidor: "), err)
 		}
 	}
+	if a.stopped != nil {
+		close(a.stopped)
+	}
 }
 
 func NewAPIServer(listenAddr string, routeManager RouteManager) *APIServer {
-	return &APIServer{
+	a := &APIServer{
 		listenAddr:             listenAddr,
 		globalMiddlewares:      MiddlewareChain{},
 		globalAfterMiddlewares: MiddlewareChain{},
 		router:                 NewRouter(), // não está recebendo nenhum middleware por enquanto
 		routeManager:           routeManager,
+		stopped:                make(chan struct{}),
 	}
+	a.SetNotFoundHandler(defaultNotFoundHandler)
+	a.SetMethodNotAllowedHandler(defaultMethodNotAllowedHandler)
+	return a
 }
 
 func defaultRouteManager() {
@@ -162,13 +276,31 @@ func defaultRouteManager() {
 func (a *APIServer) RegisterRoutes(routeInfos []RouteInfo) {
 	for _, routeInfo := range routeInfos {
 		if !AllowedMethods[routeInfo.Method] {
-			log.Fatalf(fmt.Sprintf(FmtRed("Método HTTP não permitido: "), "%s\nVeja como criar um novo método na documentação", routeInfo.Method))
+			log.Fatalf("%s%s\nVeja como criar um novo método na documentação", FmtRed("Método HTTP não permitido: "), routeInfo.Method)
+		}
+
+		if routeInfo.Version != "" {
+			a.registerVersionedRoute(routeInfo)
+			continue
 		}
+		a.registerRoute(routeInfo, a.MakeHTTPHandlerFuncHelper(routeInfo))
+	}
+}
 
-		handler := a.MakeHTTPHandlerFuncHelper(routeInfo)
-		// a.router.HandleFunc(routeInfo.Path, handler).Methods(string(routeInfo.Method))
-		a.router.Handle(string(routeInfo.Method), routeInfo.Path, handler)
+func (a *APIServer) registerRoute(routeInfo RouteInfo, handler http.HandlerFunc) {
+	if routeInfo.Name != "" {
+		if _, exists := a.namedRoutes[routeInfo.Name]; exists {
+			log.Fatalf("%s%s", FmtRed("Nome de rota repetido: "), routeInfo.Name)
+		}
+		if a.namedRoutes == nil {
+			a.namedRoutes = map[string]RouteInfo{}
+		}
+		a.namedRoutes[routeInfo.Name] = routeInfo
 	}
+
+	a.routes = append(a.routes, routeInfo)
+	// a.router.HandleFunc(routeInfo.Path, handler).Methods(string(routeInfo.Method))
+	a.router.HandleHost(routeInfo.Host, string(routeInfo.Method), routeInfo.Path, handler)
 }
 
 func WriteJSONHelper(w http.ResponseWriter, status int, v any) error {
@@ -185,44 +317,57 @@ func WriteJSONHelper(w http.ResponseWriter, status int, v any) error {
 
 func (a *APIServer) MakeHTTPHandlerFuncHelper(routeInfo RouteInfo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		var bodyErr error
+		if limit := a.effectiveBodyLimit(routeInfo); limit > 0 && r.Body != nil && r.Body != http.NoBody {
+			if r.ContentLength > limit {
+				bodyErr = &http.MaxBytesError{Limit: limit}
+			} else {
+				r.Body = http.MaxBytesReader(w, r.Body, limit)
+			}
+		}
+
 		ctx := &TupaContext{
 			Req:  r,
 			Resp: w,
 			Ctx:  r.Context(),
+			api:  a,
+
+			routePattern: routeInfo.Host + routeInfo.Path,
+		}
+		// roda o que os middlewares deixaram para depois do handler ( fechar writers, métricas, etc )
+		defer ctx.finish()
+
+		if routeInfo.Timeout > 0 {
+			ctx.withTimeout(routeInfo.Timeout)
 		}
 
 		// Combina middlewares globais com os especificos de rota
 		allMiddlewares := MiddlewareChain{}
 		allMiddlewares = append(allMiddlewares, a.globalMiddlewares...)
-		allMiddlewares = append(allMiddlewares, routeInfo.Middlewares...)
+		if bodyErr != nil {
+			// o 413 sai depois dos middlewares globais ( SecureHeaders, métricas, tracing ) pelo caminho
+			// normal de erro, sem rodar os middlewares da rota nem o handler
+			allMiddlewares = append(allMiddlewares, func(APIFunc) APIFunc {
+				return func(*TupaContext) error { return bodyErr }
+			})
+		} else {
+			allMiddlewares = append(allMiddlewares, routeInfo.Middlewares...)
+		}
 
 		doneCh := a.executeMiddlewaresAsync(ctx, allMiddlewares)
 		errorsSlice := <-doneCh // espera até que algum valor seja recebido. Continua no primeiro erro recebido ( se houver ) ou se não houver nenhum erro
 
 		if len(errorsSlice) > 0 {
-			err := errorsSlice[0]
-			if apiErr, ok := err.(APIHandlerErr); ok {
-				slog.Error("API Error", "err:", apiErr, "status:", apiErr.Status)
-				WriteJSONHelper(w, apiErr.Status, APIError{Error: apiErr.Error()})
-			} else {
-				slog.Error("API Error", "err:", apiErr, "status:", apiErr.Status)
-				WriteJSONHelper(w, http.StatusInternalServerError, APIError{Error: err.Error()})
-			}
+			ctx.writeError(errorsSlice[0])
 			return
 		}
 
-		if r.Method == string(routeInfo.Method) {
-			err := routeInfo.Handler(ctx)
-			if err != nil {
-				if apiErr, ok := err.(APIHandlerErr); ok {
-					slog.Error("API Error", "err:", apiErr, "status:", apiErr.Status)
-					WriteJSONHelper(w, apiErr.Status, APIError{Error: apiErr.Error()})
-				} else {
-					WriteJSONHelper(w, http.StatusInternalServerError, APIError{Error: err.Error()})
-				}
+		if methodMatches(string(routeInfo.Method), r.Method) {
+			if err := runHandler(ctx, routeInfo.Handler); err != nil {
+				ctx.writeError(err)
 			}
 		} else {
-			WriteJSONHelper(w, http.StatusMethodNotAllowed, APIError{Error: "Método HTTP não permitido"})
+			WriteJSONHelper(ctx.Resp, http.StatusMethodNotAllowed, APIError{Error: "Método HTTP não permitido"})
 		}
 
 		allAfterMiddlewares := MiddlewareChain{}
@@ -233,19 +378,57 @@ func (a *APIServer) MakeHTTPHandlerFuncHelper(routeInfo RouteInfo) http.HandlerF
 		errorsSlice = <-doneCh
 
 		if len(errorsSlice) > 0 {
-			err := errorsSlice[0]
-			if apiErr, ok := err.(APIHandlerErr); ok {
-				slog.Error("API Error", "err:", apiErr, "status:", apiErr.Status)
-				WriteJSONHelper(w, apiErr.Status, APIError{Error: apiErr.Error()})
-			} else {
-				slog.Error("API Error", "err:", apiErr, "status:", apiErr.Status)
-				WriteJSONHelper(w, http.StatusInternalServerError, APIError{Error: err.Error()})
-			}
+			ctx.writeError(errorsSlice[0])
 			return
 		}
 	}
 }
 
+// writeError guarda o erro e responde com ele, ou só registra no log se a conexão já foi sequestrada
+func (tc *TupaContext) writeError(err error) {
+	tc.err = err
+	if tc.hijacked {
+		slog.Error("API Error", "err:", err, "ip:", tc.RealIP())
+		return
+	}
+	writeAPIError(tc.Resp, err, "ip:", tc.RealIP())
+}
+
+// Hijacked diz se a conexão foi sequestrada, e.g. por UpgradeWebSocket. Depois disso escritas em
+// tc.Resp são descartadas
+func (tc *TupaContext) Hijacked() bool {
+	return tc.hijacked
+}
+
+// writeAPIError escreve o erro no formato JSON padrão do Tupã. APIHandlerErr mantém o status escolhido
+// pelo handler, qualquer outro erro vira 500. logAttrs vão junto no log, e.g. o IP do cliente
+func writeAPIError(w http.ResponseWriter, err error, logAttrs ...any) {
+	// corpo maior que o BodyLimit, mesmo que o handler tenha embrulhado o erro
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		err = APIHandlerErr{
+			Status: http.StatusRequestEntityTooLarge,
+			Msg:    fmt.Sprintf("Corpo da requisição maior que o limite de %d bytes", maxBytesErr.Limit),
+		}
+	}
+
+	// uma dependência do handler não respondeu dentro do prazo do context
+	if _, ok := err.(APIHandlerErr); !ok && errors.Is(err, context.DeadlineExceeded) {
+		err = APIHandlerErr{
+			Status: http.StatusGatewayTimeout,
+			Msg:    "Tempo limite excedido: " + err.Error(),
+		}
+	}
+
+	if apiErr, ok := err.(APIHandlerErr); ok {
+		slog.Error("API Error", append([]any{"err:", apiErr, "status:", apiErr.Status}, logAttrs...)...)
+		WriteJSONHelper(w, apiErr.Status, APIError{Error: apiErr.Error()})
+		return
+	}
+	slog.Error("API Error", append([]any{"err:", err, "status:", http.StatusInternalServerError}, logAttrs...)...)
+	WriteJSONHelper(w, http.StatusInternalServerError, APIError{Error: err.Error()})
+}
+
 func AddRoutes(groupMiddlewares MiddlewareChain, routeFuncs ...func() []RouteInfo) {
 	for _, routeFunc := range routeFuncs {
 		routes := routeFunc()
@@ -300,6 +483,12 @@ func (tc *TupaContext) Params() map[string]string {
 	return Vars(tc.Request())
 }
 
+// RoutePattern retorna o pattern da rota que atendeu a request ( e.g. "/users/{id}" ), com o host
+// na frente para rotas com RouteInfo.Host. Vazio quando nenhuma rota casou
+func (tc *TupaContext) RoutePattern() string {
+	return tc.routePattern
+}
+
 func (tc *TupaContext) GetCtx() context.Context {
 	return tc.Ctx
 }
@@ -329,6 +518,58 @@ func (tc *TupaContext) CtxValue(key interface{}) interface{} {
 	return tc.Ctx.Value(key)
 }
 
+// setValue guarda o valor tanto em tc.Ctx quanto no context da request, para que middlewares
+// e handlers enxerguem o mesmo valor independente de qual dos dois estejam usando
+func (tc *TupaContext) setValue(key, value interface{}) {
+	if tc.Ctx == nil {
+		tc.Ctx = tc.Req.Context()
+	}
+	tc.Ctx = context.WithValue(tc.Ctx, key, value)
+	tc.Req = tc.Req.WithContext(context.WithValue(tc.Req.Context(), key, value))
+}
+
+// onFinish agenda uma função para rodar depois do handler e dos after middlewares.
+// Como os middlewares do Tupã rodam antes do handler, é por aqui que eles conseguem
+// fazer algo quando a request termina. Roda em ordem LIFO, igual defer
+func (tc *TupaContext) onFinish(fn func()) {
+	tc.finishers = append(tc.finishers, fn)
+}
+
+func (tc *TupaContext) finish() {
+	for i := len(tc.finishers) - 1; i >= 0; i-- {
+		tc.finishers[i]()
+	}
+	tc.finishers = nil
+}
+
+func init() {
+	// o tupatest precisa montar contexts e rodar os finishers fora do router
+	hooks.NewContext = func(server any, w http.ResponseWriter, r *http.Request) any {
+		return server.(*APIServer).newContext(w, r)
+	}
+	hooks.RunFinishers = func(tc any) {
+		tc.(*TupaContext).finish()
+	}
+}
+
+// newContext monta o TupaContext da request ligado ao servidor, como o router faz
+func (a *APIServer) newContext(w http.ResponseWriter, r *http.Request) *TupaContext {
+	return &TupaContext{Req: r, Resp: w, Ctx: r.Context(), api: a}
+}
+
+// value busca a chave primeiro em tc.Ctx e depois no context da request
+func (tc *TupaContext) value(key interface{}) interface{} {
+	if tc.Ctx != nil {
+		if v := tc.Ctx.Value(key); v != nil {
+			return v
+		}
+	}
+	if tc.Req != nil {
+		return tc.Req.Context().Value(key)
+	}
+	return nil
+}
+
 func NewTupaContext(req **http.Request, resp http.ResponseWriter, ctx context.Context) *TupaContext {
 	return &TupaContext{
 		Req:  *req,
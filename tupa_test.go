@@ -302,3 +302,41 @@ func TestWriteJSONHelperStatuses(t *testing.T) {
 		}
 	})
 }
+
+func TestHandlerWithRouteManager(t *testing.T) {
+	manager := func() {
+		AddRoutes(nil, func() []RouteInfo {
+			return []RouteInfo{
+				{Path: "/handler/ping", Method: MethodGet, Handler: func(tc *TupaContext) error {
+					return tc.SendString("pong")
+				}},
+				{Path: "/handler/versao", Method: MethodGet, Version: "1", Handler: func(tc *TupaContext) error {
+					return tc.SendString("v1")
+				}},
+			}
+		})
+	}
+
+	// dois servidores com o mesmo routeManager, como acontece em testes
+	for i := 0; i < 2; i++ {
+		server := NewAPIServer(":8080", manager)
+		server.Handler()
+		// a segunda chamada não pode registrar as rotas de novo
+		handler := server.Handler()
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/handler/ping", nil))
+		if rr.Body.String() != "pong" {
+			t.Errorf("servidor %d: corpo recebido %q", i, rr.Body.String())
+		}
+
+		// a lista global já tem as rotas das duas execuções do routeManager
+		count := map[string]int{}
+		for _, route := range server.Routes() {
+			count[route.Pattern]++
+		}
+		if count["/handler/ping"] != 1 || count["/v1/handler/versao"] != 1 {
+			t.Errorf("servidor %d: rotas registradas mais de uma vez: %v", i, count)
+		}
+	}
+}
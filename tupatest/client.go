@@ -0,0 +1,244 @@
+// Package tupatest ajuda a testar aplicações Tupã sem abrir portas: um client fluente sobre
+// APIServer.Handler(), construtores de TupaContext e utilitários para testar middlewares
+package tupatest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tupatech/tupa"
+)
+
+// Client faz requests direto no handler do servidor, e.g.
+//
+//	client := tupatest.New(t, server)
+//	client.Get("/users/1").WithHeader("Authorization", "Bearer x").Expect(200).JSON(&user)
+type Client struct {
+	t       testing.TB
+	handler http.Handler
+	header  http.Header
+}
+
+// New cria um client para o servidor. As rotas são registradas por server.Handler()
+func New(t testing.TB, server *tupa.APIServer) *Client {
+	return NewFromHandler(t, server.Handler())
+}
+
+// NewFromHandler cria um client para qualquer http.Handler
+func NewFromHandler(t testing.TB, handler http.Handler) *Client {
+	return &Client{t: t, handler: handler, header: http.Header{}}
+}
+
+// WithHeader define um header enviado em todas as requests desse client
+func (c *Client) WithHeader(key, value string) *Client {
+	c.header.Set(key, value)
+	return c
+}
+
+func (c *Client) Get(path string) *Request     { return c.Request(http.MethodGet, path) }
+func (c *Client) Head(path string) *Request    { return c.Request(http.MethodHead, path) }
+func (c *Client) Delete(path string) *Request  { return c.Request(http.MethodDelete, path) }
+func (c *Client) Options(path string) *Request { return c.Request(http.MethodOptions, path) }
+
+// Post, Put e Patch mandam body como JSON, a não ser que seja io.Reader, string ou []byte
+func (c *Client) Post(path string, body any) *Request {
+	return c.Request(http.MethodPost, path).WithBody(body)
+}
+
+func (c *Client) Put(path string, body any) *Request {
+	return c.Request(http.MethodPut, path).WithBody(body)
+}
+
+func (c *Client) Patch(path string, body any) *Request {
+	return c.Request(http.MethodPatch, path).WithBody(body)
+}
+
+func (c *Client) Request(method, path string) *Request {
+	return &Request{
+		client: c,
+		method: method,
+		path:   path,
+		header: c.header.Clone(),
+		query:  url.Values{},
+		ctx:    context.Background(),
+	}
+}
+
+// Request é montada de forma fluente e só é enviada em Expect ou Send
+type Request struct {
+	client *Client
+	method string
+	path   string
+	header http.Header
+	query  url.Values
+	body   io.Reader
+	host   string
+	ctx    context.Context
+}
+
+func (r *Request) WithHeader(key, value string) *Request {
+	r.header.Set(key, value)
+	return r
+}
+
+func (r *Request) WithQuery(key, value string) *Request {
+	r.query.Add(key, value)
+	return r
+}
+
+// WithHost muda o Host da request, útil para rotas com RouteInfo.Host
+func (r *Request) WithHost(host string) *Request {
+	r.host = host
+	return r
+}
+
+func (r *Request) WithBearer(token string) *Request {
+	return r.WithHeader("Authorization", "Bearer "+token)
+}
+
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r.ctx = ctx
+	return r
+}
+
+// WithBody usa io.Reader, string e []byte como estão. Qualquer outro valor vira JSON
+// e a request ganha Content-Type application/json
+func (r *Request) WithBody(body any) *Request {
+	r.client.t.Helper()
+	switch b := body.(type) {
+	case nil:
+		r.body = nil
+	case io.Reader:
+		r.body = b
+	case string:
+		r.body = strings.NewReader(b)
+	case []byte:
+		r.body = bytes.NewReader(b)
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			r.client.t.Fatalf("tupatest: erro ao serializar o body: %v", err)
+		}
+		r.body = bytes.NewReader(data)
+		if r.header.Get("Content-Type") == "" {
+			r.header.Set("Content-Type", "application/json")
+		}
+	}
+	return r
+}
+
+// Send executa a request sem verificar nada
+func (r *Request) Send() *Response {
+	r.client.t.Helper()
+
+	target := r.path
+	if len(r.query) > 0 {
+		sep := "?"
+		if strings.Contains(target, "?") {
+			sep = "&"
+		}
+		target += sep + r.query.Encode()
+	}
+
+	req := httptest.NewRequest(r.method, target, r.body).WithContext(r.ctx)
+	req.Header = r.header
+	if r.host != "" {
+		req.Host = r.host
+	}
+
+	rr := httptest.NewRecorder()
+	r.client.handler.ServeHTTP(rr, req)
+	return &Response{
+		t:      r.client.t,
+		req:    req,
+		Status: rr.Code,
+		Header: rr.Header(),
+		Body:   rr.Body.Bytes(),
+	}
+}
+
+// Expect executa a request e falha o teste se o status for diferente
+func (r *Request) Expect(status int) *Response {
+	r.client.t.Helper()
+	resp := r.Send()
+	return resp.ExpectStatus(status)
+}
+
+type Response struct {
+	t   testing.TB
+	req *http.Request
+
+	Status int
+	Header http.Header
+	Body   []byte
+}
+
+func (r *Response) String() string {
+	return string(r.Body)
+}
+
+func (r *Response) ExpectStatus(status int) *Response {
+	r.t.Helper()
+	if r.Status != status {
+		r.t.Errorf("%s %s: status recebido %d, queria %d. Corpo: %s", r.req.Method, r.req.URL, r.Status, status, r.Body)
+	}
+	return r
+}
+
+func (r *Response) ExpectHeader(key, value string) *Response {
+	r.t.Helper()
+	if got := r.Header.Get(key); got != value {
+		r.t.Errorf("%s %s: header %s recebido %q, queria %q", r.req.Method, r.req.URL, key, got, value)
+	}
+	return r
+}
+
+func (r *Response) ExpectBody(body string) *Response {
+	r.t.Helper()
+	if string(r.Body) != body {
+		r.t.Errorf("%s %s: corpo recebido %q, queria %q", r.req.Method, r.req.URL, r.Body, body)
+	}
+	return r
+}
+
+func (r *Response) ExpectBodyContains(part string) *Response {
+	r.t.Helper()
+	if !strings.Contains(string(r.Body), part) {
+		r.t.Errorf("%s %s: corpo %q não contém %q", r.req.Method, r.req.URL, r.Body, part)
+	}
+	return r
+}
+
+// ExpectJSON compara o corpo com want depois de passar os dois por JSON, então a ordem das
+// chaves e a formatação não importam
+func (r *Response) ExpectJSON(want any) *Response {
+	r.t.Helper()
+	var got, expected any
+	if err := json.Unmarshal(r.Body, &got); err != nil {
+		r.t.Errorf("%s %s: corpo não é JSON: %v. Corpo: %s", r.req.Method, r.req.URL, err, r.Body)
+		return r
+	}
+	data, _ := json.Marshal(want)
+	json.Unmarshal(data, &expected)
+	if !reflect.DeepEqual(got, expected) {
+		r.t.Errorf("%s %s: JSON recebido %s, queria %s", r.req.Method, r.req.URL, r.Body, data)
+	}
+	return r
+}
+
+// JSON faz o unmarshal do corpo em out e para o teste se não for possível
+func (r *Response) JSON(out any) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body, out); err != nil {
+		r.t.Fatalf("%s %s: erro ao ler JSON: %v. Corpo: %s", r.req.Method, r.req.URL, err, r.Body)
+	}
+	return r
+}
@@ -0,0 +1,93 @@
+package tupatest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/tupatech/tupa"
+	"github.com/tupatech/tupa/internal/hooks"
+)
+
+type contextConfig struct {
+	params map[string]string
+	values []keyValue
+	header http.Header
+	body   io.Reader
+	host   string
+	server *tupa.APIServer
+}
+
+type keyValue struct {
+	key, value any
+}
+
+type ContextOption func(*contextConfig)
+
+// WithParams define os parâmetros de rota lidos por tc.Param
+func WithParams(params map[string]string) ContextOption {
+	return func(c *contextConfig) { c.params = params }
+}
+
+// WithValue adiciona um valor no context da request e em tc.Ctx, como um middleware faria
+func WithValue(key, value any) ContextOption {
+	return func(c *contextConfig) { c.values = append(c.values, keyValue{key, value}) }
+}
+
+func WithHeader(key, value string) ContextOption {
+	return func(c *contextConfig) { c.header.Add(key, value) }
+}
+
+func WithBody(body string) ContextOption {
+	return func(c *contextConfig) { c.body = strings.NewReader(body) }
+}
+
+func WithRequestHost(host string) ContextOption {
+	return func(c *contextConfig) { c.host = host }
+}
+
+// WithServer liga o context a um servidor já configurado, para o que depende dele: o renderer do
+// tc.Render, os proxies confiáveis do tc.RealIP, etc
+func WithServer(server *tupa.APIServer) ContextOption {
+	return func(c *contextConfig) { c.server = server }
+}
+
+// NewContext monta um TupaContext para chamar handlers e middlewares direto, sem router. Sem
+// WithServer o context fica ligado a um servidor vazio. O recorder guarda tudo que for escrito na
+// response; depois de chamar um handler direto, Finish(tc) termina o que os middlewares deixaram
+// para o fim da request ( RunMiddleware já faz isso )
+func NewContext(method, target string, opts ...ContextOption) (*tupa.TupaContext, *httptest.ResponseRecorder) {
+	cfg := &contextConfig{header: http.Header{}}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	req := httptest.NewRequest(method, target, cfg.body)
+	for k, v := range cfg.header {
+		req.Header[k] = v
+	}
+	if cfg.host != "" {
+		req.Host = cfg.host
+	}
+
+	ctx := req.Context()
+	for _, kv := range cfg.values {
+		ctx = context.WithValue(ctx, kv.key, kv.value)
+	}
+	req = tupa.WithVars(req.WithContext(ctx), cfg.params)
+
+	server := cfg.server
+	if server == nil {
+		server = tupa.NewAPIServer("", func() {})
+	}
+	rr := httptest.NewRecorder()
+	return hooks.NewContext(server, rr, req).(*tupa.TupaContext), rr
+}
+
+// Finish roda o que os middlewares deixaram para o fim da request ( fechar o gzip do Compress,
+// registrar métricas e spans, cancelar o Timeout ), como o router faz depois do handler
+func Finish(tc *tupa.TupaContext) {
+	hooks.RunFinishers(tc)
+}
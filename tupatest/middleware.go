@@ -0,0 +1,33 @@
+package tupatest
+
+import "github.com/tupatech/tupa"
+
+// MiddlewareResult diz o que aconteceu quando o middleware rodou
+type MiddlewareResult struct {
+	// Err é o erro devolvido pelo middleware
+	Err error
+	// NextCalled indica se o middleware chamou o próximo handler da cadeia
+	NextCalled bool
+	// Context é o TupaContext que chegou no próximo handler, com o que o middleware alterou.
+	// Nulo se NextCalled for false
+	Context *tupa.TupaContext
+}
+
+// RunMiddleware roda o middleware com um handler final que só registra a chamada. next, se
+// informado, roda no lugar desse handler final, e.g. para simular um handler que escreve a response
+// ou retorna erro. No fim roda Finish, como o router, então o recorder já tem a response
+// completa ( e.g. o corpo comprimido do Compress )
+func RunMiddleware(mw tupa.MiddlewareFunc, tc *tupa.TupaContext, next ...tupa.APIFunc) MiddlewareResult {
+	var result MiddlewareResult
+	final := func(tc *tupa.TupaContext) error {
+		result.NextCalled = true
+		result.Context = tc
+		if len(next) > 0 {
+			return next[0](tc)
+		}
+		return nil
+	}
+	result.Err = mw(final)(tc)
+	Finish(tc)
+	return result
+}
@@ -0,0 +1,186 @@
+package tupatest
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/tupatech/tupa"
+)
+
+type user struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+func newServer() *tupa.APIServer {
+	server := tupa.NewAPIServer(":8080", nil)
+	server.RegisterRoutes([]tupa.RouteInfo{
+		{
+			Path:   "/users/{id}",
+			Method: tupa.MethodGet,
+			Handler: func(tc *tupa.TupaContext) error {
+				if tc.Req.Header.Get("Authorization") != "Bearer segredo" {
+					return tupa.APIHandlerErr{Status: http.StatusUnauthorized, Msg: "não autorizado"}
+				}
+				return tupa.WriteJSONHelper(tc.Resp, http.StatusOK, user{ID: tc.Param("id"), Name: tc.QueryParam("nome")})
+			},
+		},
+		{
+			Path:   "/users",
+			Method: tupa.MethodPost,
+			Handler: func(tc *tupa.TupaContext) error {
+				var u user
+				if err := json.NewDecoder(tc.Req.Body).Decode(&u); err != nil {
+					return err
+				}
+				u.ID = "novo"
+				return tupa.WriteJSONHelper(tc.Resp, http.StatusCreated, u)
+			},
+		},
+	})
+	return server
+}
+
+func TestClient(t *testing.T) {
+	client := New(t, newServer())
+
+	t.Run("Teste Get com header, query e JSON", func(t *testing.T) {
+		var out user
+		client.Get("/users/1").
+			WithBearer("segredo").
+			WithQuery("nome", "Ana").
+			Expect(http.StatusOK).
+			ExpectHeader("Content-Type", "application/json").
+			JSON(&out)
+
+		if out != (user{ID: "1", Name: "Ana"}) {
+			t.Errorf("usuário recebido %+v", out)
+		}
+	})
+
+	t.Run("Teste Post com body JSON", func(t *testing.T) {
+		client.Post("/users", user{Name: "Bia"}).
+			Expect(http.StatusCreated).
+			ExpectJSON(map[string]string{"id": "novo", "name": "Bia"})
+	})
+
+	t.Run("Teste erro e 404 em JSON", func(t *testing.T) {
+		client.Get("/users/1").Expect(http.StatusUnauthorized).ExpectBodyContains("não autorizado")
+		client.Get("/nada").Expect(http.StatusNotFound)
+	})
+
+	t.Run("Teste Expect falha com status errado", func(t *testing.T) {
+		fake := &fakeT{TB: t}
+		New(fake, newServer()).Get("/nada").Expect(http.StatusOK)
+		if !fake.failed {
+			t.Error("esperava que Expect marcasse o teste como falho")
+		}
+	})
+}
+
+// fakeT captura as falhas sem derrubar o teste de verdade
+type fakeT struct {
+	testing.TB
+	failed bool
+}
+
+func (f *fakeT) Helper()                        {}
+func (f *fakeT) Errorf(string, ...any)          { f.failed = true }
+func (f *fakeT) Fatalf(format string, a ...any) { f.failed = true }
+
+func TestNewContextAndRunMiddleware(t *testing.T) {
+	type ctxKey string
+
+	tc, rr := NewContext(http.MethodGet, "/users/7",
+		WithParams(map[string]string{"id": "7"}),
+		WithValue(ctxKey("tenant"), "acme"),
+		WithHeader("X-Token", "abc"),
+	)
+	if tc.Param("id") != "7" || tc.Ctx.Value(ctxKey("tenant")) != "acme" {
+		t.Fatalf("context montado errado: param %q, tenant %v", tc.Param("id"), tc.Ctx.Value(ctxKey("tenant")))
+	}
+
+	auth := func(next tupa.APIFunc) tupa.APIFunc {
+		return func(tc *tupa.TupaContext) error {
+			if tc.Req.Header.Get("X-Token") == "" {
+				return tupa.APIHandlerErr{Status: http.StatusUnauthorized, Msg: "sem token"}
+			}
+			tc.Resp.Header().Set("X-Auth", "ok")
+			return next(tc)
+		}
+	}
+
+	result := RunMiddleware(auth, tc)
+	if result.Err != nil || !result.NextCalled || result.Context != tc {
+		t.Errorf("resultado inesperado: %+v", result)
+	}
+	if rr.Header().Get("X-Auth") != "ok" {
+		t.Error("header do middleware não chegou no recorder")
+	}
+
+	tc, _ = NewContext(http.MethodGet, "/users/7")
+	if result := RunMiddleware(auth, tc); result.Err == nil || result.NextCalled {
+		t.Errorf("esperava bloqueio sem token: %+v", result)
+	}
+}
+
+func TestRunMiddlewareCompress(t *testing.T) {
+	body := strings.Repeat("tupã ", 20)
+	tc, rr := NewContext(http.MethodGet, "/texto", WithHeader("Accept-Encoding", "gzip"))
+
+	result := RunMiddleware(tupa.Compress(tupa.CompressConfig{MinLength: 1}), tc, func(tc *tupa.TupaContext) error {
+		return tc.SendString(body)
+	})
+	if result.Err != nil {
+		t.Fatal(result.Err)
+	}
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("esperava gzip, headers %v", rr.Header())
+	}
+	reader, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil || string(got) != body {
+		t.Errorf("corpo descomprimido %q %v", got, err)
+	}
+}
+
+func TestFinish(t *testing.T) {
+	body := strings.Repeat("tupã ", 20)
+	tc, rr := NewContext(http.MethodGet, "/texto", WithHeader("Accept-Encoding", "gzip"))
+
+	handler := tupa.Compress(tupa.CompressConfig{MinLength: 1})(func(tc *tupa.TupaContext) error {
+		return tc.SendString(body)
+	})
+	if err := handler(tc); err != nil {
+		t.Fatal(err)
+	}
+	// sem o Finish o gzip não foi fechado e o corpo está incompleto
+	Finish(tc)
+
+	reader, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(reader)
+	if err != nil || string(got) != body {
+		t.Errorf("corpo descomprimido %q %v", got, err)
+	}
+}
+
+func TestNewContextWithServer(t *testing.T) {
+	server := tupa.NewAPIServer(":8080", nil)
+	if err := server.SetTrustedProxies(tupa.ProxyHeaderXRealIP, "192.0.2.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	tc, _ := NewContext(http.MethodGet, "/", WithServer(server), WithHeader("X-Real-IP", "198.51.100.7"))
+	if got := tc.RealIP(); got != "198.51.100.7" {
+		t.Errorf("RealIP recebido %q", got)
+	}
+}
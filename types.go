@@ -1,5 +1,7 @@
 package tupa
 
+import "time"
+
 type APIError struct {
 	Error string
 }
@@ -18,11 +20,39 @@ type HandleFunc func(*TupaContext) error
 type HTTPMethod string
 
 type RouteInfo struct {
-	Path             string
-	Method           HTTPMethod
-	Handler          APIFunc
+	Path   string
+	Method HTTPMethod
+	// Host restringe a rota a um host, e.g. "admin.example.com" ou "{tenant}.example.com".
+	// Vazio atende qualquer host
+	Host    string
+	Handler APIFunc
+	// Name identifica a rota para a.URLFor(). Precisa ser único no servidor
+	Name             string
 	Middlewares      []MiddlewareFunc
 	AfterMiddlewares []MiddlewareFunc
+	// BodyLimit é o tamanho máximo do corpo da request em bytes. 0 usa o limite global
+	// do APIServer ( SetBodyLimit ) e um valor negativo desliga o limite para essa rota
+	BodyLimit int64
+	// Timeout é o prazo que o handler tem para responder. Depois dele o cliente recebe 503
+	Timeout time.Duration
+	// Version é a versão da API a que a rota pertence, e.g. "2". A rota fica em /v2/<Path> e também
+	// em <Path>, escolhida pelo header Accept-Version ou pelo media type ( veja SetVersioning )
+	Version string
+
+	// Os campos abaixo só alimentam a documentação gerada por a.OpenAPI()
+	Summary     string
+	Description string
+	Tags        []string
+	OperationID string
+	Deprecated  bool
+	// Request é um valor do tipo do corpo da request ( e.g. CreateUserRequest{} ), o schema é gerado por reflection
+	Request any
+	// Responses mapeia o status para um valor do tipo da resposta. Um valor nil documenta uma resposta sem corpo
+	Responses map[int]any
+	// Security são nomes de esquemas em OpenAPIConfig.SecuritySchemes exigidos pela rota
+	Security []string
+	// Hidden tira a rota da documentação
+	Hidden bool
 }
 
 type RouteManager func()
@@ -0,0 +1,201 @@
+package tupa
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// VersionDeprecation marca uma versão como aposentada. As respostas dessa versão levam os headers
+// Deprecation ( RFC 9745 ), Sunset ( RFC 8594 ) e Link apontando para a documentação
+type VersionDeprecation struct {
+	// Since é quando a versão foi aposentada. Zero manda só "Deprecation: true"
+	Since  time.Time
+	Sunset time.Time
+	// Link é uma página explicando a migração
+	Link string
+}
+
+type VersioningConfig struct {
+	// Header é lido quando o path não tem /v{n}. Vazio usa "Accept-Version"
+	Header string
+	// Vendor do media type, e.g. "tupa" aceita "application/vnd.tupa.v2+json" no Accept.
+	// Vazio aceita qualquer vendor
+	Vendor string
+	// Default é a versão usada quando a request não pede nenhuma. Vazio usa a mais nova da rota
+	Default string
+	// Deprecated lista as versões aposentadas
+	Deprecated map[string]VersionDeprecation
+}
+
+// SetVersioning configura como as rotas com RouteInfo.Version são escolhidas. Pode ser chamado
+// antes ou depois de registrar as rotas
+func (a *APIServer) SetVersioning(cfg VersioningConfig) {
+	a.versioning = cfg
+}
+
+// APIVersion retorna a versão da API atendendo a request, vazio para rotas sem versão
+func (tc *TupaContext) APIVersion() string {
+	if v, ok := tc.value(apiVersionKey).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// versionedRoute atende o path sem prefixo e escolhe a versão pelos headers da request
+type versionedRoute struct {
+	api      *APIServer
+	route    RouteInfo
+	handlers map[string]http.HandlerFunc
+}
+
+func (a *APIServer) registerVersionedRoute(routeInfo RouteInfo) {
+	version := normalizeVersion(routeInfo.Version)
+
+	key := routeInfo.Host + " " + string(routeInfo.Method) + " " + routeInfo.Path
+	vr, exists := a.versionedRoutes[key]
+	if exists {
+		if _, dup := vr.handlers[version]; dup {
+			log.Fatalf("%sv%s %s", FmtRed("Versão registrada mais de uma vez: "), version, key)
+		}
+	}
+
+	// /v2/users atende sempre a v2
+	prefixed := routeInfo
+	prefixed.Version = version
+	prefixed.Path = "/v" + version + routeInfo.Path
+	if routeInfo.Path == "/" {
+		prefixed.Path = "/v" + version
+	}
+	// o handler é o mesmo para os dois paths, e tc.RoutePattern() mostra a versão
+	handler := a.versionHandler(version, a.MakeHTTPHandlerFuncHelper(prefixed))
+	a.registerRoute(prefixed, handler)
+
+	if !exists {
+		if a.versionedRoutes == nil {
+			a.versionedRoutes = map[string]*versionedRoute{}
+		}
+		vr = &versionedRoute{api: a, route: RouteInfo{Host: routeInfo.Host, Path: routeInfo.Path}, handlers: map[string]http.HandlerFunc{}}
+		a.versionedRoutes[key] = vr
+		a.router.HandleHost(routeInfo.Host, string(routeInfo.Method), routeInfo.Path, vr.ServeHTTP)
+	}
+	vr.handlers[version] = handler
+}
+
+func (vr *versionedRoute) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	cfg := vr.api.versioning
+	// a resposta depende desses headers, caches precisam saber
+	w.Header().Add("Vary", versionHeader(cfg))
+	w.Header().Add("Vary", "Accept")
+
+	version := requestedVersion(r, cfg)
+	if version == "" {
+		version = vr.defaultVersion(cfg)
+	}
+
+	handler, ok := vr.handlers[version]
+	if !ok {
+		// passa pelos middlewares globais como as outras respostas, e.g. CORS e SecureHeaders
+		route := vr.route
+		route.Method = HTTPMethod(r.Method)
+		route.Handler = func(tc *TupaContext) error {
+			return APIHandlerErr{
+				Status: http.StatusBadRequest,
+				Msg:    fmt.Sprintf("Versão %s da API não existe para essa rota. Versões disponíveis: %s", version, strings.Join(vr.versions(), ", ")),
+			}
+		}
+		vr.api.MakeHTTPHandlerFuncHelper(route)(w, r)
+		return
+	}
+	handler(w, r)
+}
+
+func (vr *versionedRoute) versions() []string {
+	versions := make([]string, 0, len(vr.handlers))
+	for v := range vr.handlers {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versionLess(versions[i], versions[j]) })
+	return versions
+}
+
+func (vr *versionedRoute) defaultVersion(cfg VersioningConfig) string {
+	if def := normalizeVersion(cfg.Default); def != "" {
+		if _, ok := vr.handlers[def]; ok {
+			return def
+		}
+	}
+	versions := vr.versions()
+	return versions[len(versions)-1]
+}
+
+// versionHandler guarda a versão no context e adiciona os headers de depreciação
+func (a *APIServer) versionHandler(version string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if dep, ok := a.versioning.Deprecated[version]; ok {
+			h := w.Header()
+			if dep.Since.IsZero() {
+				h.Set("Deprecation", "true")
+			} else {
+				h.Set("Deprecation", "@"+strconv.FormatInt(dep.Since.Unix(), 10))
+			}
+			if !dep.Sunset.IsZero() {
+				h.Set("Sunset", dep.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if dep.Link != "" {
+				h.Add("Link", "<"+dep.Link+`>; rel="deprecation"`)
+			}
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey, version)))
+	}
+}
+
+func versionHeader(cfg VersioningConfig) string {
+	if cfg.Header != "" {
+		return cfg.Header
+	}
+	return "Accept-Version"
+}
+
+var vendorMediaTypeRegex = regexp.MustCompile(`^application/vnd\.([a-z0-9][a-z0-9.\-]*)\.v([0-9a-z.\-]+)\+json$`)
+
+// requestedVersion lê a versão do header e depois do media type no Accept
+func requestedVersion(r *http.Request, cfg VersioningConfig) string {
+	if v := normalizeVersion(r.Header.Get(versionHeader(cfg))); v != "" {
+		return v
+	}
+	for _, accept := range headerTokens(r.Header, "Accept") {
+		mediaType, _, _ := strings.Cut(accept, ";")
+		m := vendorMediaTypeRegex.FindStringSubmatch(strings.ToLower(strings.TrimSpace(mediaType)))
+		if m == nil || (cfg.Vendor != "" && m[1] != strings.ToLower(cfg.Vendor)) {
+			continue
+		}
+		return m[2]
+	}
+	return ""
+}
+
+// normalizeVersion aceita "2" e "v2"
+func normalizeVersion(v string) string {
+	v = strings.TrimSpace(v)
+	if len(v) > 1 && (v[0] == 'v' || v[0] == 'V') {
+		return v[1:]
+	}
+	return v
+}
+
+// versionLess compara numericamente quando as duas versões são números, senão como texto
+func versionLess(a, b string) bool {
+	na, errA := strconv.ParseFloat(a, 64)
+	nb, errB := strconv.ParseFloat(b, 64)
+	if errA == nil && errB == nil {
+		return na < nb
+	}
+	return a < b
+}
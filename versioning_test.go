@@ -0,0 +1,128 @@
+package tupa
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestVersioning(t *testing.T) {
+	server := NewAPIServer(":8080", nil)
+	reply := func(tc *TupaContext) error {
+		return tc.SendString("v" + tc.APIVersion())
+	}
+	server.RegisterRoutes([]RouteInfo{
+		{Path: "/versao/users", Method: MethodGet, Version: "1", Handler: reply},
+		{Path: "/versao/users", Method: MethodGet, Version: "v2", Handler: reply, Name: "users.v2"},
+		{Path: "/versao/users", Method: MethodGet, Version: "10", Handler: reply},
+	})
+	sunset := time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	server.SetVersioning(VersioningConfig{
+		Vendor:  "tupa",
+		Default: "2",
+		Deprecated: map[string]VersionDeprecation{
+			"1": {Since: time.Unix(1700000000, 0), Sunset: sunset, Link: "https://example.com/migracao"},
+		},
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		header     http.Header
+		statusCode int
+		body       string
+	}{
+		{"prefixo no path", "/v1/versao/users", nil, http.StatusOK, "v1"},
+		{"sem versão usa o Default", "/versao/users", nil, http.StatusOK, "v2"},
+		{"header Accept-Version", "/versao/users", http.Header{"Accept-Version": {"v10"}}, http.StatusOK, "v10"},
+		{"media type do vendor", "/versao/users", http.Header{"Accept": {"text/html, application/vnd.tupa.v1+json; q=0.9"}}, http.StatusOK, "v1"},
+		{"media type de outro vendor é ignorado", "/versao/users", http.Header{"Accept": {"application/vnd.outro.v1+json"}}, http.StatusOK, "v2"},
+		{"versão inexistente", "/versao/users", http.Header{"Accept-Version": {"3"}}, http.StatusBadRequest, ""},
+		{"prefixo de versão inexistente", "/v3/versao/users", nil, http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header[k] = v
+			}
+			rr := httptest.NewRecorder()
+			server.router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.statusCode {
+				t.Errorf("status recebido %d, queria %d: %s", rr.Code, tt.statusCode, rr.Body.String())
+			}
+			if tt.body != "" && rr.Body.String() != tt.body {
+				t.Errorf("corpo recebido %q, queria %q", rr.Body.String(), tt.body)
+			}
+		})
+	}
+
+	t.Run("Teste headers de versão aposentada", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/versao/users", nil)
+		req.Header.Set("Accept-Version", "1")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		want := map[string]string{
+			"Deprecation": "@1700000000",
+			"Sunset":      "Tue, 01 Jan 2030 00:00:00 GMT",
+			"Link":        `<https://example.com/migracao>; rel="deprecation"`,
+		}
+		for k, v := range want {
+			if got := rr.Header().Get(k); got != v {
+				t.Errorf("%s recebido %q, queria %q", k, got, v)
+			}
+		}
+		if got := rr.Header().Values("Vary"); len(got) != 2 {
+			t.Errorf("Vary recebido %v", got)
+		}
+
+		rr = httptest.NewRecorder()
+		server.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v2/versao/users", nil))
+		if rr.Header().Get("Deprecation") != "" {
+			t.Error("versão atual não deveria ter header Deprecation")
+		}
+	})
+
+	t.Run("Teste URLFor aponta para o path com versão", func(t *testing.T) {
+		if got := server.MustURLFor("users.v2", nil, nil); got != "/v2/versao/users" {
+			t.Errorf("URLFor recebido %q", got)
+		}
+	})
+
+	t.Run("Teste versão inexistente passa pelos middlewares globais", func(t *testing.T) {
+		server.UseGlobalMiddlewares(SecureHeaders())
+		req := httptest.NewRequest(http.MethodGet, "/versao/users", nil)
+		req.Header.Set("Accept-Version", "3")
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest || rr.Header().Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("esperava 400 com os headers do SecureHeaders, recebeu %d %v", rr.Code, rr.Header())
+		}
+	})
+}
+
+// TestVersioningDuplicate roda o próprio binário de teste, já que versão repetida encerra o processo
+func TestVersioningDuplicate(t *testing.T) {
+	if os.Getenv("TUPA_TEST_VERSION_DUP") == "1" {
+		server := NewAPIServer(":8080", nil)
+		server.RegisterRoutes([]RouteInfo{
+			{Path: "/versao/users", Method: MethodGet, Version: "2", Handler: func(tc *TupaContext) error { return nil }},
+			{Path: "/versao/users", Method: MethodGet, Version: "v2", Handler: func(tc *TupaContext) error { return nil }},
+		})
+		return
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=^TestVersioningDuplicate$")
+	cmd.Env = append(os.Environ(), "TUPA_TEST_VERSION_DUP=1")
+	out, err := cmd.CombinedOutput()
+	if err == nil || !strings.Contains(string(out), "Versão registrada mais de uma vez") {
+		t.Errorf("esperava o processo encerrado, recebeu %v:\n%s", err, out)
+	}
+}
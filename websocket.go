@@ -0,0 +1,641 @@
+package tupa
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+type WSMessageType int
+
+const (
+	WSText   WSMessageType = 1
+	WSBinary WSMessageType = 2
+)
+
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// Códigos de fechamento da RFC 6455, seção 7.4.1
+const (
+	WSCloseNormal          = 1000
+	WSCloseGoingAway       = 1001
+	WSCloseProtocolError   = 1002
+	WSCloseUnsupportedData = 1003
+	WSCloseNoStatus        = 1005
+	WSCloseAbnormal        = 1006
+	WSCloseInvalidPayload  = 1007
+	WSClosePolicyViolation = 1008
+	WSCloseMessageTooBig   = 1009
+	WSCloseInternalError   = 1011
+)
+
+// GUID fixo da RFC 6455 usado para calcular o Sec-WebSocket-Accept
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WSCloseError é retornado por ReadMessage quando o outro lado fecha a conexão
+type WSCloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *WSCloseError) Error() string {
+	return fmt.Sprintf("websocket fechado: %d %s", e.Code, e.Reason)
+}
+
+type WSConfig struct {
+	Subprotocols []string
+	// CheckOrigin decide se o Origin da request é aceito. Nulo aceita requests sem Origin ou com o
+	// mesmo host da request, que é o que protege contra Cross-Site WebSocket Hijacking
+	CheckOrigin func(r *http.Request) bool
+	// MaxMessageSize é o tamanho máximo de uma mensagem, já juntando os fragmentos. 0 usa 1 MB
+	MaxMessageSize int64
+	// EnableCompression negocia permessage-deflate ( RFC 7692 ) quando o cliente oferece
+	EnableCompression bool
+	// PingInterval envia pings nesse intervalo e fecha a conexão se nada chegar em 2x o intervalo. 0 desliga
+	PingInterval time.Duration
+	// WriteTimeout é o prazo de cada escrita. 0 usa 10 segundos
+	WriteTimeout time.Duration
+}
+
+// WSConn é uma conexão WebSocket já aberta. Pode ter uma goroutine lendo e outra escrevendo ao mesmo tempo
+type WSConn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	tc          *TupaContext
+	config      WSConfig
+	subprotocol string
+	compress    bool
+
+	writeMu       sync.Mutex
+	reading       atomic.Bool
+	closeSent     atomic.Bool
+	closeReceived atomic.Bool
+	closeOnce     sync.Once
+	done          chan struct{}
+}
+
+// WebSocket transforma um handler de conexão em um APIFunc. Os middlewares da rota ( auth, por exemplo )
+// rodam antes do upgrade, então uma request recusada recebe a resposta HTTP normal de erro
+func WebSocket(handler func(conn *WSConn) error, cfg ...WSConfig) APIFunc {
+	return func(tc *TupaContext) error {
+		conn, err := tc.UpgradeWebSocket(cfg...)
+		if err != nil {
+			return err
+		}
+
+		if err := handler(conn); err != nil {
+			slog.Error("WebSocket Error", "err:", err)
+			conn.Close(WSCloseInternalError, "")
+			return nil
+		}
+		conn.Close(WSCloseNormal, "")
+		// a conexão foi sequestrada, não tem mais como escrever uma resposta HTTP
+		return nil
+	}
+}
+
+// UpgradeWebSocket faz o handshake e retorna a conexão. Erros de handshake são APIHandlerErr, que
+// ainda podem ser respondidos normalmente porque a conexão não foi sequestrada
+func (tc *TupaContext) UpgradeWebSocket(cfg ...WSConfig) (*WSConn, error) {
+	config := WSConfig{}
+	if len(cfg) > 0 {
+		config = cfg[0]
+	}
+	if config.MaxMessageSize == 0 {
+		config.MaxMessageSize = 1 << 20
+	}
+	if config.WriteTimeout == 0 {
+		config.WriteTimeout = 10 * time.Second
+	}
+
+	r := tc.Req
+	if r.Method != http.MethodGet {
+		return nil, APIHandlerErr{Status: http.StatusMethodNotAllowed, Msg: "WebSocket precisa de GET"}
+	}
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		return nil, APIHandlerErr{Status: http.StatusBadRequest, Msg: "Request não é um upgrade para WebSocket"}
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		tc.Resp.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, APIHandlerErr{Status: http.StatusUpgradeRequired, Msg: "Versão de WebSocket não suportada"}
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return nil, APIHandlerErr{Status: http.StatusBadRequest, Msg: "Sec-WebSocket-Key inválido"}
+	}
+
+	checkOrigin := config.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return nil, APIHandlerErr{Status: http.StatusForbidden, Msg: "Origin não permitido"}
+	}
+
+	subprotocol := ""
+	for _, offered := range headerTokens(r.Header, "Sec-WebSocket-Protocol") {
+		if containsString(config.Subprotocols, offered) {
+			subprotocol = offered
+			break
+		}
+	}
+
+	compress := config.EnableCompression && offersPerMessageDeflate(r.Header)
+
+	netConn, brw, err := http.NewResponseController(tc.Resp).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("a response não suporta Hijack, necessário para WebSocket: %w", err)
+	}
+	// prazos configurados no http.Server não valem mais depois do upgrade
+	netConn.SetDeadline(time.Time{})
+	// after middlewares e finishers ainda rodam, mas não podem escrever na conexão sequestrada
+	tc.hijacked = true
+	tc.Resp = hijackedResponseWriter{tc.Resp}
+
+	var resp strings.Builder
+	resp.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	resp.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n")
+	if subprotocol != "" {
+		resp.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+	if compress {
+		// sem context takeover nos dois lados, cada mensagem é comprimida de forma independente
+		resp.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	resp.WriteString("\r\n")
+
+	netConn.SetWriteDeadline(time.Now().Add(config.WriteTimeout))
+	if _, err := netConn.Write([]byte(resp.String())); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	netConn.SetWriteDeadline(time.Time{})
+
+	c := &WSConn{
+		conn:        netConn,
+		br:          brw.Reader,
+		tc:          tc,
+		config:      config,
+		subprotocol: subprotocol,
+		compress:    compress,
+		done:        make(chan struct{}),
+	}
+	if config.PingInterval > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(2 * config.PingInterval))
+		go c.pingLoop()
+	}
+	return c, nil
+}
+
+// hijackedResponseWriter descarta as escritas depois do upgrade, que o net/http recusaria com um log
+type hijackedResponseWriter struct {
+	http.ResponseWriter
+}
+
+func (hijackedResponseWriter) WriteHeader(int)           {}
+func (hijackedResponseWriter) Write([]byte) (int, error) { return 0, http.ErrHijacked }
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func headerTokens(h http.Header, name string) []string {
+	var tokens []string
+	for _, value := range h.Values(name) {
+		for _, token := range strings.Split(value, ",") {
+			if token = strings.TrimSpace(token); token != "" {
+				tokens = append(tokens, token)
+			}
+		}
+	}
+	return tokens
+}
+
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, t := range headerTokens(h, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+func offersPerMessageDeflate(h http.Header) bool {
+	for _, ext := range headerTokens(h, "Sec-WebSocket-Extensions") {
+		name, _, _ := strings.Cut(ext, ";")
+		if strings.TrimSpace(name) == "permessage-deflate" {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *WSConn) Subprotocol() string               { return c.subprotocol }
+func (c *WSConn) Context() *TupaContext             { return c.tc }
+func (c *WSConn) RemoteAddr() net.Addr              { return c.conn.RemoteAddr() }
+func (c *WSConn) CompressionEnabled() bool          { return c.compress }
+func (c *WSConn) Done() <-chan struct{}             { return c.done }
+func (c *WSConn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+type wsFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+func (c *WSConn) readFrame(remaining int64) (wsFrame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return wsFrame{}, err
+	}
+
+	f := wsFrame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: header[0] & 0x0F,
+	}
+	if header[0]&0x30 != 0 {
+		return f, c.protocolError("bits RSV2/RSV3 não negociados")
+	}
+	if f.rsv1 && !c.compress {
+		return f, c.protocolError("bit RSV1 sem compressão negociada")
+	}
+	// frames do cliente precisam vir mascarados ( seção 5.1 )
+	if header[1]&0x80 == 0 {
+		return f, c.protocolError("frame do cliente sem máscara")
+	}
+
+	length := int64(header[1] & 0x7F)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return f, err
+		}
+		if ext[0]&0x80 != 0 {
+			return f, c.protocolError("tamanho de frame inválido")
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	isControl := f.opcode&0x8 != 0
+	if isControl && (length > 125 || !f.fin) {
+		return f, c.protocolError("frame de controle inválido")
+	}
+	if !isControl && length > remaining {
+		c.closeWithError(WSCloseMessageTooBig, "mensagem muito grande")
+		return f, &WSCloseError{Code: WSCloseMessageTooBig, Reason: "mensagem muito grande"}
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return f, err
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return f, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i%4]
+	}
+	return f, nil
+}
+
+// ReadMessage lê a próxima mensagem de texto ou binária, juntando fragmentos. Pings são respondidos
+// automaticamente. Quando o cliente fecha, a resposta de fechamento é enviada e o erro é *WSCloseError
+func (c *WSConn) ReadMessage() (WSMessageType, []byte, error) {
+	c.reading.Store(true)
+	defer c.reading.Store(false)
+
+	var (
+		messageType WSMessageType
+		compressed  bool
+		message     []byte
+		inMessage   bool
+	)
+
+	for {
+		f, err := c.readFrame(c.config.MaxMessageSize - int64(len(message)))
+		if err != nil {
+			return 0, nil, c.readErr(err)
+		}
+		if c.config.PingInterval > 0 {
+			c.conn.SetReadDeadline(time.Now().Add(2 * c.config.PingInterval))
+		}
+
+		switch f.opcode {
+		case wsOpPing:
+			if err := c.writeFrame(wsOpPong, f.payload, false); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case wsOpPong:
+			continue
+		case wsOpClose:
+			return 0, nil, c.handleClose(f.payload)
+		case wsOpText, wsOpBinary:
+			if inMessage {
+				return 0, nil, c.protocolError("nova mensagem antes do fim da anterior")
+			}
+			inMessage = true
+			messageType = WSMessageType(f.opcode)
+			compressed = f.rsv1
+		case wsOpContinuation:
+			if !inMessage {
+				return 0, nil, c.protocolError("continuação sem mensagem iniciada")
+			}
+			if f.rsv1 {
+				return 0, nil, c.protocolError("RSV1 em frame de continuação")
+			}
+		default:
+			return 0, nil, c.protocolError("opcode desconhecido")
+		}
+
+		message = append(message, f.payload...)
+		if !f.fin {
+			continue
+		}
+
+		if compressed {
+			message, err = c.inflate(message)
+			if err != nil {
+				return 0, nil, err
+			}
+		}
+		if messageType == WSText && !utf8.Valid(message) {
+			c.closeWithError(WSCloseInvalidPayload, "texto não é UTF-8")
+			return 0, nil, &WSCloseError{Code: WSCloseInvalidPayload, Reason: "texto não é UTF-8"}
+		}
+		return messageType, message, nil
+	}
+}
+
+func (c *WSConn) inflate(data []byte) ([]byte, error) {
+	// o transmissor remove o final 00 00 ff ff do bloco, que precisa voltar antes de descomprimir ( RFC 7692 7.2.2 )
+	fr := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader([]byte{0x00, 0x00, 0xff, 0xff})))
+	defer fr.Close()
+
+	out, err := io.ReadAll(io.LimitReader(fr, c.config.MaxMessageSize+1))
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, c.protocolError("mensagem comprimida inválida")
+	}
+	if int64(len(out)) > c.config.MaxMessageSize {
+		c.closeWithError(WSCloseMessageTooBig, "mensagem muito grande")
+		return nil, &WSCloseError{Code: WSCloseMessageTooBig, Reason: "mensagem muito grande"}
+	}
+	return out, nil
+}
+
+func (c *WSConn) handleClose(payload []byte) error {
+	closeErr := &WSCloseError{Code: WSCloseNoStatus}
+	switch {
+	case len(payload) == 1:
+		return c.protocolError("frame de close inválido")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Reason = string(payload[2:])
+		if !validCloseCode(closeErr.Code) || !utf8.Valid(payload[2:]) {
+			return c.protocolError("código de close inválido")
+		}
+	}
+
+	c.closeReceived.Store(true)
+	if !c.closeSent.Load() {
+		// responde com o mesmo código, como pede a seção 5.5.1
+		code := closeErr.Code
+		if code == WSCloseNoStatus {
+			code = WSCloseNormal
+		}
+		c.sendClose(code, "")
+	}
+	c.shutdown()
+	return closeErr
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+func (c *WSConn) protocolError(reason string) error {
+	c.closeWithError(WSCloseProtocolError, reason)
+	return &WSCloseError{Code: WSCloseProtocolError, Reason: reason}
+}
+
+func (c *WSConn) closeWithError(code int, reason string) {
+	c.sendClose(code, reason)
+	c.shutdown()
+}
+
+func (c *WSConn) readErr(err error) error {
+	var closeErr *WSCloseError
+	if errors.As(err, &closeErr) {
+		return err
+	}
+	// conexão caiu sem o handshake de fechamento
+	c.shutdown()
+	if c.closeSent.Load() {
+		return &WSCloseError{Code: WSCloseNormal}
+	}
+	return &WSCloseError{Code: WSCloseAbnormal, Reason: err.Error()}
+}
+
+func (c *WSConn) writeFrame(opcode byte, payload []byte, compressed bool) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	select {
+	case <-c.done:
+		return net.ErrClosed
+	default:
+	}
+
+	var header [10]byte
+	header[0] = 0x80 | opcode
+	if compressed {
+		header[0] |= 0x40
+	}
+	n := 2
+	switch {
+	case len(payload) <= 125:
+		header[1] = byte(len(payload))
+	case len(payload) <= 0xFFFF:
+		header[1] = 126
+		binary.BigEndian.PutUint16(header[2:], uint16(len(payload)))
+		n = 4
+	default:
+		header[1] = 127
+		binary.BigEndian.PutUint64(header[2:], uint64(len(payload)))
+		n = 10
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout))
+	// frames do servidor não são mascarados
+	if _, err := c.conn.Write(append(header[:n], payload...)); err != nil {
+		c.shutdown()
+		return err
+	}
+	return nil
+}
+
+func (c *WSConn) WriteMessage(messageType WSMessageType, data []byte) error {
+	if messageType != WSText && messageType != WSBinary {
+		return errors.New("tipo de mensagem WebSocket inválido")
+	}
+	if c.closeSent.Load() {
+		return net.ErrClosed
+	}
+	if !c.compress {
+		return c.writeFrame(byte(messageType), data, false)
+	}
+
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.DefaultCompression)
+	fw.Write(data)
+	fw.Flush()
+	compressed := bytes.TrimSuffix(buf.Bytes(), []byte{0x00, 0x00, 0xff, 0xff})
+	return c.writeFrame(byte(messageType), compressed, true)
+}
+
+func (c *WSConn) WriteText(text string) error {
+	return c.WriteMessage(WSText, []byte(text))
+}
+
+func (c *WSConn) WriteJSON(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(WSText, data)
+}
+
+// ReadJSON lê a próxima mensagem e faz o unmarshal em v
+func (c *WSConn) ReadJSON(v any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (c *WSConn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("payload de ping maior que 125 bytes")
+	}
+	return c.writeFrame(wsOpPing, data, false)
+}
+
+func (c *WSConn) pingLoop() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.Ping(nil); err != nil {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *WSConn) sendClose(code int, reason string) error {
+	if !c.closeSent.CompareAndSwap(false, true) {
+		return nil
+	}
+	// o payload de controle tem no máximo 125 bytes, e o motivo precisa continuar sendo UTF-8 válido
+	if len(reason) > 123 {
+		n := 123
+		for n > 0 && !utf8.RuneStart(reason[n]) {
+			n--
+		}
+		reason = reason[:n]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	return c.writeFrame(wsOpClose, payload, false)
+}
+
+// Close faz o handshake de fechamento: envia o close, espera a resposta do cliente por até 1 segundo
+// e fecha a conexão TCP
+func (c *WSConn) Close(code int, reason string) error {
+	err := c.sendClose(code, reason)
+	if c.closeReceived.Load() {
+		c.shutdown()
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(time.Second))
+	if !c.reading.Load() {
+		// ninguém está lendo, então esperamos o close do cliente aqui mesmo
+		for {
+			f, ferr := c.readFrame(c.config.MaxMessageSize)
+			if ferr != nil || f.opcode == wsOpClose {
+				break
+			}
+		}
+		c.shutdown()
+	} else {
+		// quem está lendo recebe o close do cliente e encerra a conexão
+		time.AfterFunc(time.Second, c.shutdown)
+	}
+	return err
+}
+
+func (c *WSConn) shutdown() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
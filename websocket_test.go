@@ -0,0 +1,326 @@
+package tupa
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// wsTestClient é um cliente mínimo, só o suficiente para exercitar o servidor
+type wsTestClient struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func dialWS(t *testing.T, ts *httptest.Server, path string, header http.Header) *wsTestClient {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(ts.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &wsTestClient{t: t, conn: conn, br: br, resp: resp}
+}
+
+func (c *wsTestClient) writeFrame(fin bool, rsv1 bool, opcode byte, payload []byte) {
+	c.t.Helper()
+	var b bytes.Buffer
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	if rsv1 {
+		first |= 0x40
+	}
+	b.WriteByte(first)
+	switch {
+	case len(payload) <= 125:
+		b.WriteByte(0x80 | byte(len(payload)))
+	default:
+		b.WriteByte(0x80 | 126)
+		binary.Write(&b, binary.BigEndian, uint16(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	b.Write(mask)
+	for i, p := range payload {
+		b.WriteByte(p ^ mask[i%4])
+	}
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *wsTestClient) readFrame() (opcode byte, rsv1 bool, payload []byte) {
+	c.t.Helper()
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		c.t.Fatal(err)
+	}
+	if header[1]&0x80 != 0 {
+		c.t.Fatal("frame do servidor não pode vir mascarado")
+	}
+	length := int(header[1] & 0x7F)
+	if length == 126 {
+		var ext [2]byte
+		io.ReadFull(c.br, ext[:])
+		length = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		c.t.Fatal(err)
+	}
+	return header[0] & 0x0F, header[0]&0x40 != 0, payload
+}
+
+func closePayload(code int, reason string) []byte {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	return append(payload, reason...)
+}
+
+func TestWebSocket(t *testing.T) {
+	echo := WebSocket(func(conn *WSConn) error {
+		for {
+			messageType, data, err := conn.ReadMessage()
+			if err != nil {
+				var closeErr *WSCloseError
+				if errors.As(err, &closeErr) {
+					return nil
+				}
+				return err
+			}
+			if err := conn.WriteMessage(messageType, data); err != nil {
+				return err
+			}
+		}
+	}, WSConfig{Subprotocols: []string{"chat"}, EnableCompression: true, MaxMessageSize: 1024})
+
+	auth := func(next APIFunc) APIFunc {
+		return func(tc *TupaContext) error {
+			if tc.QueryParam("token") != "segredo" {
+				return APIHandlerErr{Status: http.StatusUnauthorized, Msg: "não autorizado"}
+			}
+			return next(tc)
+		}
+	}
+
+	server := NewAPIServer(":8080", nil)
+	server.RegisterRoutes([]RouteInfo{
+		{Path: "/ws/echo", Method: MethodGet, Handler: echo, Middlewares: []MiddlewareFunc{auth}},
+		{Path: "/ws/timeout", Method: MethodGet, Handler: echo, Timeout: time.Minute},
+	})
+	ts := httptest.NewServer(server.router)
+	defer ts.Close()
+
+	t.Run("Teste handshake e eco de mensagem", func(t *testing.T) {
+		c := dialWS(t, ts, "/ws/echo?token=segredo", http.Header{"Sec-Websocket-Protocol": {"outro, chat"}})
+		defer c.conn.Close()
+
+		if c.resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("status recebido %d, queria %d", c.resp.StatusCode, http.StatusSwitchingProtocols)
+		}
+		// valor de exemplo da RFC 6455, seção 1.3
+		if got := c.resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+			t.Errorf("Sec-WebSocket-Accept recebido %q", got)
+		}
+		if got := c.resp.Header.Get("Sec-WebSocket-Protocol"); got != "chat" {
+			t.Errorf("subprotocolo recebido %q, queria %q", got, "chat")
+		}
+
+		c.writeFrame(true, false, wsOpText, []byte("olá"))
+		if op, _, payload := c.readFrame(); op != wsOpText || string(payload) != "olá" {
+			t.Errorf("eco recebido opcode %d %q", op, payload)
+		}
+	})
+
+	t.Run("Teste mensagem fragmentada com ping no meio", func(t *testing.T) {
+		c := dialWS(t, ts, "/ws/echo?token=segredo", nil)
+		defer c.conn.Close()
+
+		c.writeFrame(false, false, wsOpBinary, []byte("abc"))
+		c.writeFrame(true, false, wsOpPing, []byte("p"))
+		c.writeFrame(false, false, wsOpContinuation, []byte("def"))
+		c.writeFrame(true, false, wsOpContinuation, []byte("ghi"))
+
+		if op, _, payload := c.readFrame(); op != wsOpPong || string(payload) != "p" {
+			t.Errorf("esperava pong com %q, recebeu opcode %d %q", "p", op, payload)
+		}
+		if op, _, payload := c.readFrame(); op != wsOpBinary || string(payload) != "abcdefghi" {
+			t.Errorf("mensagem remontada recebida opcode %d %q", op, payload)
+		}
+	})
+
+	t.Run("Teste handshake de fechamento", func(t *testing.T) {
+		c := dialWS(t, ts, "/ws/echo?token=segredo", nil)
+		defer c.conn.Close()
+
+		c.writeFrame(true, false, wsOpClose, closePayload(WSCloseGoingAway, "tchau"))
+		op, _, payload := c.readFrame()
+		if op != wsOpClose || binary.BigEndian.Uint16(payload) != WSCloseGoingAway {
+			t.Errorf("esperava close %d, recebeu opcode %d %v", WSCloseGoingAway, op, payload)
+		}
+	})
+
+	t.Run("Teste violações de protocolo", func(t *testing.T) {
+		cases := []struct {
+			name string
+			send func(c *wsTestClient)
+			code int
+		}{
+			{"continuação sem início", func(c *wsTestClient) {
+				c.writeFrame(true, false, wsOpContinuation, []byte("x"))
+			}, WSCloseProtocolError},
+			{"texto inválido", func(c *wsTestClient) {
+				c.writeFrame(true, false, wsOpText, []byte{0xff, 0xfe})
+			}, WSCloseInvalidPayload},
+			{"mensagem grande demais", func(c *wsTestClient) {
+				c.writeFrame(true, false, wsOpBinary, make([]byte, 2048))
+			}, WSCloseMessageTooBig},
+		}
+		for _, tt := range cases {
+			c := dialWS(t, ts, "/ws/echo?token=segredo", nil)
+			tt.send(c)
+			op, _, payload := c.readFrame()
+			if op != wsOpClose || int(binary.BigEndian.Uint16(payload)) != tt.code {
+				t.Errorf("%s: esperava close %d, recebeu opcode %d %v", tt.name, tt.code, op, payload)
+			}
+			c.conn.Close()
+		}
+	})
+
+	t.Run("Teste permessage-deflate", func(t *testing.T) {
+		c := dialWS(t, ts, "/ws/echo?token=segredo", http.Header{"Sec-Websocket-Extensions": {"permessage-deflate; client_max_window_bits"}})
+		defer c.conn.Close()
+
+		if got := c.resp.Header.Get("Sec-WebSocket-Extensions"); !strings.HasPrefix(got, "permessage-deflate") {
+			t.Fatalf("extensão não negociada: %q", got)
+		}
+
+		message := strings.Repeat("comprimido ", 20)
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+		fw.Write([]byte(message))
+		fw.Flush()
+		c.writeFrame(true, true, wsOpText, bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff}))
+
+		op, rsv1, payload := c.readFrame()
+		if op != wsOpText || !rsv1 {
+			t.Fatalf("esperava texto comprimido, recebeu opcode %d rsv1 %v", op, rsv1)
+		}
+		fr := flate.NewReader(io.MultiReader(bytes.NewReader(payload), bytes.NewReader([]byte{0, 0, 0xff, 0xff})))
+		got, _ := io.ReadAll(fr)
+		if string(got) != message {
+			t.Errorf("mensagem descomprimida %q, queria %q", got, message)
+		}
+	})
+
+	t.Run("Teste middleware recusa antes do upgrade", func(t *testing.T) {
+		c := dialWS(t, ts, "/ws/echo", nil)
+		defer c.conn.Close()
+
+		if c.resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("status recebido %d, queria %d", c.resp.StatusCode, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("Teste Origin de outro site é recusado", func(t *testing.T) {
+		c := dialWS(t, ts, "/ws/echo?token=segredo", http.Header{"Origin": {"https://evil.example"}})
+		defer c.conn.Close()
+
+		if c.resp.StatusCode != http.StatusForbidden {
+			t.Errorf("status recebido %d, queria %d", c.resp.StatusCode, http.StatusForbidden)
+		}
+	})
+
+	t.Run("Teste upgrade atrás do timeoutWriter", func(t *testing.T) {
+		c := dialWS(t, ts, "/ws/timeout", nil)
+		defer c.conn.Close()
+
+		if c.resp.StatusCode != http.StatusSwitchingProtocols {
+			t.Fatalf("status recebido %d, queria %d", c.resp.StatusCode, http.StatusSwitchingProtocols)
+		}
+		c.writeFrame(true, false, wsOpText, []byte("oi"))
+		if op, _, payload := c.readFrame(); op != wsOpText || string(payload) != "oi" {
+			t.Errorf("eco recebido opcode %d %q", op, payload)
+		}
+	})
+
+	t.Run("Teste after middlewares não escrevem na conexão sequestrada", func(t *testing.T) {
+		hijacked := make(chan bool, 1)
+		reason := strings.Repeat("ç", 70)
+		server.RegisterRoutes([]RouteInfo{{
+			Path:   "/ws/fecha",
+			Method: MethodGet,
+			Handler: func(tc *TupaContext) error {
+				conn, err := tc.UpgradeWebSocket()
+				if err != nil {
+					return err
+				}
+				conn.Close(WSCloseNormal, reason)
+				return errors.New("erro depois do upgrade")
+			},
+			AfterMiddlewares: []MiddlewareFunc{func(next APIFunc) APIFunc {
+				return func(tc *TupaContext) error {
+					hijacked <- tc.Hijacked()
+					tc.Resp.WriteHeader(http.StatusTeapot)
+					tc.Resp.Write([]byte("HTTP/1.1 418 lixo"))
+					return next(tc)
+				}
+			}},
+		}})
+		c := dialWS(t, ts, "/ws/fecha", nil)
+		defer c.conn.Close()
+
+		op, _, payload := c.readFrame()
+		if op != wsOpClose || len(payload) > 125 || !utf8.Valid(payload[2:]) || !strings.HasPrefix(reason, string(payload[2:])) {
+			t.Errorf("close recebido opcode %d com %d bytes %q", op, len(payload), payload)
+		}
+		c.writeFrame(true, false, wsOpClose, closePayload(WSCloseNormal, ""))
+		if !<-hijacked {
+			t.Errorf("esperava o context marcado como sequestrado")
+		}
+		c.conn.SetReadDeadline(time.Now().Add(time.Second))
+		if rest, _ := io.ReadAll(c.br); len(rest) > 0 {
+			t.Errorf("conexão recebeu bytes depois do close: %q", rest)
+		}
+	})
+
+	t.Run("Teste request sem upgrade", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/ws/echo?token=segredo", nil)
+		rr := httptest.NewRecorder()
+		server.router.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("status recebido %d, queria %d", rr.Code, http.StatusBadRequest)
+		}
+	})
+}